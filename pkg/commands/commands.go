@@ -11,7 +11,6 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
 	"moul.io/assh/v2/pkg/config"
 	loggerpkg "moul.io/assh/v2/pkg/logger"
 	"moul.io/assh/v2/pkg/version"
@@ -24,6 +23,9 @@ var commands = []*cobra.Command{
 	configCommand,
 	socketsCommand,
 	wrapperCommand,
+	historyCommand,
+	statsCommand,
+	recordCommand,
 }
 
 // RootCmd is the root cobra command containing all commands for assh.
@@ -73,19 +75,52 @@ func init() {
 }
 
 func initLogging(debug bool, verbose bool) error {
-	config := zap.NewDevelopmentConfig()
-	config.Level.SetLevel(loggerpkg.MustLogLevel(debug, verbose))
-	if !debug {
-		config.DisableStacktrace = true
-		config.DisableCaller = true
-		config.EncoderConfig.TimeKey = ""
-		config.EncoderConfig.NameKey = ""
+	logging, err := loggingConfig()
+	if err != nil {
+		return errors.Wrap(err, "failed to read the logging configuration")
+	}
+	if err := logging.Validate(); err != nil {
+		return errors.Wrap(err, "invalid logging configuration")
+	}
+	// the configured level is the base, the flags and the parent ssh process can only make assh more verbose
+	level, _, err := logging.ParsedLevel()
+	if err != nil {
+		return errors.Wrap(err, "invalid logging configuration")
+	}
+	options := loggerpkg.Options{
+		Level:      loggerpkg.BaseLogLevel(level, debug, verbose),
+		Debug:      debug,
+		JSON:       logging.IsJSON(),
+		File:       logging.File,
+		MaxBackups: logging.MaxBackupsCount(),
+	}
+	if options.MaxSize, err = logging.MaxSizeBytes(); err != nil {
+		return errors.Wrap(err, "invalid logging configuration")
+	}
+	if options.Levels, err = logging.PackageLevels(); err != nil {
+		return errors.Wrap(err, "invalid logging configuration")
 	}
-	config.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
-	l, err := config.Build()
+	if options.File != "" {
+		if options.File, err = utils.ExpandUser(options.File); err != nil {
+			return errors.Wrap(err, "invalid logging configuration")
+		}
+	}
+
+	l, err := loggerpkg.New(options)
 	if err != nil {
 		return errors.Wrap(err, "failed to initialize logger")
 	}
 	zap.ReplaceGlobals(l)
 	return nil
 }
+
+// loggingConfig returns the logging section of the configuration file and the ASSH_LOG_* environment variables,
+// the hosts are only loaded by the commands
+func loggingConfig() (*config.LoggingConfig, error) {
+	logging, err := config.OpenLogging(viper.GetString("config"))
+	if err != nil {
+		return nil, err
+	}
+	logging.ApplyEnv()
+	return logging, nil
+}
@@ -0,0 +1,47 @@
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestInitLogging(t *testing.T) {
+	Convey("Testing initLogging()", t, func() {
+		defer zap.ReplaceGlobals(zap.L())
+		defer viper.Set("config", viper.GetString("config"))
+
+		dir, err := ioutil.TempDir("", "assh-logging")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		configFile := filepath.Join(dir, "assh.yml")
+		So(ioutil.WriteFile(configFile, []byte("logging:\n  Level: error\n"), 0600), ShouldBeNil)
+		viper.Set("config", configFile)
+
+		// the configured level is the base, the flags can only lower it
+		So(initLogging(false, false), ShouldBeNil)
+		So(zap.L().Core().Enabled(zapcore.WarnLevel), ShouldBeFalse)
+		So(zap.L().Core().Enabled(zapcore.ErrorLevel), ShouldBeTrue)
+		So(initLogging(false, true), ShouldBeNil)
+		So(zap.L().Core().Enabled(zapcore.InfoLevel), ShouldBeTrue)
+		So(zap.L().Core().Enabled(zapcore.DebugLevel), ShouldBeFalse)
+		So(initLogging(true, false), ShouldBeNil)
+		So(zap.L().Core().Enabled(zapcore.DebugLevel), ShouldBeTrue)
+
+		So(os.Setenv("ASSH_LOG_LEVEL", "info"), ShouldBeNil)
+		defer os.Unsetenv("ASSH_LOG_LEVEL")
+		So(initLogging(false, false), ShouldBeNil)
+		So(zap.L().Core().Enabled(zapcore.InfoLevel), ShouldBeTrue)
+		So(os.Unsetenv("ASSH_LOG_LEVEL"), ShouldBeNil)
+
+		// the errors of the logging section are reported
+		So(ioutil.WriteFile(configFile, []byte("logging: [\n"), 0600), ShouldBeNil)
+		So(initLogging(false, false), ShouldNotBeNil)
+	})
+}
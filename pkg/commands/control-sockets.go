@@ -1,9 +1,17 @@
 package commands
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
 	"time"
 
 	units "github.com/docker/go-units"
@@ -27,22 +35,52 @@ var listSocketsCommand = &cobra.Command{
 }
 
 var flushSocketsCommand = &cobra.Command{
-	Use:   "flush",
+	Use:   "flush [host...]",
 	Short: "Close control sockets",
 	RunE:  runFlushSocketsCommand,
 }
 
+var gcSocketsCommand = &cobra.Command{
+	Use:   "gc",
+	Short: "Close idle, orphaned and unconfigured control sockets",
+	RunE:  runGCSocketsCommand,
+}
+
 var masterSocketCommand = &cobra.Command{
-	Use:   "master",
-	Short: "Open a master control socket",
+	Use:   "master [host...]",
+	Short: "Open master control sockets",
 	RunE:  runMasterSocketCommand,
 }
 
 // nolint:gochecknoinits
 func init() {
+	listSocketsCommand.Flags().BoolP("json", "", false, "Print sockets as JSON")
+
+	flushSocketsCommand.Flags().StringP("selector", "l", "", "Only close sockets of hosts matching a label selector (e.g. env=prod,role!=db)")
+	flushSocketsCommand.Flags().DurationP("older-than", "", 0, "Only close sockets older than a duration")
+	flushSocketsCommand.Flags().BoolP("dry-run", "", false, "Print the sockets that would be closed")
+
+	gcSocketsCommand.Flags().BoolP("watch", "w", false, "Keep running and collect sockets periodically")
+	gcSocketsCommand.Flags().DurationP("interval", "", 0, "Delay between two collections in watch mode (overrides sockets.GCInterval)")
+	gcSocketsCommand.Flags().DurationP("idle-timeout", "", 0, "Close masters without sessions for this duration (overrides sockets.IdleTimeout)")
+	gcSocketsCommand.Flags().BoolP("dry-run", "", false, "Print the sockets that would be closed")
+
+	masterSocketCommand.Flags().StringP("selector", "l", "", "Open masters for the hosts matching a label selector (e.g. env=prod)")
+	masterSocketCommand.Flags().DurationP("timeout", "", 30*time.Second, "Maximum duration to open a master for a host")
+	masterSocketCommand.Flags().IntP("parallel", "", 16, "Maximum amount of masters opened concurrently")
+
 	socketsCommand.AddCommand(listSocketsCommand)
 	socketsCommand.AddCommand(flushSocketsCommand)
 	socketsCommand.AddCommand(masterSocketCommand)
+	socketsCommand.AddCommand(gcSocketsCommand)
+}
+
+// socketStatus is a control socket status mapped back to its configured host
+type socketStatus struct {
+	controlsockets.Status
+	Host string `json:"host,omitempty"`
+	User string `json:"user,omitempty"`
+	Port string `json:"port,omitempty"`
 }
 
 func runListSocketsCommand(cmd *cobra.Command, args []string) error {
@@ -61,42 +99,279 @@ func runListSocketsCommand(cmd *cobra.Command, args []string) error {
 		return errors.Wrap(err, "failed to lookup control path")
 	}
 
-	if len(activeSockets) == 0 {
+	hosts := controlPathHosts(conf)
+	statuses := []socketStatus{}
+	for _, socket := range activeSockets {
+		host, remote := socketHost(conf, hosts, socket)
+		if host == "" {
+			host = remote.Host
+		}
+		status := socketStatus{
+			Status: socket.Status(),
+			Host:   host,
+			User:   remote.User,
+			Port:   remote.Port,
+		}
+		if status.Error != "" {
+			logger().Debug("failed to check control socket", zap.String("path", socket.Path()), zap.String("error", status.Error))
+		}
+		statuses = append(statuses, status)
+	}
+
+	if jsonOutput, _ := cmd.Flags().GetBool("json"); jsonOutput {
+		out, err := json.MarshalIndent(statuses, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal sockets")
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	if len(statuses) == 0 {
 		fmt.Println("No active control sockets.")
 		return nil
 	}
 
-	fmt.Printf("%d active control sockets in %q:\n\n", len(activeSockets), controlPath)
+	fmt.Printf("%d active control sockets in %q:\n\n", len(statuses), controlPath)
 	now := time.Now().UTC()
-	for _, socket := range activeSockets {
-		createdAt, err := socket.CreatedAt()
-		if err != nil {
-			logger().Warn("failed to retrieve socket creation date", zap.Error(err))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "PATH\tHOST\tUSER\tPORT\tPID\tAGE\tSTATUS\tSESSIONS")
+	orDash := func(value string) string {
+		if value == "" {
+			return "-"
+		}
+		return value
+	}
+	for _, status := range statuses {
+		pid, state, sessions := "-", "unknown", "-"
+		switch {
+		case status.Alive:
+			pid, state = strconv.Itoa(status.MasterPID), "alive"
+		case status.Stale:
+			state = "stale"
+		}
+		if status.ActiveConnections >= 0 {
+			sessions = strconv.Itoa(status.ActiveConnections)
 		}
+		_, _ = fmt.Fprintf(
+			w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			status.RelativePath, orDash(status.Host), orDash(status.User), orDash(status.Port),
+			pid, units.HumanDuration(now.Sub(status.CreatedAt)), state, sessions,
+		)
+	}
+	return w.Flush()
+}
 
-		fmt.Printf("- %s (%v)\n", socket.RelativePath(), units.HumanDuration(now.Sub(createdAt)))
+// socketHost returns the name of the configured host of a control socket and its destination,
+// the name is empty if the socket does not belong to a configured host
+func socketHost(conf *config.Config, hosts map[string]string, socket controlsockets.ControlSocket) (string, controlsockets.RemoteTokens) {
+	remote, _ := socket.RemoteTokens()
+	name := hosts[socket.Path()]
+	if name == "" && remote.Host != "" {
+		// hosts matching a wildcard entry are missing from the map
+		if _, err := conf.GetHost(remote.Host); err == nil {
+			name = remote.Host
+		}
+	}
+	if name == "" {
+		return "", remote
 	}
 
-	return nil
+	tokens := conf.GetHostSafe(name).ControlPathTokens().Remote
+	if remote.Host == "" {
+		remote.Host = name
+	}
+	if remote.User == "" {
+		remote.User = tokens.User
+	}
+	if remote.Port == "" {
+		remote.Port = tokens.Port
+	}
+	return name, remote
+}
+
+// controlPathHosts maps the expanded ControlPath of the configured hosts to their names
+func controlPathHosts(conf *config.Config) map[string]string {
+	hosts := map[string]string{}
+	for _, host := range conf.Hosts.SortedList() {
+		for _, name := range append([]string{host.Name()}, host.Aliases...) {
+			if strings.ContainsAny(name, "*?[") {
+				continue
+			}
+			computed := conf.GetHostSafe(name)
+			if computed.ControlPath == "" || computed.ControlPath == "none" {
+				continue
+			}
+			controlPath := computed.ExpandControlPath()
+			if _, found := hosts[controlPath]; !found {
+				hosts[controlPath] = name
+			}
+		}
+	}
+	return hosts
+}
+
+// masterResult is the outcome of opening a master control socket for a host
+type masterResult struct {
+	host     string
+	state    string
+	pid      int
+	duration time.Duration
+	err      error
 }
 
 func runMasterSocketCommand(cmd *cobra.Command, args []string) error {
-	if len(args) < 1 {
-		return errors.New("assh: \"sockets master\" requires 1 argument. See 'assh sockets master --help'")
+	conf, err := config.Open(viper.GetString("config"))
+	if err != nil {
+		return errors.Wrap(err, "failed to open config")
+	}
+
+	selectorFlag, _ := cmd.Flags().GetString("selector")
+	if len(args) < 1 && selectorFlag == "" {
+		return errors.New("assh: \"sockets master\" requires at least 1 host or a selector. See 'assh sockets master --help'")
+	}
+	selector, err := config.ParseSelector(selectorFlag)
+	if err != nil {
+		return err
+	}
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	parallel, _ := cmd.Flags().GetInt("parallel")
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	targets := args
+	if selectorFlag != "" {
+		hosts, err := conf.SelectHosts(selector)
+		if err != nil {
+			return errors.Wrap(err, "failed to select hosts")
+		}
+		for _, host := range hosts {
+			targets = append(targets, host.Name())
+		}
+	}
+	targets = uniqueStrings(targets)
+	if len(targets) == 0 {
+		fmt.Printf("No host matching %q.\n", selector.String())
+		return nil
 	}
 
-	for _, target := range args {
-		logger().Debug("Opening master control socket", zap.String("host", target))
+	results := make([]masterResult, len(targets))
+	semaphore := make(chan struct{}, parallel)
+	waitGroup := sync.WaitGroup{}
+	for idx, target := range targets {
+		waitGroup.Add(1)
+		go func(idx int, target string) {
+			defer waitGroup.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			results[idx] = openMaster(conf, target, timeout)
+		}(idx, target)
+	}
+	waitGroup.Wait()
 
-		cmd := exec.Command("ssh", target, "-M", "-N", "-f") // #nosec
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		return cmd.Run()
+	failures := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "HOST\tSTATUS\tPID\tDURATION\tERROR")
+	for _, result := range results {
+		pid, message := "-", ""
+		if result.pid > 0 {
+			pid = strconv.Itoa(result.pid)
+		}
+		if result.err != nil {
+			failures++
+			message = result.err.Error()
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", result.host, result.state, pid, result.duration.Round(time.Millisecond), message)
+	}
+	if err := w.Flush(); err != nil {
+		return err
 	}
 
+	if failures > 0 {
+		return fmt.Errorf("failed to open %d/%d master control sockets", failures, len(targets))
+	}
 	return nil
 }
 
+// openMaster starts a background master ssh process for a host, unless a live master already exists
+func openMaster(conf *config.Config, target string, timeout time.Duration) masterResult {
+	logger().Debug("Opening master control socket", zap.String("host", target))
+	start := time.Now()
+	result := masterResult{host: target}
+
+	host := conf.GetHostSafe(target)
+	if host.ControlPath == "" || host.ControlPath == "none" {
+		result.state = "skipped"
+		result.err = errors.New("ControlPath is not configured")
+		return result
+	}
+	if err := prepareHostControlPath(host.Clone()); err != nil {
+		result.state = "failed"
+		result.err = errors.Wrap(err, "failed to prepare host control-path")
+		return result
+	}
+
+	socket := controlsockets.NewControlSocket(host.ExpandControlPath())
+	if pid, err := socket.MasterPID(); err == nil {
+		result.state, result.pid = "already open", pid
+		result.duration = time.Since(start)
+		return result
+	}
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// the forked master process inherits stderr, using a file instead of a pipe
+	// prevents from waiting for the master to exit
+	stderr, err := ioutil.TempFile("", "assh-master-")
+	if err != nil {
+		result.state, result.err = "failed", err
+		return result
+	}
+	defer os.Remove(stderr.Name())
+	defer stderr.Close()
+
+	// BatchMode prevents concurrent password prompts from mixing on the terminal
+	cmd := exec.CommandContext(ctx, "ssh", "-o", "BatchMode=yes", "-M", "-N", "-f", target) // #nosec
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	result.duration = time.Since(start)
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		result.state, result.err = "timeout", fmt.Errorf("no master after %s", timeout)
+	case err != nil:
+		output, _ := ioutil.ReadFile(stderr.Name())
+		if message := strings.TrimSpace(string(output)); message != "" {
+			err = errors.New(strings.ReplaceAll(message, "\n", " "))
+		}
+		result.state, result.err = "failed", err
+	default:
+		result.state = "opened"
+		if pid, err := socket.MasterPID(); err == nil {
+			result.pid = pid
+		}
+	}
+	return result
+}
+
+// uniqueStrings removes the duplicates of a list, keeping the first occurrences
+func uniqueStrings(input []string) []string {
+	seen := map[string]bool{}
+	output := []string{}
+	for _, item := range input {
+		if !seen[item] {
+			seen[item] = true
+			output = append(output, item)
+		}
+	}
+	return output
+}
+
 func runFlushSocketsCommand(cmd *cobra.Command, args []string) error {
 	conf, err := config.Open(viper.GetString("config"))
 	if err != nil {
@@ -108,6 +383,14 @@ func runFlushSocketsCommand(cmd *cobra.Command, args []string) error {
 		return errors.New("missing ControlPath in the configuration; Sockets features are disabled")
 	}
 
+	selectorFlag, _ := cmd.Flags().GetString("selector")
+	selector, err := config.ParseSelector(selectorFlag)
+	if err != nil {
+		return err
+	}
+	olderThan, _ := cmd.Flags().GetDuration("older-than")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
 	activeSockets, err := controlsockets.LookupControlPathDir(controlPath)
 	if err != nil {
 		return errors.Wrap(err, "failed to lookup control path")
@@ -118,18 +401,238 @@ func runFlushSocketsCommand(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	success := 0
+	hosts := controlPathHosts(conf)
+	closed, removed := 0, 0
 	for _, socket := range activeSockets {
-		if err := os.Remove(socket.Path()); err != nil {
-			logger().Warn("Failed to close control socket", zap.String("path", socket.Path()), zap.Error(err))
-		} else {
-			success++
+		host, _ := socketHost(conf, hosts, socket)
+		if !socketMatches(conf, host, args, selector) {
+			continue
+		}
+		if olderThan > 0 {
+			createdAt, err := socket.CreatedAt()
+			if err != nil || time.Since(createdAt) < olderThan {
+				continue
+			}
+		}
+
+		name := socket.RelativePath()
+		if host != "" {
+			name = fmt.Sprintf("%s (%s)", name, host)
+		}
+
+		status := socket.Status()
+		switch {
+		case status.Alive:
+			if dryRun {
+				fmt.Printf("Would close %s, master pid %d\n", name, status.MasterPID)
+			} else if err := socket.Terminate(); err != nil {
+				logger().Warn("Failed to close control socket", zap.String("path", socket.Path()), zap.Error(err))
+				continue
+			}
+			closed++
+		case status.Stale:
+			if dryRun {
+				fmt.Printf("Would remove stale %s\n", name)
+			} else if err := socket.Remove(); err != nil {
+				logger().Warn("Failed to remove stale control socket", zap.String("path", socket.Path()), zap.Error(err))
+				continue
+			}
+			removed++
+		default:
+			logger().Warn("Skipping control socket in an unknown state", zap.String("path", socket.Path()), zap.String("error", status.Error))
+		}
+	}
+
+	switch {
+	case closed == 0 && removed == 0:
+		fmt.Println("No matching control sockets.")
+	case dryRun:
+		fmt.Printf("Would close %d control sockets and remove %d stale control sockets.\n", closed, removed)
+	default:
+		if closed > 0 {
+			fmt.Printf("Closed %d control sockets.\n", closed)
+		}
+		if removed > 0 {
+			fmt.Printf("Removed %d stale control sockets.\n", removed)
+		}
+	}
+
+	return nil
+}
+
+// socketMatches returns true if the host of a control socket matches one of the patterns and the selector,
+// sockets that cannot be mapped to a configured host only match when no filter is given
+func socketMatches(conf *config.Config, host string, patterns []string, selector config.Selector) bool {
+	if len(patterns) == 0 && len(selector) == 0 {
+		return true
+	}
+	if host == "" {
+		return false
+	}
+
+	if len(patterns) > 0 {
+		matched := false
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, host); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	return len(selector) == 0 || selector.Matches(conf.GetHostSafe(host))
+}
+
+func runGCSocketsCommand(cmd *cobra.Command, args []string) error {
+	watch, _ := cmd.Flags().GetBool("watch")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	collector := socketsCollector{
+		dryRun:     dryRun,
+		lastActive: map[string]time.Time{},
+	}
+
+	for {
+		conf, err := config.Open(viper.GetString("config"))
+		if err != nil {
+			return errors.Wrap(err, "failed to open config")
+		}
+
+		interval, err := conf.Sockets.GCIntervalDuration()
+		if err != nil {
+			return err
+		}
+		if flagInterval, _ := cmd.Flags().GetDuration("interval"); flagInterval > 0 {
+			interval = flagInterval
+		}
+		collector.idleTimeout, err = conf.Sockets.IdleTimeoutDuration()
+		if err != nil {
+			return err
+		}
+		if flagIdleTimeout, _ := cmd.Flags().GetDuration("idle-timeout"); flagIdleTimeout > 0 {
+			collector.idleTimeout = flagIdleTimeout
 		}
+		collector.closeRemovedHosts = conf.Sockets != nil && conf.Sockets.CloseRemovedHosts
+
+		if err := collector.collect(conf); err != nil {
+			if !watch {
+				return err
+			}
+			logger().Warn("Failed to collect control sockets", zap.Error(err))
+		}
+
+		if !watch {
+			return nil
+		}
+		// the configuration is reloaded at each iteration to detect the removed hosts
+		time.Sleep(interval)
+	}
+}
+
+// socketsCollector closes the control sockets that are not needed anymore
+type socketsCollector struct {
+	idleTimeout       time.Duration
+	closeRemovedHosts bool
+	dryRun            bool
+
+	// lastActive is the last time a master was seen with sessions, by socket path
+	lastActive map[string]time.Time
+	// warnedUncounted is true once the user knows that idle masters cannot be detected
+	warnedUncounted bool
+}
+
+// hostRemoved returns true when the host parsed from the path of a socket is not configured anymore, the
+// sockets whose path does not tell the host, such as with a %C ControlPath, are never considered removed
+func hostRemoved(conf *config.Config, host string, remote controlsockets.RemoteTokens) bool {
+	if host != "" || remote.Host == "" {
+		return false
+	}
+	_, err := conf.GetHost(remote.Host)
+	return err != nil
+}
+
+// collect scans the control path once
+func (c *socketsCollector) collect(conf *config.Config) error {
+	controlPath := conf.Defaults.ControlPath
+	if controlPath == "" {
+		return errors.New("missing ControlPath in the configuration; Sockets features are disabled")
 	}
 
-	if success > 0 {
-		fmt.Printf("Closed %d control sockets.\n", success)
+	activeSockets, err := controlsockets.LookupControlPathDir(controlPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to lookup control path")
 	}
 
+	now := time.Now()
+	hosts := controlPathHosts(conf)
+	seen := map[string]bool{}
+	for _, socket := range activeSockets {
+		seen[socket.Path()] = true
+		host, remote := socketHost(conf, hosts, socket)
+		status := socket.Status()
+
+		name := socket.RelativePath()
+		if host != "" {
+			name = fmt.Sprintf("%s (%s)", name, host)
+		}
+
+		if status.Stale {
+			c.close(socket, name, "orphaned socket", "remove", socket.Remove)
+			continue
+		}
+		if !status.Alive {
+			logger().Debug("Skipping control socket in an unknown state", zap.String("path", socket.Path()), zap.String("error", status.Error))
+			continue
+		}
+
+		if status.ActiveConnections > 0 {
+			c.lastActive[socket.Path()] = now
+		}
+		if c.idleTimeout > 0 && status.ActiveConnections < 0 && !c.warnedUncounted {
+			logger().Warn("The sessions of the control sockets cannot be counted on this system, IdleTimeout is ignored")
+			c.warnedUncounted = true
+		}
+
+		switch {
+		case c.closeRemovedHosts && hostRemoved(conf, host, remote):
+			c.close(socket, name, "host not configured", "close", socket.Terminate)
+		case c.idleTimeout > 0 && status.ActiveConnections == 0:
+			// a master is idle since its creation or since the last time it was seen with sessions
+			idleSince := status.CreatedAt
+			if lastActive, found := c.lastActive[socket.Path()]; found && lastActive.After(idleSince) {
+				idleSince = lastActive
+			}
+			if idle := now.Sub(idleSince); idle >= c.idleTimeout {
+				c.close(socket, name, fmt.Sprintf("idle for %s", units.HumanDuration(idle)), "close", socket.Terminate)
+			}
+		}
+	}
+
+	for path := range c.lastActive {
+		if !seen[path] {
+			delete(c.lastActive, path)
+		}
+	}
 	return nil
 }
+
+// close terminates the master or removes the socket file, depending on the action
+func (c *socketsCollector) close(socket controlsockets.ControlSocket, name, reason, action string, closeFunc func() error) {
+	if c.dryRun {
+		fmt.Printf("Would %s %s: %s\n", action, name, reason)
+		return
+	}
+	if err := closeFunc(); err != nil {
+		logger().Warn("Failed to "+action+" control socket", zap.String("path", socket.Path()), zap.Error(err))
+		return
+	}
+	delete(c.lastActive, socket.Path())
+	if action == "remove" {
+		fmt.Printf("Removed %s: %s\n", name, reason)
+	} else {
+		fmt.Printf("Closed %s: %s\n", name, reason)
+	}
+}
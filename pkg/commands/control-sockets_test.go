@@ -0,0 +1,38 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"moul.io/assh/v2/pkg/config"
+	"moul.io/assh/v2/pkg/controlsockets"
+)
+
+func TestHostRemoved(t *testing.T) {
+	Convey("Testing hostRemoved()", t, func() {
+		conf := config.New()
+		So(conf.LoadConfig(strings.NewReader(`
+hosts:
+  web:
+    HostName: 1.2.3.4
+  "*.example.com":
+    User: root
+`)), ShouldBeNil)
+
+		for _, tt := range []struct {
+			host     string
+			remote   controlsockets.RemoteTokens
+			expected bool
+		}{
+			{"web", controlsockets.RemoteTokens{Host: "web"}, false},
+			// a wildcard entry still matches
+			{"", controlsockets.RemoteTokens{Host: "db.example.com"}, false},
+			// %C: the host cannot be found from the path
+			{"", controlsockets.RemoteTokens{Hash: "b46b3438e82fadbdb149035d752ea255272597bf"}, false},
+			{"", controlsockets.RemoteTokens{Host: "old", Port: "22"}, true},
+		} {
+			So(hostRemoved(conf, tt.host, tt.remote), ShouldEqual, tt.expected)
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"moul.io/assh/v2/pkg/config"
+	"moul.io/assh/v2/pkg/history"
+)
+
+var historyCommand = &cobra.Command{
+	Use:     "history",
+	Short:   "List the connections of assh connect",
+	Example: "  assh history --host '*.prod' --since 24h --failed",
+	RunE:    runHistoryCommand,
+}
+
+var statsCommand = &cobra.Command{
+	Use:     "stats",
+	Short:   "Aggregate the connection history by host, week or gateway",
+	Example: "  assh stats --by gateway --since 720h",
+	RunE:    runStatsCommand,
+}
+
+// nolint:gochecknoinits
+func init() {
+	for _, cmd := range []*cobra.Command{historyCommand, statsCommand} {
+		cmd.Flags().StringP("host", "", "", "Only include the hosts matching a shell pattern (e.g. '*.prod')")
+		cmd.Flags().StringP("gateway", "", "", "Only include the connections through a gateway ('direct' for the direct connections)")
+		cmd.Flags().StringP("since", "", "", "Only include the connections started since a duration (e.g. 24h) or a date (e.g. 2020-01-31)")
+		cmd.Flags().StringP("until", "", "", "Only include the connections started before a duration or a date")
+		cmd.Flags().BoolP("failed", "", false, "Only include the failed connections")
+		cmd.Flags().BoolP("json", "", false, "Print as JSON")
+	}
+	historyCommand.Flags().IntP("limit", "n", 50, "Print the last n connections, 0 for all")
+	statsCommand.Flags().StringP("by", "", "host", "Aggregate by 'host', 'week' or 'gateway'")
+}
+
+func openHistory() (*history.Store, error) {
+	conf, err := config.Open(viper.GetString("config"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load config")
+	}
+	if !conf.HistoryEnabled() {
+		return nil, errors.New("the history is disabled by 'ASSHHistoryFile: none'")
+	}
+	store, err := history.Open(conf.ASSHHistoryFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open the history")
+	}
+	return store, nil
+}
+
+// historyFilter builds a filter from the flags of the history and stats commands
+func historyFilter(cmd *cobra.Command, now time.Time) (history.Filter, error) {
+	filter := history.Filter{}
+	filter.Host, _ = cmd.Flags().GetString("host")
+	filter.Gateway, _ = cmd.Flags().GetString("gateway")
+	filter.Failed, _ = cmd.Flags().GetBool("failed")
+	for name, value := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		input, _ := cmd.Flags().GetString(name)
+		parsed, err := parseHistoryTime(input, now)
+		if err != nil {
+			return filter, errors.Wrapf(err, "invalid value for --%s", name)
+		}
+		*value = parsed
+	}
+	return filter, nil
+}
+
+// parseHistoryTime parses a duration before now or a date, an empty input is the zero time
+func parseHistoryTime(input string, now time.Time) (time.Time, error) {
+	if input == "" {
+		return time.Time{}, nil
+	}
+	if duration, err := time.ParseDuration(input); err == nil {
+		return now.Add(-duration), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02"} {
+		if parsed, err := time.ParseInLocation(layout, input, time.Local); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is neither a duration nor a date", input)
+}
+
+func runHistoryCommand(cmd *cobra.Command, args []string) error {
+	store, err := openHistory()
+	if err != nil {
+		return err
+	}
+	filter, err := historyFilter(cmd, time.Now())
+	if err != nil {
+		return err
+	}
+	entries, err := store.Entries(filter)
+	if err != nil {
+		return errors.Wrap(err, "failed to read the history")
+	}
+	if limit, _ := cmd.Flags().GetInt("limit"); limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+
+	if jsonOutput, _ := cmd.Flags().GetBool("json"); jsonOutput {
+		out, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "STARTED\tHOST\tGATEWAY\tDURATION\tSENT\tRECEIVED\tRESULT")
+	for _, entry := range entries {
+		gateway, result := "-", "ok"
+		if entry.Gateway != "" {
+			gateway = entry.Gateway
+		}
+		if !entry.Success {
+			result = fmt.Sprintf("%s: %s", entry.ErrorClass, entry.Error)
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			entry.StartedAt.Local().Format("2006-01-02 15:04:05"),
+			entry.Host,
+			gateway,
+			entry.Duration.Round(time.Millisecond),
+			humanize.Bytes(entry.SentBytes),
+			humanize.Bytes(entry.ReceivedBytes),
+			result,
+		)
+	}
+	return w.Flush()
+}
+
+func runStatsCommand(cmd *cobra.Command, args []string) error {
+	store, err := openHistory()
+	if err != nil {
+		return err
+	}
+	filter, err := historyFilter(cmd, time.Now())
+	if err != nil {
+		return err
+	}
+	entries, err := store.Entries(filter)
+	if err != nil {
+		return errors.Wrap(err, "failed to read the history")
+	}
+	by, _ := cmd.Flags().GetString("by")
+	aggregates, err := history.AggregateBy(entries, by)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput, _ := cmd.Flags().GetBool("json"); jsonOutput {
+		out, err := json.MarshalIndent(aggregates, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "%s\tATTEMPTS\tFAILURES\tFAILURE RATE\tSENT\tRECEIVED\tTIME\tLAST SEEN\n", map[string]string{
+		"host": "HOST", "week": "WEEK", "gateway": "GATEWAY",
+	}[by])
+	for _, aggregate := range aggregates {
+		_, _ = fmt.Fprintf(w, "%s\t%d\t%d\t%.1f%%\t%s\t%s\t%s\t%s\n",
+			aggregate.Key,
+			aggregate.Attempts,
+			aggregate.Failures,
+			aggregate.FailureRate,
+			humanize.Bytes(aggregate.SentBytes),
+			humanize.Bytes(aggregate.ReceivedBytes),
+			aggregate.Duration.Round(time.Second),
+			humanize.Time(aggregate.LastSeen),
+		)
+	}
+	return w.Flush()
+}
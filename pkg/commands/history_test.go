@@ -0,0 +1,70 @@
+package commands
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"moul.io/assh/v2/pkg/config"
+)
+
+func TestParseHistoryTime(t *testing.T) {
+	Convey("Testing parseHistoryTime()", t, func() {
+		now := time.Date(2020, 1, 31, 12, 0, 0, 0, time.Local)
+		parsed, err := parseHistoryTime("", now)
+		So(err, ShouldBeNil)
+		So(parsed.IsZero(), ShouldBeTrue)
+
+		parsed, err = parseHistoryTime("24h", now)
+		So(err, ShouldBeNil)
+		So(parsed, ShouldEqual, now.Add(-24*time.Hour))
+
+		parsed, err = parseHistoryTime("2020-01-02", now)
+		So(err, ShouldBeNil)
+		So(parsed, ShouldEqual, time.Date(2020, 1, 2, 0, 0, 0, 0, time.Local))
+
+		parsed, err = parseHistoryTime("2020-01-02 15:04", now)
+		So(err, ShouldBeNil)
+		So(parsed, ShouldEqual, time.Date(2020, 1, 2, 15, 4, 0, 0, time.Local))
+
+		_, err = parseHistoryTime("yesterday", now)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestHistoryEntry(t *testing.T) {
+	Convey("Testing historyEntry()", t, func() {
+		host := config.NewHost("web")
+		host.HostName = "10.0.0.1"
+		host.Port = "22"
+		start := time.Date(2020, 1, 31, 12, 0, 0, 0, time.UTC)
+
+		// a connection of assh
+		stats := &ConnectionStats{
+			ConnectedAt:    start.Add(time.Second),
+			DisconnectedAt: start.Add(time.Minute),
+			SentBytes:      10,
+			ReceivedBytes:  20,
+			GatewayPath:    []string{"web"},
+		}
+		entry := historyEntry(host, "direct", start, stats, nil, start.Add(time.Hour))
+		So(entry.Host, ShouldEqual, "web")
+		So(entry.HostName, ShouldEqual, "10.0.0.1")
+		So(entry.Success, ShouldBeTrue)
+		So(entry.Connected(), ShouldBeTrue)
+		So(entry.EndedAt, ShouldEqual, start.Add(time.Minute))
+		So(entry.Duration, ShouldEqual, time.Minute)
+		So(entry.SentBytes, ShouldEqual, 10)
+		So(entry.ReceivedBytes, ShouldEqual, 20)
+
+		// a connection through a gateway
+		entry = historyEntry(host, "bastion", start, nil, errors.New("exit status 255"), start.Add(time.Second))
+		So(entry.Success, ShouldBeFalse)
+		So(entry.Connected(), ShouldBeFalse)
+		So(entry.Error, ShouldEqual, "exit status 255")
+		So(entry.ErrorClass, ShouldEqual, "other")
+		So(entry.GatewayPath, ShouldResemble, []string{"bastion", "web"})
+		So(entry.Duration, ShouldEqual, time.Second)
+	})
+}
@@ -0,0 +1,156 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"moul.io/assh/v2/pkg/config"
+)
+
+// pingFleetOptions configures the probes sent to each host of a fleet
+type pingFleetOptions struct {
+	mode     string
+	port     int           // overrides the port of the hosts if set
+	count    uint          // probes per host, at least 1
+	wait     time.Duration // between the probes of a host
+	timeout  time.Duration // per probe
+	parallel int
+}
+
+// pingFleetResult is the reachability of a host of a fleet
+type pingFleetResult struct {
+	Type       string `json:"type"`
+	Host       string `json:"host"`
+	Up         bool   `json:"up"`
+	Gateway    string `json:"gateway,omitempty"`
+	Error      string `json:"error,omitempty"`
+	ErrorClass string `json:"error_class,omitempty"`
+	pingSummary
+}
+
+// pingFleet probes the hosts concurrently, and returns the results sorted by host name
+func pingFleet(conf *config.Config, targets []string, options pingFleetOptions) []pingFleetResult {
+	if options.parallel < 1 {
+		options.parallel = 1
+	}
+	if options.count < 1 {
+		options.count = 1
+	}
+
+	results := make([]pingFleetResult, len(targets))
+	semaphore := make(chan struct{}, options.parallel)
+	waitGroup := sync.WaitGroup{}
+	for idx, target := range targets {
+		waitGroup.Add(1)
+		go func(idx int, target string) {
+			defer waitGroup.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			results[idx] = pingFleetHost(conf, target, options)
+		}(idx, target)
+	}
+	waitGroup.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Host < results[j].Host })
+	return results
+}
+
+// pingFleetHost sends the probes to a host, a host is up if at least one probe succeeded; the probes of a
+// host share a deadline, so a host whose gateways or ProxyCommand hang does not hold a slot of the fleet
+func pingFleetHost(conf *config.Config, target string, options pingFleetOptions) pingFleetResult {
+	result := pingFleetResult{Type: "host", Host: target}
+	stats := &pingStats{}
+	host, err := computeHost(target, options.port, conf)
+	if err != nil {
+		result.Error, result.ErrorClass = err.Error(), classifyPingError(err)
+		result.pingSummary = stats.Summary(target)
+		return result
+	}
+
+	hostTimeout := time.Duration(options.count)*options.timeout + time.Duration(options.count-1)*options.wait
+	ctx, cancel := context.WithTimeout(context.Background(), hostTimeout)
+	defer cancel()
+
+	type probe struct {
+		result *pingResult
+		err    error
+	}
+probes:
+	for seq := uint(0); seq < options.count; seq++ {
+		if seq > 0 {
+			select {
+			case <-time.After(options.wait):
+			case <-ctx.Done():
+				break probes
+			}
+		}
+		start := time.Now()
+		done := make(chan probe, 1)
+		go func() {
+			result, err := pingOnce(conf, host, options.mode, options.timeout)
+			done <- probe{result: result, err: err}
+		}()
+		var current probe
+		select {
+		case current = <-done:
+		case <-ctx.Done():
+			// the probe closes its connection by itself once finished
+			current.err = errors.Wrapf(pingTimeoutError{}, "no answer within %v", hostTimeout)
+		}
+		stats.Add(time.Since(start), current.err)
+		if current.err != nil {
+			result.Error, result.ErrorClass = current.err.Error(), classifyPingError(current.err)
+			continue
+		}
+		result.Gateway = current.result.Gateway
+	}
+	result.Up = stats.Received > 0
+	if result.Up {
+		// the host answered, the errors remain in the statistics
+		result.Error, result.ErrorClass = "", ""
+	}
+	result.pingSummary = stats.Summary(target)
+	return result
+}
+
+// pingFleetDown returns the amount of hosts that did not answer
+func pingFleetDown(results []pingFleetResult) int {
+	down := 0
+	for _, result := range results {
+		if !result.Up {
+			down++
+		}
+	}
+	return down
+}
+
+// printPingFleet prints a table of the results and a summary line
+func printPingFleet(results []pingFleetResult) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "HOST\tSTATUS\tLATENCY\tLOSS\tGATEWAY\tERROR")
+	for _, result := range results {
+		status, latency, gateway := "down", "-", "-"
+		if result.Up {
+			status, latency = "up", result.Avg.Round(time.Microsecond).String()
+		}
+		if result.Gateway != "" {
+			gateway = result.Gateway
+		}
+		message := result.ErrorClass
+		if result.Error != "" {
+			message = fmt.Sprintf("%s: %s", result.ErrorClass, result.Error)
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%.0f%%\t%s\t%s\n", result.Host, status, latency, result.Loss, gateway, message)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("\n%d hosts, %d up, %d down\n", len(results), len(results)-pingFleetDown(results), pingFleetDown(results))
+	return nil
+}
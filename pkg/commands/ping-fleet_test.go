@@ -0,0 +1,81 @@
+package commands
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/ssh"
+	"moul.io/assh/v2/pkg/config"
+)
+
+func TestPingFleet(t *testing.T) {
+	Convey("Testing pingFleet()", t, func() {
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		So(err, ShouldBeNil)
+		hostKey, err := ssh.NewSignerFromKey(privateKey)
+		So(err, ShouldBeNil)
+		serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+		serverConfig.AddHostKey(hostKey)
+		server := startSSHServer(t, serverConfig)
+		defer server.Close()
+
+		// a port closed right after being allocated refuses the connections
+		closed, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		So(closed.Close(), ShouldBeNil)
+
+		_, serverPort, _ := net.SplitHostPort(server.Addr().String())
+		_, closedPort, _ := net.SplitHostPort(closed.Addr().String())
+		conf := config.New()
+		So(conf.LoadConfig(strings.NewReader(fmt.Sprintf(`
+hosts:
+  web1:
+    HostName: 127.0.0.1
+    Port: %s
+    Labels:
+      env: prod
+  web2:
+    HostName: 127.0.0.1
+    Port: %s
+    Labels:
+      env: prod
+  dev1:
+    HostName: 127.0.0.1
+    Port: %s
+`, serverPort, closedPort, serverPort))), ShouldBeNil)
+
+		selector, err := config.ParseSelector("env=prod")
+		So(err, ShouldBeNil)
+		hosts, err := conf.SelectHosts(selector)
+		So(err, ShouldBeNil)
+		targets := []string{}
+		for _, host := range hosts {
+			targets = append(targets, host.Name())
+		}
+
+		results := pingFleet(conf, targets, pingFleetOptions{mode: pingModeBanner, count: 2, timeout: 5 * time.Second, parallel: 1})
+		So(len(results), ShouldEqual, 2)
+		So(results[0].Host, ShouldEqual, "web1")
+		So(results[0].Up, ShouldBeTrue)
+		So(results[0].Received, ShouldEqual, 2)
+		So(results[0].Error, ShouldEqual, "")
+		So(results[1].Host, ShouldEqual, "web2")
+		So(results[1].Up, ShouldBeFalse)
+		So(results[1].ErrorClass, ShouldEqual, pingErrorRefused)
+		So(results[1].Loss, ShouldEqual, 100)
+		So(pingFleetDown(results), ShouldEqual, 1)
+
+		// --port overrides the port of every host
+		port, err := strconv.Atoi(serverPort)
+		So(err, ShouldBeNil)
+		results = pingFleet(conf, targets, pingFleetOptions{mode: pingModeBanner, port: port, count: 1, timeout: 5 * time.Second, parallel: 2})
+		So(pingFleetDown(results), ShouldEqual, 0)
+	})
+}
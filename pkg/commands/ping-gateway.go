@@ -0,0 +1,587 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+	"sync"
+	"time"
+
+	shlex "github.com/flynn/go-shlex"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"moul.io/assh/v2/pkg/config"
+	"moul.io/assh/v2/pkg/utils"
+)
+
+// maxPingGatewayDepth prevents from looping on gateways configured with themselves as gateway
+const maxPingGatewayDepth = 8
+
+// pingHop is one step of the path to a host and the time it took to establish it
+type pingHop struct {
+	Name string        `json:"name"`
+	Via  string        `json:"via,omitempty"`
+	Time time.Duration `json:"time"`
+}
+
+func (h pingHop) String() string {
+	return fmt.Sprintf("%s:%v", h.Name, h.Time)
+}
+
+// pingPath is an established connection to a host and the hops used to reach it
+type pingPath struct {
+	conn    net.Conn
+	hops    []pingHop
+	gateway string
+	// piped is true when the connection is the standard input/output of a ProxyCommand,
+	// connecting the process does not mean that the host is reachable
+	piped   bool
+	closers []io.Closer
+}
+
+// Close closes the connection and the gateway clients
+func (p *pingPath) Close() error {
+	var err error
+	if p.conn != nil {
+		err = p.conn.Close()
+	}
+	for idx := len(p.closers) - 1; idx >= 0; idx-- {
+		_ = p.closers[idx].Close()
+	}
+	return err
+}
+
+// pingDial connects to a host like proxy() does: trying its gateways in order, through their own gateways,
+// or using its ProxyCommand
+func pingDial(conf *config.Config, host *config.Host, timeout time.Duration, depth int) (*pingPath, error) {
+	if depth > maxPingGatewayDepth {
+		return nil, errors.New("too many nested gateways")
+	}
+
+	if len(host.Gateways) == 0 {
+		return pingDialDirect(host, timeout)
+	}
+
+	errs := pingGatewaysError{}
+	for _, gateway := range host.Gateways {
+		var path *pingPath
+		var err error
+		if gateway == "direct" {
+			path, err = pingDialDirect(host, timeout)
+		} else {
+			path, err = pingDialGateway(conf, host, gateway, timeout, depth)
+		}
+		if err == nil {
+			path.gateway = gateway
+			return path, nil
+		}
+		logger().Debug("Failed to ping through gateway", zap.String("gateway", gateway), zap.Error(err))
+		errs.gateways = append(errs.gateways, gateway)
+		errs.errs = append(errs.errs, err)
+	}
+	return nil, errs
+}
+
+// pingGatewaysError lists the errors of each gateway, the last one is used to classify the failure
+type pingGatewaysError struct {
+	gateways []string
+	errs     []error
+}
+
+func (e pingGatewaysError) Error() string {
+	parts := make([]string, len(e.errs))
+	for idx, err := range e.errs {
+		parts[idx] = fmt.Sprintf("%s: %v", e.gateways[idx], err)
+	}
+	return fmt.Sprintf("no such available gateway (%s)", strings.Join(parts, ", "))
+}
+
+func (e pingGatewaysError) Unwrap() error {
+	if len(e.errs) == 0 {
+		return nil
+	}
+	return e.errs[len(e.errs)-1]
+}
+
+// pingDialDirect opens a TCP connection to the host, or runs its ProxyCommand
+func pingDialDirect(host *config.Host, timeout time.Duration) (*pingPath, error) {
+	host = host.Clone()
+	if err := hostPrepare(host, ""); err != nil {
+		return nil, errors.Wrap(err, "failed to prepare host")
+	}
+
+	start := time.Now()
+	if host.ProxyCommand != "" {
+		conn, err := newCommandConn(host.ExpandString(host.ProxyCommand, ""))
+		if err != nil {
+			return nil, err
+		}
+		return &pingPath{
+			conn:  conn,
+			piped: true,
+			hops:  []pingHop{{Name: host.Name(), Via: "ProxyCommand", Time: time.Since(start)}},
+		}, nil
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host.HostName, hostPort(host)), timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &pingPath{
+		conn: conn,
+		hops: []pingHop{{Name: host.Name(), Time: time.Since(start)}},
+	}, nil
+}
+
+// pingGatewayAuthError is a failure to authenticate on a gateway with the native client, ssh may still succeed
+// with its own configuration: encrypted keys, options only set in ssh_config, ControlMaster, ...
+type pingGatewayAuthError struct {
+	err error
+}
+
+func (e pingGatewayAuthError) Error() string { return e.err.Error() }
+func (e pingGatewayAuthError) Unwrap() error { return e.err }
+
+// pingDialGateway reaches the host through the gateway natively, measuring each hop, and falls back on the
+// command used by proxy() when the native client cannot authenticate on the gateway
+func pingDialGateway(conf *config.Config, host *config.Host, gateway string, timeout time.Duration, depth int) (*pingPath, error) {
+	path, err := pingDialGatewayNative(conf, host, gateway, timeout, depth)
+	var authErr pingGatewayAuthError
+	if err == nil || !errors.As(err, &authErr) {
+		return path, err
+	}
+	logger().Debug("Falling back on ssh to ping through the gateway", zap.String("gateway", gateway), zap.Error(err))
+	path, commandErr := pingDialGatewayCommand(conf, host, gateway)
+	if commandErr != nil {
+		return nil, errors.Wrapf(commandErr, "%v; ssh fallback", err)
+	}
+	return path, nil
+}
+
+// pingDialGatewayCommand runs the command used by proxy() to reach the host through the gateway, the hop
+// to the gateway is not measured separately
+func pingDialGatewayCommand(conf *config.Config, host *config.Host, gateway string) (*pingPath, error) {
+	target := host.Clone()
+	if err := hostPrepare(target, gateway); err != nil {
+		return nil, errors.Wrap(err, "failed to prepare host for gateway")
+	}
+	gatewayHost := conf.GetGatewaySafe(gateway)
+	command := gatewayHost.ExpandString(gatewayCommand(target, gateway), "")
+
+	start := time.Now()
+	conn, err := newCommandConn(command, gatewayPathEnv+"="+strings.Join(currentGatewayPath(host.Name()), ","))
+	if err != nil {
+		return nil, err
+	}
+	return &pingPath{
+		conn:  conn,
+		piped: true,
+		hops:  []pingHop{{Name: target.Name(), Via: gateway, Time: time.Since(start)}},
+	}, nil
+}
+
+// pingDialGatewayNative opens a SSH connection to the gateway, then a direct-tcpip channel to the host,
+// or runs the ProxyCommand of the host on the gateway
+func pingDialGatewayNative(conf *config.Config, host *config.Host, gateway string, timeout time.Duration, depth int) (*pingPath, error) {
+	gatewayHost := conf.GetGatewaySafe(gateway)
+	path, err := pingDial(conf, gatewayHost, timeout, depth+1)
+	if err != nil {
+		return nil, err
+	}
+
+	gatewayPrepared := gatewayHost.Clone()
+	if err := hostPrepare(gatewayPrepared, ""); err != nil {
+		_ = path.Close()
+		return nil, errors.Wrap(err, "failed to prepare gateway")
+	}
+	clientConfig, closeAgent, err := pingClientConfig(gatewayPrepared, timeout)
+	if err != nil {
+		_ = path.Close()
+		return nil, pingGatewayAuthError{err}
+	}
+	defer closeAgent()
+
+	// the hop to the gateway includes the SSH handshake and the authentication
+	start := time.Now()
+	if timeout > 0 {
+		_ = path.conn.SetDeadline(time.Now().Add(timeout))
+	}
+	address := net.JoinHostPort(gatewayPrepared.HostName, hostPort(gatewayPrepared))
+	clientConn, chans, reqs, err := ssh.NewClientConn(path.conn, address, clientConfig)
+	if err != nil {
+		_ = path.Close()
+		return nil, pingGatewayAuthError{errors.Wrapf(err, "failed to connect to gateway %q", gateway)}
+	}
+	_ = path.conn.SetDeadline(time.Time{})
+	client := ssh.NewClient(clientConn, chans, reqs)
+	path.closers = append(path.closers, client)
+	path.hops[len(path.hops)-1].Time += time.Since(start)
+
+	target := host.Clone()
+	if err := hostPrepare(target, gateway); err != nil {
+		_ = path.Close()
+		return nil, errors.Wrap(err, "failed to prepare host for gateway")
+	}
+
+	start = time.Now()
+	if target.ProxyCommand != "" {
+		conn, err := newSessionConn(client, target.ExpandString(target.ProxyCommand, gateway))
+		if err != nil {
+			_ = path.Close()
+			return nil, err
+		}
+		path.conn, path.piped = conn, true
+		path.hops = append(path.hops, pingHop{Name: target.Name(), Via: gateway, Time: time.Since(start)})
+		return path, nil
+	}
+
+	conn, err := client.Dial("tcp", net.JoinHostPort(target.HostName, hostPort(target)))
+	if err != nil {
+		_ = path.Close()
+		return nil, errors.Wrapf(err, "gateway %q failed to connect to the host", gateway)
+	}
+	path.conn = newChannelConn(conn)
+	path.hops = append(path.hops, pingHop{Name: target.Name(), Via: gateway, Time: time.Since(start)})
+	return path, nil
+}
+
+// pingClientConfig returns the configuration used to authenticate on a gateway,
+// using the ssh-agent and the unencrypted identity files; the returned function
+// closes the connection to the agent once authenticated
+func pingClientConfig(host *config.Host, timeout time.Duration) (*ssh.ClientConfig, func(), error) {
+	closeAgent := func() {}
+	username := host.User
+	if username == "" {
+		currentUser, err := user.Current()
+		if err != nil {
+			return nil, closeAgent, err
+		}
+		username = currentUser.Username
+	}
+
+	var signers []ssh.Signer
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if agentConn, err := net.Dial("unix", socket); err == nil {
+			closeAgent = func() { _ = agentConn.Close() }
+			if agentSigners, err := agent.NewClient(agentConn).Signers(); err == nil {
+				signers = append(signers, agentSigners...)
+			}
+		}
+	}
+	identityFiles := []string(host.IdentityFile)
+	if len(identityFiles) == 0 {
+		identityFiles = []string{"~/.ssh/id_ed25519", "~/.ssh/id_ecdsa", "~/.ssh/id_rsa"}
+	}
+	for _, identityFile := range identityFiles {
+		path, err := utils.ExpandUser(identityFile)
+		if err != nil {
+			continue
+		}
+		key, err := ioutil.ReadFile(path) // #nosec
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			logger().Debug("Skipping identity file", zap.String("path", path), zap.Error(err))
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) == 0 {
+		closeAgent()
+		return nil, func() {}, errors.New("no ssh-agent key nor unencrypted identity file to authenticate on the gateway")
+	}
+
+	hostKeyCallback, err := pingHostKeyCallback(host)
+	if err != nil {
+		closeAgent()
+		return nil, func() {}, err
+	}
+
+	return &ssh.ClientConfig{
+		User:            username,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         timeout,
+	}, closeAgent, nil
+}
+
+// pingHostKeyCallback verifies the host keys of the gateways with the known_hosts files
+func pingHostKeyCallback(host *config.Host) (ssh.HostKeyCallback, error) {
+	if strings.ToLower(strings.TrimSpace(host.StrictHostKeyChecking)) == "no" {
+		return ssh.InsecureIgnoreHostKey(), nil // #nosec
+	}
+
+	knownHostsFiles := []string(host.UserKnownHostsFile)
+	if len(knownHostsFiles) == 0 {
+		knownHostsFiles = []string{"~/.ssh/known_hosts", "~/.ssh/known_hosts2"}
+	}
+	files := []string{}
+	for _, entry := range knownHostsFiles {
+		for _, file := range strings.Fields(entry) {
+			path, err := utils.ExpandUser(file)
+			if err != nil {
+				continue
+			}
+			if _, err := os.Stat(path); err == nil {
+				files = append(files, path)
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no known_hosts file to verify the host key of the gateway")
+	}
+
+	callback, err := knownhosts.New(files...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load known_hosts")
+	}
+	if host.HostKeyAlias == "" {
+		return callback, nil
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		return callback(net.JoinHostPort(host.HostKeyAlias, hostPort(host)), remote, key)
+	}, nil
+}
+
+func hostPort(host *config.Host) string {
+	if host.Port == "" {
+		return "22"
+	}
+	return host.Port
+}
+
+// pipeAddr is the address of a connection over the standard input/output of a process
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// commandConn is a net.Conn over the standard input/output of a local process or of a remote command,
+// deadlines stop the process
+type commandConn struct {
+	io.Reader
+	io.WriteCloser
+	deadlineCloser
+	addr     pipeAddr
+	stop     func() error
+	stopOnce sync.Once
+	stopErr  error
+	// stderr explains why the connection closed, nil for the remote commands
+	stderr *stderrTail
+	exited chan struct{}
+}
+
+// stderrTail keeps the end of the error output of a process
+type stderrTail struct {
+	lock sync.Mutex
+	buf  []byte
+}
+
+const maxStderrTail = 4096
+
+func (t *stderrTail) Write(p []byte) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > maxStderrTail {
+		t.buf = t.buf[len(t.buf)-maxStderrTail:]
+	}
+	return len(p), nil
+}
+
+// LastLine returns the last line written, empty if none
+func (t *stderrTail) LastLine() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	lines := strings.Split(strings.TrimSpace(string(t.buf)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// newCommandConn runs a ProxyCommand and connects to its standard input/output, env is added to its environment
+func newCommandConn(command string, env ...string) (*commandConn, error) {
+	args, err := shlex.Split(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, errors.New("empty ProxyCommand")
+	}
+
+	spawn := exec.Command(args[0], args[1:]...) // #nosec
+	if len(env) > 0 {
+		spawn.Env = append(os.Environ(), env...)
+	}
+	stderr := &stderrTail{}
+	spawn.Stderr = stderr
+	stdin, err := spawn.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := spawn.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := spawn.Start(); err != nil {
+		return nil, errors.Wrap(err, "failed to run ProxyCommand")
+	}
+	exited := make(chan struct{})
+	go func() {
+		_ = spawn.Wait()
+		close(exited)
+	}()
+	conn := &commandConn{
+		Reader:      stdout,
+		WriteCloser: stdin,
+		addr:        pipeAddr(command),
+		stderr:      stderr,
+		exited:      exited,
+		stop: func() error {
+			_ = spawn.Process.Kill()
+			<-exited
+			return nil
+		},
+	}
+	conn.closer = conn
+	return conn, nil
+}
+
+// newSessionConn runs a ProxyCommand on a gateway and connects to its standard input/output
+func newSessionConn(client *ssh.Client, command string) (*commandConn, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		_ = session.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		_ = session.Close()
+		return nil, err
+	}
+	if err := session.Start(command); err != nil {
+		_ = session.Close()
+		return nil, errors.Wrap(err, "failed to run ProxyCommand on the gateway")
+	}
+	conn := &commandConn{
+		Reader:      stdout,
+		WriteCloser: stdin,
+		addr:        pipeAddr(command),
+		stop:        session.Close,
+	}
+	conn.closer = conn
+	return conn, nil
+}
+
+func (c *commandConn) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	return n, c.wrapError(c.explain(err))
+}
+
+func (c *commandConn) Write(p []byte) (int, error) {
+	n, err := c.WriteCloser.Write(p)
+	return n, c.wrapError(c.explain(err))
+}
+
+// explain adds the last line of the error output of the process to the error
+func (c *commandConn) explain(err error) error {
+	if err == nil || c.stderr == nil {
+		return err
+	}
+	// the error output is complete once the process exited
+	select {
+	case <-c.exited:
+	case <-time.After(100 * time.Millisecond):
+	}
+	if line := c.stderr.LastLine(); line != "" {
+		return errors.Wrap(err, line)
+	}
+	return err
+}
+
+func (c *commandConn) Close() error {
+	_ = c.WriteCloser.Close()
+	c.stopOnce.Do(func() { c.stopErr = c.stop() })
+	return c.stopErr
+}
+
+func (c *commandConn) LocalAddr() net.Addr  { return c.addr }
+func (c *commandConn) RemoteAddr() net.Addr { return c.addr }
+
+// deadlineCloser implements the deadlines of the connections without native support by closing them
+type deadlineCloser struct {
+	closer    io.Closer
+	timerLock sync.Mutex
+	timer     *time.Timer
+	expired   bool
+}
+
+// SetDeadline closes the connection when the deadline is reached
+func (d *deadlineCloser) SetDeadline(t time.Time) error {
+	d.timerLock.Lock()
+	defer d.timerLock.Unlock()
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	if !t.IsZero() {
+		d.timer = time.AfterFunc(time.Until(t), func() {
+			d.timerLock.Lock()
+			d.expired = true
+			d.timerLock.Unlock()
+			_ = d.closer.Close()
+		})
+	}
+	return nil
+}
+
+// wrapError reports the errors caused by closing the connection at the deadline as timeouts
+func (d *deadlineCloser) wrapError(err error) error {
+	d.timerLock.Lock()
+	defer d.timerLock.Unlock()
+	if err != nil && d.expired {
+		return pingTimeoutError{}
+	}
+	return err
+}
+
+func (d *deadlineCloser) SetReadDeadline(t time.Time) error  { return d.SetDeadline(t) }
+func (d *deadlineCloser) SetWriteDeadline(t time.Time) error { return d.SetDeadline(t) }
+
+// channelConn is a direct-tcpip channel opened on a gateway, x/crypto/ssh does not support deadlines on channels
+type channelConn struct {
+	net.Conn
+	deadlineCloser
+}
+
+func newChannelConn(conn net.Conn) *channelConn {
+	channel := &channelConn{Conn: conn}
+	channel.closer = conn
+	return channel
+}
+
+func (c *channelConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	return n, c.wrapError(err)
+}
+
+func (c *channelConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	return n, c.wrapError(err)
+}
+
+func (c *channelConn) SetDeadline(t time.Time) error      { return c.deadlineCloser.SetDeadline(t) }
+func (c *channelConn) SetReadDeadline(t time.Time) error  { return c.deadlineCloser.SetReadDeadline(t) }
+func (c *channelConn) SetWriteDeadline(t time.Time) error { return c.deadlineCloser.SetWriteDeadline(t) }
@@ -0,0 +1,212 @@
+package commands
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/ssh"
+	"moul.io/assh/v2/pkg/config"
+)
+
+// startGatewayServer starts a SSH server accepting the given key and forwarding the direct-tcpip channels
+func startGatewayServer(t *testing.T, hostKey ssh.Signer, authorizedKey ssh.PublicKey) net.Listener {
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(authorizedKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown key for %q", conn.User())
+		},
+	}
+	serverConfig.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for newChannel := range chans {
+					var payload struct {
+						Host     string
+						Port     uint32
+						OrigHost string
+						OrigPort uint32
+					}
+					if newChannel.ChannelType() != "direct-tcpip" || ssh.Unmarshal(newChannel.ExtraData(), &payload) != nil {
+						_ = newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+						continue
+					}
+					target, err := net.Dial("tcp", net.JoinHostPort(payload.Host, fmt.Sprintf("%d", payload.Port)))
+					if err != nil {
+						_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+						continue
+					}
+					channel, requests, err := newChannel.Accept()
+					if err != nil {
+						_ = target.Close()
+						continue
+					}
+					go ssh.DiscardRequests(requests)
+					go func() {
+						_, _ = io.Copy(channel, target)
+						_ = channel.Close()
+					}()
+					go func() {
+						_, _ = io.Copy(target, channel)
+						_ = target.Close()
+					}()
+				}
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestPingOnce(t *testing.T) {
+	Convey("Testing pingOnce()", t, func() {
+		oldAuthSock := os.Getenv("SSH_AUTH_SOCK")
+		So(os.Unsetenv("SSH_AUTH_SOCK"), ShouldBeNil)
+		defer os.Setenv("SSH_AUTH_SOCK", oldAuthSock)
+
+		dir, err := ioutil.TempDir("", "assh-ping")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		_, hostPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+		So(err, ShouldBeNil)
+		hostKey, err := ssh.NewSignerFromKey(hostPrivateKey)
+		So(err, ShouldBeNil)
+
+		clientPublicKey, clientPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+		So(err, ShouldBeNil)
+		authorizedKey, err := ssh.NewPublicKey(clientPublicKey)
+		So(err, ShouldBeNil)
+		pkcs8, err := x509.MarshalPKCS8PrivateKey(clientPrivateKey)
+		So(err, ShouldBeNil)
+		identityFile := filepath.Join(dir, "id_ed25519")
+		So(ioutil.WriteFile(identityFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}), 0600), ShouldBeNil)
+		knownHostsFile := filepath.Join(dir, "known_hosts")
+
+		targetConfig := &ssh.ServerConfig{NoClientAuth: true, ServerVersion: "SSH-2.0-assh-target"}
+		targetConfig.AddHostKey(hostKey)
+		target := startSSHServer(t, targetConfig)
+		defer target.Close()
+		gateway := startGatewayServer(t, hostKey, authorizedKey)
+		defer gateway.Close()
+
+		_, targetPort, _ := net.SplitHostPort(target.Addr().String())
+		_, gatewayPort, _ := net.SplitHostPort(gateway.Addr().String())
+		conf := config.New()
+		So(conf.LoadConfig(strings.NewReader(fmt.Sprintf(`
+hosts:
+  target:
+    HostName: 127.0.0.1
+    Port: %s
+    Gateways: [bastion]
+  down:
+    HostName: 127.0.0.1
+    Port: 1
+    Gateways: [bastion, direct]
+  bastion:
+    HostName: 127.0.0.1
+    Port: %s
+    User: moul
+    IdentityFile: %s
+    UserKnownHostsFile: %s
+  piped:
+    ProxyCommand: cat
+`, targetPort, gatewayPort, identityFile, knownHostsFile))), ShouldBeNil)
+
+		Convey("unknown gateway host key", func() {
+			if _, err := exec.LookPath("sh"); err != nil {
+				t.Skip("sh is not available")
+			}
+			// the native client fails, then ssh is run like `assh connect` does
+			binDir := filepath.Join(dir, "bin")
+			So(os.Mkdir(binDir, 0700), ShouldBeNil)
+			oldPath := os.Getenv("PATH")
+			defer os.Setenv("PATH", oldPath)
+			So(os.Setenv("PATH", binDir+string(os.PathListSeparator)+oldPath), ShouldBeNil)
+			argsFile := filepath.Join(dir, "ssh-args")
+			So(ioutil.WriteFile(knownHostsFile, []byte{}, 0600), ShouldBeNil)
+
+			Convey("ssh fails too", func() {
+				So(ioutil.WriteFile(filepath.Join(binDir, "ssh"), []byte("#!/bin/sh\necho 'Host key verification failed.' >&2\nexit 255\n"), 0700), ShouldBeNil) // #nosec
+				_, err := pingOnce(conf, conf.GetHostSafe("target"), pingModeBanner, 5*time.Second)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "Host key verification failed.")
+			})
+
+			Convey("ssh succeeds", func() {
+				// cat echoes our identification string back
+				script := fmt.Sprintf("#!/bin/sh\necho \"$@ $%s\" > %s\nexec cat\n", gatewayPathEnv, argsFile)
+				So(ioutil.WriteFile(filepath.Join(binDir, "ssh"), []byte(script), 0700), ShouldBeNil) // #nosec
+				result, err := pingOnce(conf, conf.GetHostSafe("target"), pingModeBanner, 5*time.Second)
+				So(err, ShouldBeNil)
+				So(result.Banner, ShouldEqual, pingClientVersion)
+				So(result.Gateway, ShouldEqual, "bastion")
+				So(len(result.Hops), ShouldEqual, 1)
+				So(result.Hops[0].Via, ShouldEqual, "bastion")
+				args, err := ioutil.ReadFile(argsFile)
+				So(err, ShouldBeNil)
+				So(string(args), ShouldEqual, fmt.Sprintf("-W 127.0.0.1:%s bastion target\n", targetPort))
+			})
+		})
+
+		Convey("through a gateway", func() {
+			line := fmt.Sprintf("[127.0.0.1]:%s %s", gatewayPort, ssh.MarshalAuthorizedKey(hostKey.PublicKey()))
+			So(ioutil.WriteFile(knownHostsFile, []byte(line), 0600), ShouldBeNil)
+
+			result, err := pingOnce(conf, conf.GetHostSafe("target"), pingModeKex, 5*time.Second)
+			So(err, ShouldBeNil)
+			So(result.Gateway, ShouldEqual, "bastion")
+			So(result.Banner, ShouldEqual, "SSH-2.0-assh-target")
+			So(len(result.Hops), ShouldEqual, 2)
+			So(result.Hops[0].Name, ShouldEqual, "bastion")
+			So(result.Hops[1].Name, ShouldEqual, "target")
+			So(result.Hops[1].Via, ShouldEqual, "bastion")
+			So(result.details(), ShouldContainSubstring, "via=bastion hops=bastion:")
+
+			_, err = pingOnce(conf, conf.GetHostSafe("down"), pingModeTCP, 5*time.Second)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, `bastion: gateway "bastion" failed to connect to the host`)
+			So(err.Error(), ShouldContainSubstring, "direct: dial tcp 127.0.0.1:1")
+		})
+
+		Convey("through a ProxyCommand", func() {
+			if _, err := exec.LookPath("cat"); err != nil {
+				t.Skip("cat is not available")
+			}
+			// cat echoes our identification string back
+			result, err := pingOnce(conf, conf.GetHostSafe("piped"), pingModeTCP, 5*time.Second)
+			So(err, ShouldBeNil)
+			So(result.Banner, ShouldEqual, pingClientVersion)
+			So(result.Hops[0].Via, ShouldEqual, "ProxyCommand")
+		})
+	})
+}
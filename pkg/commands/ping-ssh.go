@@ -0,0 +1,194 @@
+package commands
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"moul.io/assh/v2/pkg/sshproto"
+)
+
+// ping modes, each mode goes further than the previous one in the SSH protocol
+const (
+	pingModeTCP    = "tcp"    // TCP connect
+	pingModeBanner = "banner" // TCP connect + identification banner
+	pingModeKex    = "kex"    // TCP connect + identification banner + key exchange
+)
+
+const (
+	pingClientVersion = "SSH-2.0-assh-ping"
+	maxBannerSize     = 8192
+)
+
+// pingSSHConfig lists the algorithms proposed by `assh ping`, in preference order
+var (
+	pingSSHConfig = ssh.Config{
+		KeyExchanges: []string{"curve25519-sha256@libssh.org", "ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521", "diffie-hellman-group14-sha1"},
+		Ciphers:      []string{"aes128-gcm@openssh.com", "chacha20-poly1305@openssh.com", "aes128-ctr", "aes192-ctr", "aes256-ctr"},
+		MACs:         []string{"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256", "hmac-sha1", "hmac-sha1-96"},
+	}
+	pingHostKeyAlgorithms = []string{
+		ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521, ssh.KeyAlgoRSA, ssh.KeyAlgoDSA,
+	}
+
+	errPingKexDone = errors.New("key exchange done")
+)
+
+// sshProbe is the result of a SSH protocol probe
+type sshProbe struct {
+	Banner     string         `json:"banner,omitempty"`
+	BannerTime time.Duration  `json:"banner_time,omitempty"`
+	KexTime    time.Duration  `json:"kex_time,omitempty"`
+	HostKey    string         `json:"host_key,omitempty"`
+	Algorithms *kexAlgorithms `json:"algorithms,omitempty"`
+}
+
+// kexAlgorithms are the algorithms negotiated during the key exchange
+type kexAlgorithms struct {
+	KeyExchange        string `json:"kex"`
+	HostKey            string `json:"host_key"`
+	CipherClientServer string `json:"cipher_client_server"`
+	CipherServerClient string `json:"cipher_server_client"`
+	MACClientServer    string `json:"mac_client_server"`
+	MACServerClient    string `json:"mac_server_client"`
+	Compression        string `json:"compression"`
+}
+
+func (a *kexAlgorithms) String() string {
+	cipher, mac := a.CipherClientServer, a.MACClientServer
+	if a.CipherServerClient != cipher {
+		cipher += "/" + a.CipherServerClient
+	}
+	if a.MACServerClient != mac {
+		mac += "/" + a.MACServerClient
+	}
+	return fmt.Sprintf("kex=%s hostkey=%s cipher=%s mac=%s compression=%s", a.KeyExchange, a.HostKey, cipher, mac, a.Compression)
+}
+
+// recordingConn keeps the data read from a connection and the time the identification banner was received
+type recordingConn struct {
+	net.Conn
+	data     []byte
+	bannerAt time.Time
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if len(c.data) < maxBannerSize+256*1024 {
+		c.data = append(c.data, p[:n]...)
+	}
+	if c.bannerAt.IsZero() {
+		if _, _, err := sshproto.ReadIdentification(c.data); err == nil {
+			c.bannerAt = time.Now()
+		}
+	}
+	return n, err
+}
+
+// probeSSH reads the identification banner of the server on an established connection
+// and, in kex mode, runs a key exchange up to the verification of the host key
+func probeSSH(conn net.Conn, mode string, timeout time.Duration) (*sshProbe, error) {
+	if timeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+	}
+	start := time.Now()
+	recorder := &recordingConn{Conn: conn}
+	probe := &sshProbe{}
+
+	if mode == pingModeBanner {
+		// sending our identification lets the server log a clean disconnection
+		if _, err := conn.Write([]byte(pingClientVersion + "\r\n")); err != nil {
+			return nil, errors.Wrap(err, "failed to send SSH identification")
+		}
+		buf := make([]byte, 512)
+		for recorder.bannerAt.IsZero() {
+			if len(recorder.data) > maxBannerSize {
+				return nil, errors.New("no SSH identification banner")
+			}
+			if _, err := recorder.Read(buf); err != nil {
+				return nil, errors.Wrap(err, "failed to read SSH identification banner")
+			}
+		}
+		if identification, _, err := sshproto.ReadIdentification(recorder.data); err == nil {
+			probe.Banner = identification.Version
+		}
+		probe.BannerTime = recorder.bannerAt.Sub(start)
+		return probe, nil
+	}
+
+	var hostKey ssh.PublicKey
+	var hostKeyAt time.Time
+	config := &ssh.ClientConfig{
+		Config:            pingSSHConfig,
+		HostKeyAlgorithms: pingHostKeyAlgorithms,
+		ClientVersion:     pingClientVersion,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey, hostKeyAt = key, time.Now()
+			// the key exchange is verified, no need to authenticate
+			return errPingKexDone
+		},
+	}
+	_, _, _, err := ssh.NewClientConn(recorder, conn.RemoteAddr().String(), config)
+	if hostKey == nil {
+		if err == nil {
+			err = errors.New("no host key received")
+		}
+		return nil, err
+	}
+
+	identification, rest, err := sshproto.ReadIdentification(recorder.data)
+	if err == nil {
+		probe.Banner = identification.Version
+	}
+	probe.BannerTime = recorder.bannerAt.Sub(start)
+	probe.KexTime = hostKeyAt.Sub(recorder.bannerAt)
+	probe.HostKey = fmt.Sprintf("%s %s", hostKey.Type(), ssh.FingerprintSHA256(hostKey))
+	// the first packet of the server is its KEXINIT, sent in clear
+	payload, _, err := sshproto.ReadPacket(rest)
+	var server *sshproto.KexInit
+	if err == nil {
+		server, err = sshproto.ParseKexInit(payload)
+	}
+	if err == nil {
+		probe.Algorithms = negotiateAlgorithms(server)
+	} else {
+		logger().Debug("Failed to parse server KEXINIT", zap.Error(err))
+	}
+	return probe, nil
+}
+
+// negotiateAlgorithms returns the algorithms agreed by the server and `assh ping`, "none" if there is no
+// common algorithm
+func negotiateAlgorithms(server *sshproto.KexInit) *kexAlgorithms {
+	client := &sshproto.KexInit{
+		Kex:               pingSSHConfig.KeyExchanges,
+		HostKey:           pingHostKeyAlgorithms,
+		CiphersClient:     pingSSHConfig.Ciphers,
+		CiphersServer:     pingSSHConfig.Ciphers,
+		MACsClient:        pingSSHConfig.MACs,
+		MACsServer:        pingSSHConfig.MACs,
+		CompressionClient: []string{"none"},
+		CompressionServer: []string{"none"},
+	}
+	negotiated := sshproto.Negotiate(client, server)
+	first := func(list []string) string {
+		if len(list) == 0 {
+			return "none"
+		}
+		return list[0]
+	}
+	return &kexAlgorithms{
+		KeyExchange:        first(negotiated.Kex),
+		HostKey:            first(negotiated.HostKey),
+		CipherClientServer: first(negotiated.CiphersClient),
+		CipherServerClient: first(negotiated.CiphersServer),
+		MACClientServer:    first(negotiated.MACsClient),
+		MACServerClient:    first(negotiated.MACsServer),
+		Compression:        first(negotiated.CompressionClient),
+	}
+}
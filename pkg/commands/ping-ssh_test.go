@@ -0,0 +1,103 @@
+package commands
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/ssh"
+)
+
+// startSSHServer starts a SSH server accepting connections until the listener is closed
+func startSSHServer(t *testing.T, config *ssh.ServerConfig) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _, _, _ = ssh.NewServerConn(conn, config)
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestProbeSSH(t *testing.T) {
+	Convey("Testing probeSSH()", t, func() {
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		So(err, ShouldBeNil)
+		signer, err := ssh.NewSignerFromKey(privateKey)
+		So(err, ShouldBeNil)
+
+		config := &ssh.ServerConfig{
+			NoClientAuth:  true,
+			ServerVersion: "SSH-2.0-assh-test",
+		}
+		config.Ciphers = []string{"aes256-ctr", "aes128-ctr"}
+		config.AddHostKey(signer)
+		listener := startSSHServer(t, config)
+		defer listener.Close()
+
+		Convey("banner", func() {
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			So(err, ShouldBeNil)
+			defer conn.Close()
+
+			probe, err := probeSSH(conn, pingModeBanner, 5*time.Second)
+			So(err, ShouldBeNil)
+			So(probe.Banner, ShouldEqual, "SSH-2.0-assh-test")
+			So(probe.BannerTime, ShouldBeGreaterThan, 0)
+			So(probe.HostKey, ShouldBeEmpty)
+		})
+
+		Convey("kex", func() {
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			So(err, ShouldBeNil)
+			defer conn.Close()
+
+			probe, err := probeSSH(conn, pingModeKex, 5*time.Second)
+			So(err, ShouldBeNil)
+			So(probe.Banner, ShouldEqual, "SSH-2.0-assh-test")
+			So(probe.KexTime, ShouldBeGreaterThan, 0)
+			So(probe.HostKey, ShouldEqual, "ssh-ed25519 "+ssh.FingerprintSHA256(signer.PublicKey()))
+			So(probe.Algorithms, ShouldResemble, &kexAlgorithms{
+				KeyExchange:        "curve25519-sha256@libssh.org",
+				HostKey:            "ssh-ed25519",
+				CipherClientServer: "aes128-ctr",
+				CipherServerClient: "aes128-ctr",
+				MACClientServer:    "hmac-sha2-256-etm@openssh.com",
+				MACServerClient:    "hmac-sha2-256-etm@openssh.com",
+				Compression:        "none",
+			})
+		})
+
+		Convey("not a SSH server", func() {
+			httpListener, err := net.Listen("tcp", "127.0.0.1:0")
+			So(err, ShouldBeNil)
+			defer httpListener.Close()
+			go func() {
+				conn, err := httpListener.Accept()
+				if err == nil {
+					_, _ = conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+					_ = conn.Close()
+				}
+			}()
+
+			conn, err := net.Dial("tcp", httpListener.Addr().String())
+			So(err, ShouldBeNil)
+			defer conn.Close()
+			_, err = probeSSH(conn, pingModeBanner, 5*time.Second)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,178 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ping error classes
+const (
+	pingErrorTimeout     = "timeout"
+	pingErrorRefused     = "refused"
+	pingErrorDNS         = "dns"
+	pingErrorUnreachable = "unreachable"
+	pingErrorReset       = "reset"
+	pingErrorOther       = "other"
+)
+
+// classifyPingError returns the class of a failed probe, to tell a filtered port from a closed one
+func classifyPingError(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &dnsErr):
+		return pingErrorDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return pingErrorRefused
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return pingErrorUnreachable
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return pingErrorReset
+	case errors.As(err, &netErr) && netErr.Timeout(), os.IsTimeout(err):
+		return pingErrorTimeout
+	}
+	return pingErrorOther
+}
+
+// pingTimeoutError is returned by the connections without native deadlines once their deadline is exceeded
+type pingTimeoutError struct{}
+
+func (pingTimeoutError) Error() string   { return "i/o timeout" }
+func (pingTimeoutError) Timeout() bool   { return true }
+func (pingTimeoutError) Temporary() bool { return true }
+
+// pingStats aggregates the results of the probes sent to a host
+type pingStats struct {
+	Transmitted int            `json:"transmitted"`
+	Received    int            `json:"received"`
+	Errors      map[string]int `json:"errors,omitempty"`
+	times       []time.Duration
+}
+
+// Add records the result of a probe
+func (s *pingStats) Add(duration time.Duration, err error) {
+	s.Transmitted++
+	if err != nil {
+		if s.Errors == nil {
+			s.Errors = map[string]int{}
+		}
+		s.Errors[classifyPingError(err)]++
+		return
+	}
+	s.Received++
+	s.times = append(s.times, duration)
+}
+
+// Loss returns the percentage of lost probes
+func (s *pingStats) Loss() float64 {
+	if s.Transmitted == 0 {
+		return 0
+	}
+	return float64(s.Transmitted-s.Received) / float64(s.Transmitted) * 100
+}
+
+// Min returns the shortest round-trip
+func (s *pingStats) Min() time.Duration {
+	return s.Percentile(0)
+}
+
+// Max returns the longest round-trip
+func (s *pingStats) Max() time.Duration {
+	return s.Percentile(100)
+}
+
+// Avg returns the mean round-trip
+func (s *pingStats) Avg() time.Duration {
+	if len(s.times) == 0 {
+		return 0
+	}
+	total := time.Duration(0)
+	for _, duration := range s.times {
+		total += duration
+	}
+	return total / time.Duration(len(s.times))
+}
+
+// StdDev returns the population standard deviation of the round-trips
+func (s *pingStats) StdDev() time.Duration {
+	if len(s.times) == 0 {
+		return 0
+	}
+	avg := float64(s.Avg())
+	variance := 0.0
+	for _, duration := range s.times {
+		variance += math.Pow(float64(duration)-avg, 2)
+	}
+	return time.Duration(math.Sqrt(variance / float64(len(s.times))))
+}
+
+// Percentile returns the round-trip below which p percent of the round-trips fall (nearest-rank method)
+func (s *pingStats) Percentile(p float64) time.Duration {
+	if len(s.times) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(s.times))
+	copy(sorted, s.times)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+// ErrorsString returns the errors by class, i.e: "refused=2 timeout=1"
+func (s *pingStats) ErrorsString() string {
+	classes := make([]string, 0, len(s.Errors))
+	for class, count := range s.Errors {
+		classes = append(classes, fmt.Sprintf("%s=%d", class, count))
+	}
+	sort.Strings(classes)
+	return strings.Join(classes, " ")
+}
+
+// pingSummary is the JSON representation of the statistics
+type pingSummary struct {
+	Type   string `json:"type"`
+	Target string `json:"target"`
+	*pingStats
+	Loss   float64       `json:"loss"`
+	Min    time.Duration `json:"min,omitempty"`
+	Avg    time.Duration `json:"avg,omitempty"`
+	Max    time.Duration `json:"max,omitempty"`
+	StdDev time.Duration `json:"stddev,omitempty"`
+	P50    time.Duration `json:"p50,omitempty"`
+	P95    time.Duration `json:"p95,omitempty"`
+	P99    time.Duration `json:"p99,omitempty"`
+}
+
+// Summary returns the statistics computed
+func (s *pingStats) Summary(target string) pingSummary {
+	return pingSummary{
+		Type:      "summary",
+		Target:    target,
+		pingStats: s,
+		Loss:      s.Loss(),
+		Min:       s.Min(),
+		Avg:       s.Avg(),
+		Max:       s.Max(),
+		StdDev:    s.StdDev(),
+		P50:       s.Percentile(50),
+		P95:       s.Percentile(95),
+		P99:       s.Percentile(99),
+	}
+}
@@ -0,0 +1,65 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClassifyPingError(t *testing.T) {
+	Convey("Testing classifyPingError()", t, func() {
+		for _, test := range []struct {
+			err      error
+			expected string
+		}{
+			{nil, ""},
+			{&net.DNSError{Err: "no such host", Name: "nonexistent.invalid"}, pingErrorDNS},
+			{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, pingErrorRefused},
+			{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.EHOSTUNREACH)}, pingErrorUnreachable},
+			{errors.Wrap(io.EOF, "failed to read SSH identification banner"), pingErrorReset},
+			{errors.Wrap(pingTimeoutError{}, "failed to read SSH identification banner"), pingErrorTimeout},
+			{pingGatewaysError{gateways: []string{"bastion", "direct"}, errs: []error{io.EOF, pingTimeoutError{}}}, pingErrorTimeout},
+			{errors.New("ssh: handshake failed"), pingErrorOther},
+		} {
+			So(classifyPingError(test.err), ShouldEqual, test.expected)
+		}
+	})
+}
+
+func TestPingStats(t *testing.T) {
+	Convey("Testing pingStats", t, func() {
+		stats := &pingStats{}
+		So(stats.Loss(), ShouldEqual, 0)
+		So(stats.Percentile(50), ShouldEqual, 0)
+
+		for i := 1; i <= 10; i++ {
+			stats.Add(time.Duration(i)*time.Millisecond, nil)
+		}
+		stats.Add(time.Second, pingTimeoutError{})
+		stats.Add(time.Millisecond, fmt.Errorf("dial: %w", syscall.ECONNREFUSED))
+		stats.Add(time.Millisecond, fmt.Errorf("dial: %w", syscall.ECONNREFUSED))
+
+		So(stats.Transmitted, ShouldEqual, 13)
+		So(stats.Received, ShouldEqual, 10)
+		So(stats.ErrorsString(), ShouldEqual, "refused=2 timeout=1")
+		So(stats.Min(), ShouldEqual, time.Millisecond)
+		So(stats.Max(), ShouldEqual, 10*time.Millisecond)
+		So(stats.Avg(), ShouldEqual, 5500*time.Microsecond)
+		So(stats.StdDev(), ShouldAlmostEqual, 2872281, 1)
+		So(stats.Percentile(50), ShouldEqual, 5*time.Millisecond)
+		So(stats.Percentile(95), ShouldEqual, 10*time.Millisecond)
+		So(stats.Percentile(99), ShouldEqual, 10*time.Millisecond)
+
+		summary := stats.Summary("localhost")
+		So(summary.Type, ShouldEqual, "summary")
+		So(summary.Loss, ShouldAlmostEqual, 23.0769, 0.001)
+		So(summary.P50, ShouldEqual, 5*time.Millisecond)
+	})
+}
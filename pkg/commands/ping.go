@@ -1,14 +1,17 @@
 package commands
 
 import (
+	"encoding/json"
 	"fmt"
-	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"go.uber.org/zap"
 	"moul.io/assh/v2/pkg/config"
 )
 
@@ -27,11 +30,19 @@ func init() {
 	pingCommand.Flags().BoolP("o", "", false, "Exit successfully after receiving one reply packet")
 	pingCommand.Flags().Float64P("waittime", "W", 1, "Time in seconds to wait for a reply for each packet sent")
 	_ = viper.BindPFlags(pingCommand.Flags())
+	pingCommand.Flags().StringP("mode", "m", pingModeTCP, "Probe mode: 'tcp' (connect), 'banner' (read the SSH banner) or 'kex' (run a SSH key exchange)")
+	pingCommand.Flags().BoolP("json", "", false, "Stream the probes and the statistics as JSON lines")
+	pingCommand.Flags().BoolP("all", "", false, "Ping all the hosts of the configuration")
+	pingCommand.Flags().StringP("selector", "l", "", "Ping the hosts matching a label selector (e.g. env=prod,role!=db)")
+	pingCommand.Flags().IntP("parallel", "", 32, "Maximum amount of hosts pinged concurrently")
 }
 
 func runPingCommand(cmd *cobra.Command, args []string) error {
-	if len(args) < 1 {
-		return errors.New("assh: \"ping\" requires exactly 1 argument. See 'assh ping --help'")
+	all, _ := cmd.Flags().GetBool("all")
+	selectorFlag, _ := cmd.Flags().GetString("selector")
+	fleet := all || selectorFlag != "" || len(args) > 1
+	if len(args) < 1 && !fleet {
+		return errors.New("assh: \"ping\" requires at least 1 host, --all or a selector. See 'assh ping --help'")
 	}
 
 	conf, err := config.Open(viper.GetString("config"))
@@ -41,74 +52,244 @@ func runPingCommand(cmd *cobra.Command, args []string) error {
 	if err = conf.LoadKnownHosts(); err != nil {
 		return errors.Wrap(err, "failed to load known-hosts")
 	}
+	mode, _ := cmd.Flags().GetString("mode")
+	switch mode {
+	case pingModeTCP, pingModeBanner, pingModeKex:
+	default:
+		return fmt.Errorf("invalid ping mode %q, should be 'tcp', 'banner' or 'kex'", mode)
+	}
+
+	jsonOutput, _ := cmd.Flags().GetBool("json")
+	encoder := json.NewEncoder(os.Stdout)
+
+	if fleet {
+		selector, err := config.ParseSelector(selectorFlag)
+		if err != nil {
+			return err
+		}
+		targets := args
+		if all || selectorFlag != "" {
+			hosts, err := conf.SelectHosts(selector)
+			if err != nil {
+				return errors.Wrap(err, "failed to select hosts")
+			}
+			for _, host := range hosts {
+				targets = append(targets, host.Name())
+			}
+		}
+		targets = uniqueStrings(targets)
+		if len(targets) == 0 {
+			fmt.Printf("No host matching %q.\n", selector.String())
+			return nil
+		}
+		parallel, _ := cmd.Flags().GetInt("parallel")
+		options := pingFleetOptions{
+			mode:     mode,
+			port:     viper.GetInt("port"),
+			count:    uint(viper.GetInt("count")),
+			wait:     time.Duration(viper.GetFloat64("wait") * float64(time.Second)),
+			timeout:  time.Duration(viper.GetFloat64("waittime") * float64(time.Second)),
+			parallel: parallel,
+		}
+		results := pingFleet(conf, targets, options)
+		if jsonOutput {
+			for _, result := range results {
+				if err := encoder.Encode(result); err != nil {
+					return err
+				}
+			}
+		} else if err := printPingFleet(results); err != nil {
+			return err
+		}
+		if down := pingFleetDown(results); down > 0 {
+			return fmt.Errorf("%d/%d hosts down", down, len(results))
+		}
+		return nil
+	}
+
 	target := args[0]
 	host, err := computeHost(target, viper.GetInt("port"), conf)
 	if err != nil {
 		return errors.Wrapf(err, "failed to get host %q", target)
 	}
 
-	if len(host.Gateways) > 0 {
-		return errors.New("assh \"ping\" is not working with gateways (yet)")
-	}
-	if host.ProxyCommand != "" {
-		return errors.New("assh \"ping\" is not working with custom ProxyCommand (yet)")
-	}
-
 	portName := "ssh"
 	if host.Port != "22" {
 		// fixme: resolve port name
 		portName = "unknown"
 	}
 	proto := "tcp"
-	fmt.Printf("PING %s (%s) PORT %s (%s) PROTO %s\n", target, host.HostName, host.Port, portName, proto)
-	dest := fmt.Sprintf("%s:%s", host.HostName, host.Port)
+	if !jsonOutput {
+		fmt.Printf("PING %s (%s) PORT %s (%s) PROTO %s\n", target, host.HostName, host.Port, portName, proto)
+	}
+
+	// Ctrl+C stops the probes and prints the statistics
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	waittime := time.Duration(viper.GetFloat64("waittime") * float64(time.Second))
+	wait := time.Duration(viper.GetFloat64("wait") * float64(time.Second))
 	count := uint(viper.GetInt("count"))
-	transmittedPackets := 0
-	receivedPackets := 0
-	minRoundtrip := time.Duration(0)
-	maxRoundtrip := time.Duration(0)
-	totalRoundtrip := time.Duration(0)
+	stats := &pingStats{}
+probes:
 	for seq := uint(0); count == 0 || seq < count; seq++ {
 		if seq > 0 {
-			time.Sleep(time.Duration(viper.GetFloat64("wait")) * time.Second)
+			select {
+			case <-time.After(wait):
+			case <-interrupt:
+				break probes
+			}
 		}
-		start := time.Now()
-		conn, err := net.DialTimeout(proto, dest, time.Second*time.Duration(viper.GetFloat64("waittime")))
-		transmittedPackets++
-		duration := time.Since(start)
-		totalRoundtrip += duration
-		if minRoundtrip == 0 || minRoundtrip > duration {
-			minRoundtrip = duration
+
+		type probe struct {
+			result *pingResult
+			err    error
 		}
-		if maxRoundtrip < duration {
-			maxRoundtrip = duration
+		start := time.Now()
+		done := make(chan probe, 1)
+		go func() {
+			result, err := pingOnce(conf, host, mode, waittime)
+			done <- probe{result: result, err: err}
+		}()
+		var current probe
+		select {
+		case current = <-done:
+		case <-interrupt:
+			// the probe closes its connection by itself once finished
+			break probes
 		}
-		if err == nil {
-			defer func() {
-				if err2 := conn.Close(); err2 != nil {
-					logger().Error("Failed to close connection", zap.Error(err2))
+		duration := time.Since(start)
+		stats.Add(duration, current.err)
+
+		if jsonOutput {
+			if err := encoder.Encode(newPingEvent(target, seq, duration, current.result, current.err)); err != nil {
+				return err
+			}
+		} else if current.err == nil {
+			fmt.Printf("Connected to %s: seq=%d time=%v%s protocol=%s port=%s\n", host.HostName, seq, duration, current.result.details(), proto, host.Port)
+			if current.result.sshProbe != nil && stats.Received == 1 {
+				// the server identity does not change between the probes
+				fmt.Printf("  banner: %s\n", current.result.Banner)
+				if current.result.HostKey != "" {
+					fmt.Printf("  host key: %s\n", current.result.HostKey)
+				}
+				if current.result.Algorithms != nil {
+					fmt.Printf("  algorithms: %s\n", current.result.Algorithms)
 				}
-			}()
-		}
-		if err == nil {
-			receivedPackets++
-			fmt.Printf("Connected to %s: seq=%d time=%v protocol=%s port=%s\n", host.HostName, seq, duration, proto, host.Port)
-			if viper.GetBool("o") {
-				goto stats
 			}
+		} else if class := classifyPingError(current.err); class == pingErrorTimeout {
+			fmt.Printf("Request timeout for seq %d (%v)\n", seq, current.err)
 		} else {
-			// FIXME: switch on error type
-			fmt.Printf("Request timeout for seq %d (%v)\n", seq, err)
+			fmt.Printf("Request failed for seq %d: %s (%v)\n", seq, class, current.err)
 		}
-	}
 
-	// FIXME: catch Ctrl+C
+		if current.err == nil && viper.GetBool("o") {
+			break
+		}
+	}
 
-stats:
+	if stats.Transmitted == 0 {
+		return nil
+	}
+	if jsonOutput {
+		return encoder.Encode(stats.Summary(target))
+	}
 	fmt.Printf("\n--- %s assh ping statistics ---\n", target)
-	packetLossRatio := float64(transmittedPackets-receivedPackets) / float64(transmittedPackets) * 100
-	fmt.Printf("%d packets transmitted, %d packets received, %.2f%% packet loss\n", transmittedPackets, receivedPackets, packetLossRatio)
-	avgRoundtrip := totalRoundtrip / time.Duration(transmittedPackets)
-	fmt.Printf("round-trip min/avg/max = %v/%v/%v\n", minRoundtrip, avgRoundtrip, maxRoundtrip)
+	fmt.Printf("%d packets transmitted, %d packets received, %.2f%% packet loss\n", stats.Transmitted, stats.Received, stats.Loss())
+	if len(stats.Errors) > 0 {
+		fmt.Printf("errors: %s\n", stats.ErrorsString())
+	}
+	if stats.Received > 0 {
+		fmt.Printf("round-trip min/avg/max/stddev = %v/%v/%v/%v\n", stats.Min(), stats.Avg(), stats.Max(), stats.StdDev())
+		fmt.Printf("round-trip p50/p95/p99 = %v/%v/%v\n", stats.Percentile(50), stats.Percentile(95), stats.Percentile(99))
+	}
 	return nil
 }
+
+// pingEvent is the JSON representation of a probe
+type pingEvent struct {
+	Type       string        `json:"type"`
+	Target     string        `json:"target"`
+	Seq        uint          `json:"seq"`
+	Time       time.Duration `json:"time"`
+	Success    bool          `json:"success"`
+	Error      string        `json:"error,omitempty"`
+	ErrorClass string        `json:"error_class,omitempty"`
+	*pingResult
+}
+
+func newPingEvent(target string, seq uint, duration time.Duration, result *pingResult, err error) pingEvent {
+	event := pingEvent{
+		Type:       "probe",
+		Target:     target,
+		Seq:        seq,
+		Time:       duration,
+		Success:    err == nil,
+		ErrorClass: classifyPingError(err),
+		pingResult: result,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	return event
+}
+
+// pingResult is the result of a successful probe
+type pingResult struct {
+	Time    time.Duration `json:"time"`
+	Hops    []pingHop     `json:"hops,omitempty"`
+	Gateway string        `json:"gateway,omitempty"`
+	*sshProbe
+}
+
+// details returns the timings of the probe, empty for a direct TCP probe
+func (r *pingResult) details() string {
+	parts := []string{}
+	if r.sshProbe != nil {
+		connectTime := time.Duration(0)
+		for _, hop := range r.Hops {
+			connectTime += hop.Time
+		}
+		parts = append(parts, fmt.Sprintf("connect=%v banner=%v", connectTime, r.BannerTime))
+		if r.KexTime > 0 {
+			parts = append(parts, fmt.Sprintf("kex=%v", r.KexTime))
+		}
+	}
+	if (r.Gateway != "" && r.Gateway != "direct") || len(r.Hops) > 1 {
+		hops := make([]string, len(r.Hops))
+		for idx, hop := range r.Hops {
+			hops[idx] = hop.String()
+		}
+		parts = append(parts, fmt.Sprintf("via=%s hops=%s", r.Gateway, strings.Join(hops, ",")))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " " + strings.Join(parts, " ")
+}
+
+// pingOnce connects to the host, through its gateways if any, and probes the SSH server
+func pingOnce(conf *config.Config, host *config.Host, mode string, timeout time.Duration) (*pingResult, error) {
+	start := time.Now()
+	path, err := pingDial(conf, host, timeout, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer path.Close()
+
+	result := &pingResult{Hops: path.hops, Gateway: path.gateway}
+	// the process of a ProxyCommand starts even if the host is not reachable, waiting for the banner is needed
+	if path.piped && mode == pingModeTCP {
+		mode = pingModeBanner
+	}
+	if mode != pingModeTCP {
+		probe, err := probeSSH(path.conn, mode, timeout)
+		if err != nil {
+			return nil, err
+		}
+		result.sshProbe = probe
+	}
+	result.Time = time.Since(start)
+	return result, nil
+}
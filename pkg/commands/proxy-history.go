@@ -0,0 +1,60 @@
+package commands
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+	"moul.io/assh/v2/pkg/config"
+	"moul.io/assh/v2/pkg/history"
+)
+
+// historyEntry converts a connection attempt to a history entry, stats are nil when assh did not connect
+// itself (gateway or ProxyCommand)
+func historyEntry(host *config.Host, gateway string, startedAt time.Time, stats *ConnectionStats, err error, now time.Time) history.Entry {
+	entry := history.Entry{
+		Host:        host.Name(),
+		HostName:    host.HostName,
+		Port:        host.Port,
+		Gateway:     gateway,
+		GatewayPath: currentGatewayPath(host.Name()),
+		StartedAt:   startedAt,
+		EndedAt:     now,
+		Success:     err == nil,
+	}
+	if stats == nil && gateway != "" && gateway != "direct" {
+		// the nested `assh connect` records the connection to the gateway
+		entry.GatewayPath = append([]string{gateway}, entry.GatewayPath...)
+	}
+	if stats != nil {
+		entry.GatewayPath = stats.GatewayPath
+		if !stats.ConnectedAt.IsZero() {
+			connectedAt := stats.ConnectedAt
+			entry.ConnectedAt = &connectedAt
+		}
+		if !stats.DisconnectedAt.IsZero() {
+			entry.EndedAt = stats.DisconnectedAt
+		}
+		entry.SentBytes = stats.SentBytes
+		entry.ReceivedBytes = stats.ReceivedBytes
+	}
+	entry.Duration = entry.EndedAt.Sub(entry.StartedAt)
+	if err != nil {
+		entry.Error = err.Error()
+		entry.ErrorClass = classifyPingError(err)
+	}
+	return entry
+}
+
+// recordConnection appends a connection attempt to the history, the failures are only logged
+func recordConnection(conf *config.Config, host *config.Host, gateway string, startedAt time.Time, stats *ConnectionStats, err error, dryRun bool) {
+	if dryRun || !conf.HistoryEnabled() {
+		return
+	}
+	store, openErr := history.Open(conf.ASSHHistoryFile)
+	if openErr == nil {
+		openErr = store.Append(historyEntry(host, gateway, startedAt, stats, err, time.Now()))
+	}
+	if openErr != nil {
+		logger().Warn("Failed to record the connection in the history", zap.String("file", conf.ASSHHistoryFile), zap.Error(openErr))
+	}
+}
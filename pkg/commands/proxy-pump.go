@@ -0,0 +1,70 @@
+package commands
+
+import (
+	"io"
+	"time"
+)
+
+// the buffer of the metered pump grows during bulk transfers (scp, rsync...), interactive sessions keep a small one
+const (
+	minPumpBufferSize = 32 * 1024
+	maxPumpBufferSize = 1024 * 1024
+)
+
+type exportReadWrite struct {
+	written uint64
+	err     error
+}
+
+// pump copies src to dst until EOF or an error, io.EOF is not reported as an error
+//
+// With zeroCopy, io.Copy lets the standard library use splice(2) or sendfile(2) between the sockets and the
+// pipes of ssh, the traffic is only counted once the copy is finished and the timings are not measured; the
+// metered copy records each write as it happens, which the peak speed, the idle periods and the time to first
+// byte depend on
+func pump(dst io.Writer, src io.Reader, meter *trafficMeter, sent bool, zeroCopy bool) <-chan exportReadWrite {
+	c := make(chan exportReadWrite, 1)
+	go func() {
+		if !zeroCopy {
+			c <- meteredCopy(dst, src, meter, sent)
+			return
+		}
+		written, err := io.Copy(dst, src)
+		meter.count(sent, int(written))
+		c <- exportReadWrite{written: uint64(written), err: err}
+	}()
+	return c
+}
+
+// meteredCopy is io.Copy with a buffer doubling each time a read fills it
+func meteredCopy(dst io.Writer, src io.Reader, meter *trafficMeter, sent bool) exportReadWrite {
+	export := exportReadWrite{}
+	buff := make([]byte, minPumpBufferSize)
+	for {
+		nr, err := src.Read(buff)
+		if nr > 0 {
+			nw, werr := dst.Write(buff[:nr])
+			if nw > 0 {
+				export.written += uint64(nw)
+				meter.record(sent, nw, time.Now())
+			}
+			if werr != nil {
+				export.err = werr
+				return export
+			}
+			if nw != nr {
+				export.err = io.ErrShortWrite
+				return export
+			}
+			if nr == len(buff) && len(buff) < maxPumpBufferSize {
+				buff = make([]byte, 2*len(buff))
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				export.err = err
+			}
+			return export
+		}
+	}
+}
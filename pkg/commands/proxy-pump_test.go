@@ -0,0 +1,123 @@
+package commands
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// chunkedReader returns at most size bytes per read, like a socket
+type chunkedReader struct {
+	reader io.Reader
+	size   int
+}
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	if len(p) > r.size {
+		p = p[:r.size]
+	}
+	return r.reader.Read(p)
+}
+
+func TestPump(t *testing.T) {
+	Convey("Testing pump()", t, func() {
+		data := bytes.Repeat([]byte("assh"), 1024*1024)
+
+		for _, zeroCopy := range []bool{false, true} {
+			meter := newTrafficMeter(time.Now())
+			var output bytes.Buffer
+			result := <-pump(&output, &chunkedReader{reader: bytes.NewReader(data), size: 100 * 1024}, meter, true, zeroCopy)
+			So(result.err, ShouldBeNil)
+			So(result.written, ShouldEqual, len(data))
+			So(output.Bytes(), ShouldResemble, data)
+
+			stats := ConnectionStats{}
+			meter.finish(&stats, time.Now())
+			So(stats.SentBytes, ShouldEqual, len(data))
+			So(stats.ReceivedBytes, ShouldEqual, 0)
+		}
+	})
+}
+
+// legacyReadAndWrite is the pump used before the buffer became adaptive, kept as a reference for the benchmark
+func legacyReadAndWrite(dst io.Writer, src io.Reader) error {
+	buff := make([]byte, 1024)
+	for {
+		nr, err := src.Read(buff)
+		if err != nil {
+			return err
+		}
+		if _, err := dst.Write(buff[:nr]); err != nil {
+			return err
+		}
+	}
+}
+
+// BenchmarkPump copies from a TCP connection to a pipe, like assh does between the server and ssh
+func BenchmarkPump(b *testing.B) {
+	const size = 64 * 1024 * 1024
+	payload := bytes.Repeat([]byte{42}, 1024*1024)
+
+	for _, bench := range []struct {
+		name string
+		copy func(dst io.Writer, src io.Reader)
+	}{
+		{"legacy-1KiB", func(dst io.Writer, src io.Reader) { _ = legacyReadAndWrite(dst, src) }},
+		{"metered", func(dst io.Writer, src io.Reader) { <-pump(dst, src, newTrafficMeter(time.Now()), false, false) }},
+		{"zero-copy", func(dst io.Writer, src io.Reader) { <-pump(dst, src, newTrafficMeter(time.Now()), false, true) }},
+	} {
+		bench := bench
+		b.Run(bench.name, func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				listener, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					b.Fatal(err)
+				}
+				go func() {
+					server, err := listener.Accept()
+					if err != nil {
+						return
+					}
+					for sent := 0; sent < size; sent += len(payload) {
+						if _, err := server.Write(payload); err != nil {
+							break
+						}
+					}
+					_ = server.Close()
+				}()
+				conn, err := net.Dial("tcp", listener.Addr().String())
+				if err != nil {
+					b.Fatal(err)
+				}
+				pipeReader, pipeWriter, err := os.Pipe()
+				if err != nil {
+					b.Fatal(err)
+				}
+				drained := make(chan struct{})
+				go func() {
+					_, _ = io.Copy(ioutil.Discard, pipeReader)
+					close(drained)
+				}()
+				b.StartTimer()
+
+				bench.copy(pipeWriter, conn)
+
+				b.StopTimer()
+				_ = pipeWriter.Close()
+				<-drained
+				_ = pipeReader.Close()
+				_ = conn.Close()
+				_ = listener.Close()
+				b.StartTimer()
+			}
+		})
+	}
+}
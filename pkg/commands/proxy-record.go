@@ -0,0 +1,38 @@
+package commands
+
+import (
+	"go.uber.org/zap"
+	"moul.io/assh/v2/pkg/config"
+	"moul.io/assh/v2/pkg/record"
+	"moul.io/assh/v2/pkg/utils"
+)
+
+// startRecording creates the capture of a connection when the host has a Record directory, nil otherwise;
+// the recording is a debugging aid, the connection goes on when it fails
+func startRecording(host *config.Host, stats *ConnectionStats) *record.Recorder {
+	if host.Record == "" {
+		return nil
+	}
+	dir, err := utils.ExpandUser(host.Record)
+	if err != nil {
+		logger().Warn("Failed to record the connection", zap.String("record", host.Record), zap.Error(err))
+		return nil
+	}
+	maxSize, err := host.RecordMaxSizeBytes()
+	if err != nil {
+		logger().Warn("Failed to record the connection", zap.String("record-max-size", host.RecordMaxSize), zap.Error(err))
+		return nil
+	}
+	recorder, err := record.Create(dir, record.Header{
+		Host:      host.Name(),
+		HostName:  host.HostName,
+		Port:      host.Port,
+		StartedAt: stats.ConnectedAt,
+	}, record.Options{MaxSize: maxSize, MaxFiles: host.RecordMaxFilesCount()})
+	if err != nil {
+		logger().Warn("Failed to record the connection", zap.String("dir", dir), zap.Error(err))
+		return nil
+	}
+	logger().Debug("Recording the connection", zap.String("file", recorder.Path()))
+	return recorder
+}
@@ -0,0 +1,134 @@
+package commands
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// idlePeriodThreshold is the minimal time without traffic counted as an idle period
+const idlePeriodThreshold = 10 * time.Second
+
+// gatewayPathEnv is set by assh when it runs a gateway, so the nested `assh connect` knows the
+// hosts that are reached through its connection
+const gatewayPathEnv = "ASSH_GATEWAY_PATH"
+
+// trafficMeter measures the traffic of a connection in both directions
+type trafficMeter struct {
+	lock         sync.Mutex
+	start        time.Time
+	sent         uint64
+	received     uint64
+	firstByteAt  time.Time
+	lastActivity time.Time
+	idlePeriods  int
+	idleDuration time.Duration
+	longestIdle  time.Duration
+	window       time.Duration // index of the current one-second window since start
+	windowBytes  uint64
+	peak         uint64 // bytes per second
+	// unmeasured is true when bytes were counted without their time, see count
+	unmeasured bool
+}
+
+func newTrafficMeter(start time.Time) *trafficMeter {
+	return &trafficMeter{start: start, lastActivity: start}
+}
+
+// record accounts n bytes sent to the server, or received from it
+func (m *trafficMeter) record(sent bool, n int, now time.Time) {
+	if n <= 0 {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if sent {
+		m.sent += uint64(n)
+	} else {
+		m.received += uint64(n)
+		if m.firstByteAt.IsZero() {
+			m.firstByteAt = now
+		}
+	}
+
+	m.recordIdle(now)
+	m.lastActivity = now
+
+	window := now.Sub(m.start) / time.Second
+	if window != m.window {
+		m.closeWindow()
+		m.window = window
+	}
+	m.windowBytes += uint64(n)
+}
+
+// count accounts n bytes without knowing when they were transferred, the timings are not measured anymore
+func (m *trafficMeter) count(sent bool, n int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.unmeasured = true
+	if n <= 0 {
+		return
+	}
+	if sent {
+		m.sent += uint64(n)
+	} else {
+		m.received += uint64(n)
+	}
+}
+
+func (m *trafficMeter) recordIdle(now time.Time) {
+	if gap := now.Sub(m.lastActivity); gap >= idlePeriodThreshold {
+		m.idlePeriods++
+		m.idleDuration += gap
+		if gap > m.longestIdle {
+			m.longestIdle = gap
+		}
+	}
+}
+
+func (m *trafficMeter) closeWindow() {
+	if m.windowBytes > m.peak {
+		m.peak = m.windowBytes
+	}
+	m.windowBytes = 0
+}
+
+// finish accounts the idle period before the disconnection and fills the stats, the timings are left
+// zero when they were not measured
+func (m *trafficMeter) finish(stats *ConnectionStats, now time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	stats.SentBytes = m.sent
+	stats.ReceivedBytes = m.received
+	stats.TotalBytes = m.sent + m.received
+	stats.WrittenBytes = m.received
+	stats.TimingsMeasured = !m.unmeasured
+	if m.unmeasured {
+		return
+	}
+
+	m.recordIdle(now)
+	m.lastActivity = now
+	m.closeWindow()
+	if !m.firstByteAt.IsZero() {
+		stats.TimeToFirstByte = m.firstByteAt.Sub(m.start)
+	}
+	stats.PeakSpeed = float64(m.peak)
+	stats.IdlePeriods = m.idlePeriods
+	stats.IdleDuration = m.idleDuration
+	stats.LongestIdle = m.longestIdle
+}
+
+// currentGatewayPath returns the hosts reached through a connection to host, i.e: [gw, web] when
+// assh connects to gw to reach web
+func currentGatewayPath(host string) []string {
+	path := []string{host}
+	if env := os.Getenv(gatewayPathEnv); env != "" {
+		path = append(path, strings.Split(env, ",")...)
+	}
+	return path
+}
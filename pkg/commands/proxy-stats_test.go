@@ -0,0 +1,63 @@
+package commands
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTrafficMeter(t *testing.T) {
+	Convey("Testing trafficMeter", t, func() {
+		start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		meter := newTrafficMeter(start)
+
+		meter.record(true, 21, start.Add(10*time.Millisecond))  // client identification
+		meter.record(false, 40, start.Add(30*time.Millisecond)) // server identification
+		meter.record(false, 1000, start.Add(500*time.Millisecond))
+		meter.record(true, 300, start.Add(1200*time.Millisecond))
+		meter.record(false, 0, start.Add(time.Minute))
+		meter.record(false, 100, start.Add(15*time.Second)) // after 13.8s idle
+		meter.record(true, 10, start.Add(16*time.Second))
+
+		stats := ConnectionStats{}
+		meter.finish(&stats, start.Add(40*time.Second)) // after 24s idle
+		So(stats.SentBytes, ShouldEqual, 331)
+		So(stats.ReceivedBytes, ShouldEqual, 1140)
+		So(stats.TotalBytes, ShouldEqual, 1471)
+		So(stats.WrittenBytes, ShouldEqual, 1140)
+		So(stats.TimeToFirstByte, ShouldEqual, 30*time.Millisecond)
+		So(stats.PeakSpeed, ShouldEqual, 1061)
+		So(stats.IdlePeriods, ShouldEqual, 2)
+		So(stats.IdleDuration, ShouldEqual, 13800*time.Millisecond+24*time.Second)
+		So(stats.LongestIdle, ShouldEqual, 24*time.Second)
+		So(stats.TimingsMeasured, ShouldBeTrue)
+
+		Convey("zero-copy", func() {
+			meter := newTrafficMeter(start)
+			meter.count(true, 300)
+			meter.count(false, 50000)
+
+			stats := ConnectionStats{}
+			meter.finish(&stats, start.Add(time.Hour))
+			So(stats.SentBytes, ShouldEqual, 300)
+			So(stats.ReceivedBytes, ShouldEqual, 50000)
+			So(stats.TimingsMeasured, ShouldBeFalse)
+			So(stats.TimeToFirstByte, ShouldEqual, 0)
+			So(stats.PeakSpeed, ShouldEqual, 0)
+			So(stats.IdlePeriods, ShouldEqual, 0)
+			So(stats.LongestIdle, ShouldEqual, 0)
+		})
+	})
+
+	Convey("Testing currentGatewayPath()", t, func() {
+		oldPath := os.Getenv(gatewayPathEnv)
+		defer os.Setenv(gatewayPathEnv, oldPath)
+
+		So(os.Unsetenv(gatewayPathEnv), ShouldBeNil)
+		So(currentGatewayPath("web"), ShouldResemble, []string{"web"})
+		So(os.Setenv(gatewayPathEnv, "gw1,web"), ShouldBeNil)
+		So(currentGatewayPath("gw2"), ShouldResemble, []string{"gw2", "gw1", "web"})
+	})
+}
@@ -2,6 +2,7 @@ package commands
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,11 +11,9 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
-	"os/user"
 	"path"
 	"strconv"
 	"strings"
-	"sync"
 	"syscall"
 	"time"
 
@@ -24,21 +23,16 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
-	"golang.org/x/net/context"
-	"golang.org/x/time/rate"
 	"moul.io/assh/v2/pkg/config"
 	"moul.io/assh/v2/pkg/ratelimit"
+	"moul.io/assh/v2/pkg/record"
 )
 
-type contextKey string
-
 type gatewayErrorMsg struct {
 	gateway string
 	err     zap.Field
 }
 
-var syncContextKey contextKey = "sync"
-
 var proxyCommand = &cobra.Command{
 	Use:     "connect",
 	Short:   "Connect to host SSH socket, used by ProxyCommand",
@@ -158,67 +152,12 @@ func computeHost(dest string, portOverride int, conf *config.Config) (*config.Ho
 	return host, nil
 }
 
-func expandSSHTokens(tokenized string, host *config.Host) string {
-	result := tokenized
-
-	// OpenSSH Token Cheatsheet (stolen directly from the man pages)
-	//
-	// %%    A literal `%'.
-	// %C    Shorthand for %l%h%p%r.
-	// %d    Local user's home directory.
-	// %h    The remote hostname.
-	// %i    The local user ID.
-	// %L    The local hostname.
-	// %l    The local hostname, including the domain name.
-	// %n    The original remote hostname, as given on the command line.
-	// %p    The remote port.
-	// %r    The remote username.
-	// %u    The local username.
-
-	// TODO: Expansion of strings like "%%C" and "%C" are equivalent due to the
-	//       order that tokens are evaluated.  Should look at how OpenSSH implements
-	//       the tokenization behavior.
-
-	// Expand a home directory ~.  Assume nobody is using
-	// the ~otheruser syntax.
-	homedir := os.ExpandEnv("$HOME")
-
-	if result[0] == '~' {
-		result = strings.Replace(result, "~", homedir, 1)
-	}
-	result = strings.ReplaceAll(result, "%d", homedir)
-
-	result = strings.ReplaceAll(result, "%%", "%")
-	result = strings.ReplaceAll(result, "%C", "%l%h%p%r")
-	result = strings.ReplaceAll(result, "%h", host.Name())
-	result = strings.ReplaceAll(result, "%i", strconv.Itoa(os.Geteuid()))
-	result = strings.ReplaceAll(result, "%p", host.Port)
-
-	if hostname, err := os.Hostname(); err == nil {
-		result = strings.ReplaceAll(result, "%L", hostname)
-	} else {
-		result = strings.ReplaceAll(result, "%L", "hostname")
-	}
-
-	if host.User != "" {
-		result = strings.ReplaceAll(result, "%r", host.User)
-	} else {
-		if userdata, err := user.Current(); err == nil {
-			result = strings.ReplaceAll(result, "%r", userdata.Username)
-		} else {
-			result = strings.ReplaceAll(result, "%r", "username")
-		}
-	}
-
-	return result
-}
-
 func prepareHostControlPath(host *config.Host) error {
 	if !config.BoolVal(host.ControlMasterMkdir) || ("none" == host.ControlPath || "" == host.ControlPath) {
 		return nil
 	}
 
-	controlPath := expandSSHTokens(host.ControlPath, host)
+	controlPath := host.ExpandControlPath()
 	controlPathDir := path.Dir(controlPath)
 	logger().Debug("Creating control path", zap.String("path", controlPathDir))
 	return os.MkdirAll(controlPathDir, 0700)
@@ -232,9 +171,14 @@ func proxy(host *config.Host, conf *config.Config, dryRun bool) error {
 	if len(host.Gateways) > 0 {
 		logger().Debug("Trying gateways", zap.String("gateways", strings.Join(host.Gateways, ", ")))
 		var gatewayErrors []gatewayErrorMsg
+		// the nested `assh connect` reports the hosts reached through its connection
+		gatewayPath := strings.Join(currentGatewayPath(host.Name()), ",")
 		for _, gateway := range host.Gateways {
 			if gateway == "direct" {
-				if err := proxyDirect(host, dryRun); err != nil {
+				startedAt := time.Now()
+				stats, err := proxyDirect(host, gateway, dryRun)
+				recordConnection(conf, host, gateway, startedAt, stats, err, dryRun)
+				if err != nil {
 					gatewayErrors = append(gatewayErrors, gatewayErrorMsg{
 						gateway: "direct", err: zap.Error(err)})
 				} else {
@@ -250,8 +194,6 @@ func proxy(host *config.Host, conf *config.Config, dryRun bool) error {
 
 				// FIXME: dynamically add "-v" flags
 
-				var command string
-
 				// FIXME: detect ssh client version and use netcat if too old
 				// for now, the workaround is to configure the ProxyCommand of the host to "nc %h %p"
 
@@ -259,18 +201,17 @@ func proxy(host *config.Host, conf *config.Config, dryRun bool) error {
 					return errors.Wrap(err, "failed to prepare host for gateway")
 				}
 
-				if hostCopy.ProxyCommand != "" {
-					command = "ssh %name -- " + hostCopy.ExpandString(hostCopy.ProxyCommand, gateway)
-				} else {
-					command = hostCopy.ExpandString("ssh -W %h:%p ", "") + "%name"
-				}
+				command := gatewayCommand(hostCopy, gateway)
 
 				logger().Debug(
 					"Using gateway",
 					zap.String("gateway", gateway),
 					zap.String("command", command),
 				)
-				if err := runProxy(gatewayHost, command, dryRun); err != nil {
+				startedAt := time.Now()
+				err := runProxy(gatewayHost, command, dryRun, gatewayPathEnv+"="+gatewayPath)
+				recordConnection(conf, hostCopy, gateway, startedAt, nil, err, dryRun)
+				if err != nil {
 					gatewayErrors = append(gatewayErrors, gatewayErrorMsg{
 						gateway: gateway, err: zap.Error(err)})
 				} else {
@@ -293,17 +234,31 @@ func proxy(host *config.Host, conf *config.Config, dryRun bool) error {
 	}
 
 	logger().Debug("Connecting without gateway")
-	return proxyDirect(host, dryRun)
+	startedAt := time.Now()
+	stats, err := proxyDirect(host, "", dryRun)
+	recordConnection(conf, host, "", startedAt, stats, err, dryRun)
+	return err
+}
+
+// gatewayCommand returns the command reaching the prepared host through the gateway, its `%name` is
+// expanded with the gateway
+func gatewayCommand(host *config.Host, gateway string) string {
+	if host.ProxyCommand != "" {
+		return "ssh %name -- " + host.ExpandString(host.ProxyCommand, gateway)
+	}
+	return host.ExpandString("ssh -W %h:%p ", "") + "%name"
 }
 
-func proxyDirect(host *config.Host, dryRun bool) error {
+// proxyDirect connects to the host without gateway, the stats are nil when the ProxyCommand is used
+func proxyDirect(host *config.Host, gateway string, dryRun bool) (*ConnectionStats, error) {
 	if host.ProxyCommand != "" {
-		return runProxy(host, host.ProxyCommand, dryRun)
+		return nil, runProxy(host, host.ProxyCommand, dryRun)
 	}
-	return proxyGo(host, dryRun)
+	return proxyGo(host, gateway, dryRun)
 }
 
-func runProxy(host *config.Host, command string, dryRun bool) error {
+// runProxy runs a command connected to the standard input and output of assh, env is added to its environment
+func runProxy(host *config.Host, command string, dryRun bool, env ...string) error {
 	command = host.ExpandString(command, "")
 	logger().Debug("ProxyCommand", zap.String("command", command))
 	args, err := shlex.Split(command)
@@ -319,6 +274,9 @@ func runProxy(host *config.Host, command string, dryRun bool) error {
 	spawn.Stdout = os.Stdout
 	spawn.Stdin = os.Stdin
 	spawn.Stderr = os.Stderr
+	if len(env) > 0 {
+		spawn.Env = append(os.Environ(), env...)
+	}
 	return spawn.Run()
 }
 
@@ -372,22 +330,43 @@ func hostPrepare(host *config.Host, gateway string) error {
 	return nil
 }
 
-type exportReadWrite struct {
-	written uint64
-	err     error
-}
-
 // ConnectionStats contains network and timing informations about a connection
 type ConnectionStats struct {
+	// WrittenBytes is the amount of bytes received from the server, kept for compatibility with ReceivedBytes
 	WrittenBytes            uint64
 	WrittenBytesHuman       string
+	SentBytes               uint64
+	SentBytesHuman          string
+	ReceivedBytes           uint64
+	ReceivedBytesHuman      string
+	TotalBytes              uint64
+	TotalBytesHuman         string
 	CreatedAt               time.Time
 	ConnectedAt             time.Time
 	DisconnectedAt          time.Time
 	ConnectionDuration      time.Duration
 	ConnectionDurationHuman string
-	AverageSpeed            float64
-	AverageSpeedHuman       string
+	// TimingsMeasured is false when the traffic was copied without userland buffers, TimeToFirstByte,
+	// PeakSpeed and the idle periods are then zero
+	TimingsMeasured bool
+	TimeToFirstByte time.Duration
+	// AverageSpeed and PeakSpeed are in bytes per second, in both directions
+	AverageSpeed      float64
+	AverageSpeedHuman string
+	PeakSpeed         float64
+	PeakSpeedHuman    string
+	// IdlePeriods counts the periods of at least 10 seconds without traffic
+	IdlePeriods  int
+	IdleDuration time.Duration
+	LongestIdle  time.Duration
+	// RateLimitedBytes counts the bytes that went through the rate limiters, ThrottledUp and ThrottledDown
+	// the time spent waiting for them
+	RateLimitedBytes uint64
+	ThrottledUp      time.Duration
+	ThrottledDown    time.Duration
+	Gateway          string
+	GatewayPath      []string
+	GatewayPathHuman string
 }
 
 func (c *ConnectionStats) String() string {
@@ -415,10 +394,13 @@ func (c ConnectHookArgs) String() string {
 	return string(b)
 }
 
-func proxyGo(host *config.Host, dryRun bool) error {
+func proxyGo(host *config.Host, gateway string, dryRun bool) (*ConnectionStats, error) {
 	stats := ConnectionStats{
-		CreatedAt: time.Now(),
+		CreatedAt:   time.Now(),
+		Gateway:     gateway,
+		GatewayPath: currentGatewayPath(host.Name()),
 	}
+	stats.GatewayPathHuman = strings.Join(stats.GatewayPath, " -> ")
 	connectHookArgs := ConnectHookArgs{
 		Host:  host,
 		Stats: &stats,
@@ -426,11 +408,17 @@ func proxyGo(host *config.Host, dryRun bool) error {
 
 	logger().Debug("Preparing host object")
 	if err := hostPrepare(host, ""); err != nil {
-		return errors.Wrap(err, "failed to prepare host")
+		return &stats, errors.Wrap(err, "failed to prepare host")
 	}
 
 	if dryRun {
-		return fmt.Errorf("dry-run: Golang native TCP connection to '%s:%s'", host.HostName, host.Port)
+		return &stats, fmt.Errorf("dry-run: Golang native TCP connection to '%s:%s'", host.HostName, host.Port)
+	}
+
+	// the limits are parsed before dialing, an invalid configuration does not leave a connection open
+	throttles, err := newConnThrottles(host)
+	if err != nil {
+		return &stats, errors.Wrap(err, "failed to parse rate limit configuration")
 	}
 
 	// BeforeConnect hook
@@ -466,7 +454,7 @@ func proxyGo(host *config.Host, dryRun bool) error {
 			defer drivers.Close()
 		}
 
-		return errors.Wrap(err, "failed to dial")
+		return &stats, errors.Wrap(err, "failed to dial")
 	}
 	logger().Debug(
 		"Connected",
@@ -474,6 +462,7 @@ func proxyGo(host *config.Host, dryRun bool) error {
 		zap.String("port", host.Port),
 	)
 	stats.ConnectedAt = time.Now()
+	meter := newTrafficMeter(stats.ConnectedAt)
 
 	// OnConnect hook
 	logger().Debug("Calling OnConnect hooks")
@@ -486,59 +475,61 @@ func proxyGo(host *config.Host, dryRun bool) error {
 	// Ignore SIGHUP
 	signal.Ignore(syscall.SIGHUP)
 
-	waitGroup := sync.WaitGroup{}
 	result := exportReadWrite{}
 
+	// cancelling the context interrupts the pumps waiting for the rate limiters
 	ctx, cancel := context.WithCancel(context.Background())
-	ctx = context.WithValue(ctx, syncContextKey, &waitGroup)
-
-	waitGroup.Add(2)
-
-	var reader io.Reader
-	var writer io.Writer
-	reader = conn
-	writer = conn
-	if host.RateLimit != "" {
-		bytes, err := humanize.ParseBytes(host.RateLimit)
-		if err != nil {
-			return errors.Wrap(err, "failed to parse rate limit configuration")
-		}
-		limit := rate.Limit(float64(bytes))
-		limiter := rate.NewLimiter(limit, int(bytes))
-		reader = ratelimit.NewReader(conn, limiter)
-		writer = ratelimit.NewWriter(conn, limiter)
+	defer cancel()
+	reader, writer := throttles.wrap(ctx, conn)
+
+	// the frames are timestamped when the data is read from ssh and from the server
+	var stdin io.Reader = os.Stdin
+	recorder := startRecording(host, &stats)
+	if recorder != nil {
+		defer func() {
+			if err := recorder.Close(); err != nil {
+				logger().Warn("Failed to close the capture", zap.String("file", recorder.Path()), zap.Error(err))
+			}
+		}()
+		reader = recorder.Reader(reader, record.Received)
+		stdin = recorder.Reader(stdin, record.Sent)
 	}
 
-	c1 := readAndWrite(ctx, reader, os.Stdout)
-	c2 := readAndWrite(ctx, os.Stdin, writer)
+	// the rate limiters, the recorder and the OnDisconnect hooks, which read the stats, need the metered copy
+	zeroCopy := throttles == nil && recorder == nil && len(host.Hooks.OnDisconnect) == 0
+	toClient := pump(os.Stdout, reader, meter, false, zeroCopy)
+	toServer := pump(writer, stdin, meter, true, zeroCopy)
 	select {
-	case result = <-c1:
-		stats.WrittenBytes = result.written
-	case result = <-c2:
-	}
-	if result.err != nil && result.err == io.EOF {
-		result.err = nil
+	case result = <-toClient:
+	case result = <-toServer:
+		// stdin is closed: half-close the connection and let the server send its remaining data
+		if tcpConn, ok := conn.(*net.TCPConn); ok && result.err == nil {
+			if err := tcpConn.CloseWrite(); err == nil {
+				result = <-toClient
+			}
+		}
 	}
 
-	if err := conn.Close(); err != nil {
-		return err
-	}
+	// the pump of stdin may be blocked on a read, ssh closes the pipe when it exits
 	cancel()
-	waitGroup.Wait()
-	select {
-	case res := <-c1:
-		stats.WrittenBytes = res.written
-	default:
+	if err := conn.Close(); err != nil {
+		return &stats, err
 	}
 
 	stats.DisconnectedAt = time.Now()
+	meter.finish(&stats, stats.DisconnectedAt)
+	throttles.finish(&stats)
 	stats.ConnectionDuration = stats.DisconnectedAt.Sub(stats.ConnectedAt)
-	averageSpeed := float64(stats.WrittenBytes) / stats.ConnectionDuration.Seconds()
+	averageSpeed := float64(stats.TotalBytes) / stats.ConnectionDuration.Seconds()
 	// round duraction
 	stats.ConnectionDuration = ((stats.ConnectionDuration + time.Second/2) / time.Second) * time.Second
 	stats.AverageSpeed = math.Ceil(averageSpeed*1000) / 1000
 	// human
 	stats.WrittenBytesHuman = humanize.Bytes(stats.WrittenBytes)
+	stats.SentBytesHuman = humanize.Bytes(stats.SentBytes)
+	stats.ReceivedBytesHuman = humanize.Bytes(stats.ReceivedBytes)
+	stats.TotalBytesHuman = humanize.Bytes(stats.TotalBytes)
+	stats.PeakSpeedHuman = humanize.Bytes(uint64(stats.PeakSpeed)) + "/s"
 	connectionDurationHuman := humanize.RelTime(stats.DisconnectedAt, stats.ConnectedAt, "", "")
 	stats.ConnectionDurationHuman = strings.ReplaceAll(connectionDurationHuman, "now", "0 sec")
 	stats.AverageSpeedHuman = humanize.Bytes(uint64(stats.AverageSpeed)) + "/s"
@@ -553,45 +544,71 @@ func proxyGo(host *config.Host, dryRun bool) error {
 
 	logger().Debug(
 		"Connection finished",
-		zap.Uint64("bytes written", stats.WrittenBytes),
+		zap.Uint64("bytes sent", stats.SentBytes),
+		zap.Uint64("bytes received", stats.ReceivedBytes),
 		zap.Error(result.err),
 	)
-	return result.err
+	return &stats, result.err
 }
 
-func readAndWrite(ctx context.Context, r io.Reader, w io.Writer) <-chan exportReadWrite {
-	buff := make([]byte, 1024)
-	c := make(chan exportReadWrite, 1)
-
-	go func() {
-		defer ctx.Value(syncContextKey).(*sync.WaitGroup).Done()
-
-		export := exportReadWrite{}
-		for {
-			select {
-			case <-ctx.Done():
-				c <- export
-				return
-			default:
-				nr, err := r.Read(buff)
-				if err != nil {
-					export.err = err
-					c <- export
-					return
-				}
-				if nr > 0 {
-					wr, err := w.Write(buff[:nr])
-					if err != nil {
-						export.err = err
-						c <- export
-						return
-					}
-					if wr > 0 {
-						export.written += uint64(wr)
-					}
-				}
-			}
+// connThrottles are the rate limits of the data sent to the server and received from it
+type connThrottles struct {
+	up, down *ratelimit.Throttle
+}
+
+// finish fills the stats with the counters of the throttles
+func (t *connThrottles) finish(stats *ConnectionStats) {
+	if t == nil {
+		return
+	}
+	up, down := t.up.Stats(), t.down.Stats()
+	stats.RateLimitedBytes = up.Bytes + down.Bytes
+	stats.ThrottledUp = up.Throttled
+	stats.ThrottledDown = down.Throttled
+}
+
+// newConnThrottles returns the rate limits of the host, nil when unlimited: RateLimit is shared by both directions,
+// RateLimitUp limits the data sent to the server and RateLimitDown the data received from it. With a
+// RateLimitGroup, the limits are shared by all the connections of the group on the machine
+func newConnThrottles(host *config.Host) (*connThrottles, error) {
+	burst, err := host.RateLimitBurstBytes()
+	if err != nil {
+		return nil, err
+	}
+	limiters := map[string]*ratelimit.Limiter{}
+	for name, value := range map[string]string{"both": host.RateLimit, "up": host.RateLimitUp, "down": host.RateLimitDown} {
+		schedule, err := ratelimit.ParseSchedule(value)
+		if err != nil {
+			return nil, err
+		}
+		if schedule.IsUnlimited() {
+			continue
+		}
+		limiters[name] = ratelimit.NewLimiter(schedule, burst)
+		if host.RateLimitGroup == "" {
+			continue
 		}
-	}()
-	return c
+		// the bucket is shared with the other connections of the group
+		group := fmt.Sprintf("%s.%s", host.RateLimitGroup, name)
+		if limiter, err := ratelimit.NewGroupLimiter(group, schedule, burst); err == nil {
+			limiters[name] = limiter
+		} else {
+			logger().Warn("Failed to join the rate limit group, limiting this connection only", zap.String("group", group), zap.Error(err))
+		}
+	}
+	if len(limiters) == 0 {
+		return nil, nil
+	}
+	return &connThrottles{
+		up:   ratelimit.NewThrottle(limiters["both"], limiters["up"]),
+		down: ratelimit.NewThrottle(limiters["both"], limiters["down"]),
+	}, nil
+}
+
+// wrap limits the data read from and written to a connection
+func (t *connThrottles) wrap(ctx context.Context, conn net.Conn) (io.Reader, io.Writer) {
+	if t == nil {
+		return conn, conn
+	}
+	return t.down.Reader(ctx, conn), t.up.Writer(ctx, conn)
 }
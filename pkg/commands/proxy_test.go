@@ -2,8 +2,10 @@ package commands
 
 import (
 	"fmt"
+	"net"
 	"strings"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 	"moul.io/assh/v2/pkg/config"
@@ -110,3 +112,33 @@ func Test_hostPrepare(t *testing.T) {
 		So(host.HostName, ShouldEqual, "42.42.42.42")
 	})
 }
+
+func Test_proxyGoRateLimit(t *testing.T) {
+	Convey("Testing proxyGo() with an invalid rate limit", t, func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer listener.Close()
+		accepted := make(chan struct{}, 1)
+		go func() {
+			if conn, err := listener.Accept(); err == nil {
+				accepted <- struct{}{}
+				_ = conn.Close()
+			}
+		}()
+
+		_, port, err := net.SplitHostPort(listener.Addr().String())
+		So(err, ShouldBeNil)
+		host := config.NewHost("ratelimited")
+		host.HostName, host.Port, host.RateLimit = "127.0.0.1", port, "garbage"
+		_, err = proxyGo(host, "direct", false)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldStartWith, "failed to parse rate limit configuration")
+
+		// the connection is not opened
+		select {
+		case <-accepted:
+			t.Error("the host was dialed")
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+}
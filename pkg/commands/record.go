@@ -0,0 +1,123 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"moul.io/assh/v2/pkg/record"
+)
+
+var recordCommand = &cobra.Command{
+	Use:   "record",
+	Short: "Manage the captures of the hosts with a Record directory",
+}
+
+var inspectRecordCommand = &cobra.Command{
+	Use:     "inspect <file>...",
+	Short:   "Summarize the SSH version exchange and packet boundaries of captures",
+	Example: "  assh record inspect ~/.ssh/captures/web-20200131T091203.000000000Z.assh-rec",
+	Args:    cobra.MinimumNArgs(1),
+	RunE:    runInspectRecordCommand,
+}
+
+// nolint:gochecknoinits
+func init() {
+	inspectRecordCommand.Flags().BoolP("json", "", false, "Print the summaries as JSON")
+	recordCommand.AddCommand(inspectRecordCommand)
+}
+
+func runInspectRecordCommand(cmd *cobra.Command, args []string) error {
+	jsonOutput, _ := cmd.Flags().GetBool("json")
+	summaries := []*record.Summary{}
+	for idx, path := range args {
+		header, frames, err := record.Open(path)
+		if header == nil {
+			return errors.Wrapf(err, "failed to read %q", path)
+		}
+		if err != nil {
+			// keep the frames written before a crash
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		}
+		summary := record.Inspect(*header, frames)
+		if jsonOutput {
+			summaries = append(summaries, summary)
+			continue
+		}
+		if idx > 0 {
+			fmt.Println()
+		}
+		printRecordSummary(os.Stdout, path, summary)
+	}
+
+	if jsonOutput {
+		out, err := json.MarshalIndent(summaries, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+	}
+	return nil
+}
+
+func printRecordSummary(w io.Writer, path string, summary *record.Summary) {
+	header := summary.Header
+	_, _ = fmt.Fprintf(w, "%s\n", path)
+	_, _ = fmt.Fprintf(w, "Host: %s (%s:%s), connected at %s\n", header.Host, header.HostName, header.Port, header.StartedAt.Local().Format(time.RFC3339))
+	truncated := ""
+	if summary.Truncated {
+		truncated = ", truncated by RecordMaxSize"
+	}
+	_, _ = fmt.Fprintf(w, "Duration: %s, %d frames%s\n", summary.Duration.Round(time.Millisecond), summary.Frames, truncated)
+	if summary.LongestSilence > 0 {
+		_, _ = fmt.Fprintf(w, "Longest silence: %s at +%s\n", summary.LongestSilence.Round(time.Millisecond), summary.SilenceAt.Round(time.Millisecond))
+	}
+	if summary.LastSpeaker != "" {
+		_, _ = fmt.Fprintf(w, "Last frame: %s\n", summary.LastSpeaker)
+	}
+
+	for _, name := range []string{"client", "server"} {
+		stream := summary.Streams[name]
+		_, _ = fmt.Fprintf(w, "\n%s: %s in %d frames\n", strings.Title(name), humanize.Bytes(uint64(stream.Bytes)), stream.Frames)
+		for _, line := range stream.PreVersion {
+			_, _ = fmt.Fprintf(w, "  line before the version: %q\n", line)
+		}
+		if stream.Version != "" {
+			_, _ = fmt.Fprintf(w, "  +%-10s version %s\n", stream.VersionAt.Round(time.Millisecond), stream.Version)
+		}
+		for _, packet := range stream.Packets {
+			line := fmt.Sprintf("  +%-10s %s (%d bytes)", packet.Offset.Round(time.Millisecond), packet.Name, packet.Length)
+			if packet.Details != "" {
+				line += " " + packet.Details
+			}
+			_, _ = fmt.Fprintln(w, line)
+		}
+		if stream.EncryptedBytes > 0 {
+			_, _ = fmt.Fprintf(w, "  +%-10s encrypted: %s\n", stream.EncryptedAt.Round(time.Millisecond), humanize.Bytes(uint64(stream.EncryptedBytes)))
+		}
+		if stream.Error != "" {
+			_, _ = fmt.Fprintf(w, "  error: %s\n", stream.Error)
+		}
+	}
+
+	if negotiated := summary.Negotiated; negotiated != nil {
+		first := func(list []string) string {
+			if len(list) == 0 {
+				return "<none>"
+			}
+			return list[0]
+		}
+		_, _ = fmt.Fprintf(w, "\nNegotiated: kex=%s hostkey=%s cipher=%s/%s mac=%s/%s compression=%s/%s\n",
+			first(negotiated.Kex), first(negotiated.HostKey),
+			first(negotiated.CiphersClient), first(negotiated.CiphersServer),
+			first(negotiated.MACsClient), first(negotiated.MACsServer),
+			first(negotiated.CompressionClient), first(negotiated.CompressionServer),
+		)
+	}
+}
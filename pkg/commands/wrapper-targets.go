@@ -0,0 +1,227 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	shlex "github.com/flynn/go-shlex"
+)
+
+// wrapperOptions describes the options of a wrapped command that take a value
+type wrapperOptions struct {
+	// short options taking a value, i.e: "io" for `-i file -o option`
+	short string
+	// long options taking a value, i.e: "rsh" for `--rsh ssh` or `--rsh=ssh`
+	long []string
+	// stopAtOperand stops parsing the options at the first operand, like getopt(3) on BSD does
+	stopAtOperand bool
+}
+
+// parseWrapperArgs splits the arguments of a wrapped command into operands and option values,
+// boolean options are ignored
+func parseWrapperArgs(args []string, options wrapperOptions) (operands []string, values map[string][]string) {
+	values = map[string][]string{}
+	longWithValue := map[string]bool{}
+	for _, name := range options.long {
+		longWithValue[name] = true
+	}
+
+	for idx := 0; idx < len(args); idx++ {
+		arg := args[idx]
+		switch {
+		case arg == "--":
+			return append(operands, args[idx+1:]...), values
+		case arg == "-" || !strings.HasPrefix(arg, "-"):
+			operands = append(operands, arg)
+			if options.stopAtOperand {
+				return append(operands, args[idx+1:]...), values
+			}
+		case strings.HasPrefix(arg, "--"):
+			name := arg[2:]
+			if eq := strings.Index(name, "="); eq >= 0 {
+				values[name[:eq]] = append(values[name[:eq]], name[eq+1:])
+			} else if longWithValue[name] && idx+1 < len(args) {
+				idx++
+				values[name] = append(values[name], args[idx])
+			}
+		default:
+			// grouped short options, i.e: `-avz`, `-avze ssh` or `-p22`
+			for pos := 1; pos < len(arg); pos++ {
+				name := arg[pos : pos+1]
+				if !strings.Contains(options.short, name) {
+					continue
+				}
+				if pos+1 < len(arg) {
+					values[name] = append(values[name], arg[pos+1:])
+				} else if idx+1 < len(args) {
+					idx++
+					values[name] = append(values[name], args[idx])
+				}
+				break
+			}
+		}
+	}
+	return operands, values
+}
+
+// remoteHost returns the host of a remote operand: `host:path`, `user@host:path`, `user@[::1]:path`
+// or `scheme://user@host:port/path`; local paths, i.e: `./file:name` or `/tmp/a:b`, are ignored
+func remoteHost(operand string, scheme string) (string, bool) {
+	if scheme != "" && strings.HasPrefix(operand, scheme+"://") {
+		authority := strings.SplitN(strings.TrimPrefix(operand, scheme+"://"), "/", 2)[0]
+		return stripUserPort(authority), authority != ""
+	}
+
+	// same logic as colon() in OpenSSH's scp
+	bracket := false
+	for idx := 0; idx < len(operand); idx++ {
+		switch char := operand[idx]; {
+		case char == '@' && idx+1 < len(operand) && operand[idx+1] == '[':
+			bracket = true
+		case char == ']' && idx+1 < len(operand) && operand[idx+1] == ':' && bracket:
+			return stripUserPort(operand[:idx+1]), true
+		case char == ':' && !bracket:
+			if idx == 0 {
+				return "", false
+			}
+			return stripUserPort(operand[:idx]), true
+		case char == '[' && idx == 0:
+			bracket = true
+		case char == '/':
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// stripUserPort removes the user, the port and the brackets of an IPv6 address
+func stripUserPort(destination string) string {
+	if idx := strings.LastIndex(destination, "@"); idx >= 0 {
+		destination = destination[idx+1:]
+	}
+	if strings.HasPrefix(destination, "[") {
+		if end := strings.Index(destination, "]"); end > 0 {
+			return destination[1:end]
+		}
+	}
+	if strings.Count(destination, ":") == 1 {
+		destination = strings.SplitN(destination, ":", 2)[0]
+	}
+	return destination
+}
+
+// jumpHosts returns the hosts of a -J option: `[user@]host[:port][,...]`
+func jumpHosts(values []string) []string {
+	hosts := []string{}
+	for _, value := range values {
+		if value == "none" {
+			continue
+		}
+		for _, jump := range strings.Split(value, ",") {
+			jump = strings.TrimPrefix(jump, "ssh://")
+			if host := stripUserPort(jump); host != "" {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	return hosts
+}
+
+var scpOptions = wrapperOptions{short: "cDFiJloPSX", stopAtOperand: true}
+
+// scpTargets returns the remote hosts of a scp command line
+func scpTargets(args []string) []string {
+	operands, values := parseWrapperArgs(args, scpOptions)
+	targets := jumpHosts(values["J"])
+	for _, operand := range operands {
+		if host, ok := remoteHost(operand, "scp"); ok {
+			targets = append(targets, host)
+		}
+	}
+	return uniqueStrings(targets)
+}
+
+var sftpOptions = wrapperOptions{short: "BbcDFiJloPRSsX", stopAtOperand: true}
+
+// sftpTargets returns the remote hosts of a sftp command line, the destination may not have a path
+func sftpTargets(args []string) []string {
+	operands, values := parseWrapperArgs(args, sftpOptions)
+	targets := jumpHosts(values["J"])
+	if len(operands) > 0 {
+		if host, ok := remoteHost(operands[0], "sftp"); ok {
+			targets = append(targets, host)
+		} else if !strings.Contains(operands[0], "/") {
+			targets = append(targets, stripUserPort(operands[0]))
+		}
+	}
+	return uniqueStrings(targets)
+}
+
+var rsyncOptions = wrapperOptions{
+	short: "eBfTM@",
+	long: []string{
+		"rsh", "rsync-path", "filter", "exclude", "include", "exclude-from", "include-from", "files-from",
+		"temp-dir", "compare-dest", "copy-dest", "link-dest", "backup-dir", "suffix", "chmod", "chown",
+		"usermap", "groupmap", "timeout", "contimeout", "partial-dir", "log-file", "log-file-format",
+		"out-format", "password-file", "bwlimit", "block-size", "max-size", "min-size", "max-delete",
+		"modify-window", "compress-level", "compress-choice", "checksum-choice", "skip-compress",
+		"protocol", "iconv", "remote-option", "sockopts", "port", "address", "info", "debug",
+		"write-batch", "only-write-batch", "read-batch", "outbuf", "stop-after", "stop-at",
+	},
+}
+
+// rsyncTargets returns the remote hosts of a rsync command line, when its remote shell is ssh;
+// the rsync daemon operands (`host::module` and `rsync://`) do not use ssh
+func rsyncTargets(args []string) []string {
+	operands, values := parseWrapperArgs(args, rsyncOptions)
+
+	rsh := os.Getenv("RSYNC_RSH")
+	for _, name := range []string{"e", "rsh"} {
+		if len(values[name]) > 0 {
+			rsh = values[name][len(values[name])-1]
+		}
+	}
+	if !isSSHCommand(rsh) {
+		return []string{}
+	}
+
+	targets := []string{}
+	for _, operand := range operands {
+		if strings.HasPrefix(operand, "rsync://") || strings.Contains(operand, "::") {
+			continue
+		}
+		if host, ok := remoteHost(operand, ""); ok {
+			targets = append(targets, host)
+		}
+	}
+	return uniqueStrings(targets)
+}
+
+var moshOptions = wrapperOptions{
+	short:         "p",
+	long:          []string{"client", "server", "ssh", "predict", "family", "port", "bind-server", "experimental-remote-ip"},
+	stopAtOperand: true,
+}
+
+// moshTargets returns the remote host of a mosh command line, the following operands are the remote command
+func moshTargets(args []string) []string {
+	operands, values := parseWrapperArgs(args, moshOptions)
+	if len(values["ssh"]) > 0 && !isSSHCommand(values["ssh"][len(values["ssh"])-1]) {
+		return []string{}
+	}
+	if len(operands) == 0 {
+		return []string{}
+	}
+	return []string{stripUserPort(operands[0])}
+}
+
+// isSSHCommand returns true if a remote shell command runs ssh (or the assh wrapper), an empty command is ssh
+func isSSHCommand(command string) bool {
+	args, err := shlex.Split(command)
+	if err != nil || len(args) == 0 {
+		return command == ""
+	}
+	name := filepath.Base(args[0])
+	return name == "ssh" || name == "assh"
+}
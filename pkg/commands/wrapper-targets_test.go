@@ -0,0 +1,49 @@
+package commands
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWrapperTargets(t *testing.T) {
+	Convey("Testing the targets of the wrapped commands", t, func() {
+		oldRsyncRSH := os.Getenv("RSYNC_RSH")
+		So(os.Unsetenv("RSYNC_RSH"), ShouldBeNil)
+		defer os.Setenv("RSYNC_RSH", oldRsyncRSH)
+
+		for _, test := range []struct {
+			targets  func([]string) []string
+			args     []string
+			expected []string
+		}{
+			// scp
+			{scpTargets, []string{"file.txt", "web1.lan:/tmp/"}, []string{"web1.lan"}},
+			{scpTargets, []string{"-r", "-P", "2222", "root@web1:dir", "bob@web2:"}, []string{"web1", "web2"}},
+			{scpTargets, []string{"-3", "-i", "id_rsa", "-J", "gw1,user@gw2:22", "web1:a", "./local:b"}, []string{"gw1", "gw2", "web1"}},
+			{scpTargets, []string{"-oPort=22", "user@[::1]:file", "/tmp/a:b", ":file"}, []string{"::1"}},
+			{scpTargets, []string{"scp://root@web1:2222/etc/hosts", "."}, []string{"web1"}},
+			{scpTargets, []string{"a.txt", "b.txt"}, []string{}},
+			// sftp
+			{sftpTargets, []string{"web1"}, []string{"web1"}},
+			{sftpTargets, []string{"-P", "2222", "-b", "batch", "root@web1:/tmp"}, []string{"web1"}},
+			{sftpTargets, []string{"-J", "gw", "sftp://root@web1:2222/tmp"}, []string{"gw", "web1"}},
+			// rsync
+			{rsyncTargets, []string{"-avz", "src/", "web1:/srv/"}, []string{"web1"}},
+			{rsyncTargets, []string{"-avze", "ssh -p 2222", "--exclude", "*.o", "root@web1:/srv/", "web2:/srv/", "dst/"}, []string{"web1", "web2"}},
+			{rsyncTargets, []string{"--rsh=/usr/bin/ssh", "src", "web1:dst"}, []string{"web1"}},
+			{rsyncTargets, []string{"-e", "rsh", "src", "web1:dst"}, []string{}},
+			{rsyncTargets, []string{"src", "web1::module", "rsync://web2/module"}, []string{}},
+			// mosh
+			{moshTargets, []string{"web1"}, []string{"web1"}},
+			{moshTargets, []string{"--ssh", "ssh -p 2222", "-p", "60001", "root@web1", "--", "tmux", "a"}, []string{"web1"}},
+			{moshTargets, []string{"--ssh=rsh", "web1"}, []string{}},
+		} {
+			So(test.targets(test.args), ShouldResemble, test.expected)
+		}
+
+		So(os.Setenv("RSYNC_RSH", "rsh"), ShouldBeNil)
+		So(rsyncTargets([]string{"src", "web1:dst"}), ShouldResemble, []string{})
+	})
+}
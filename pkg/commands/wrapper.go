@@ -11,6 +11,7 @@ import (
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 	"moul.io/assh/v2/pkg/config"
+	"moul.io/assh/v2/pkg/sshargs"
 )
 
 var wrapperCommand = &cobra.Command{
@@ -19,62 +20,98 @@ var wrapperCommand = &cobra.Command{
 	Hidden: true,
 }
 
+// the arguments of the wrapped commands are passed as is, they are only parsed to find the remote hosts
 var sshWrapperCommand = &cobra.Command{
-	Use:   "ssh",
-	Short: "Wrap ssh",
-	RunE:  runSSHWrapperCommand,
+	Use:                "ssh",
+	Short:              "Wrap ssh",
+	DisableFlagParsing: true,
+	RunE:               runSSHWrapperCommand,
+}
+
+var scpWrapperCommand = &cobra.Command{
+	Use:                "scp",
+	Short:              "Wrap scp",
+	DisableFlagParsing: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return execWrapper(cmd.Name(), args, scpTargets(args))
+	},
+}
+
+var sftpWrapperCommand = &cobra.Command{
+	Use:                "sftp",
+	Short:              "Wrap sftp",
+	DisableFlagParsing: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return execWrapper(cmd.Name(), args, sftpTargets(args))
+	},
+}
+
+var rsyncWrapperCommand = &cobra.Command{
+	Use:                "rsync",
+	Short:              "Wrap rsync",
+	DisableFlagParsing: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return execWrapper(cmd.Name(), args, rsyncTargets(args))
+	},
+}
+
+var moshWrapperCommand = &cobra.Command{
+	Use:                "mosh",
+	Short:              "Wrap mosh",
+	DisableFlagParsing: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return execWrapper(cmd.Name(), args, moshTargets(args))
+	},
 }
 
 // nolint:gochecknoinits
 func init() {
-	sshWrapperCommand.Flags().AddFlagSet(config.SSHFlags())
 	wrapperCommand.AddCommand(sshWrapperCommand)
+	wrapperCommand.AddCommand(scpWrapperCommand)
+	wrapperCommand.AddCommand(sftpWrapperCommand)
+	wrapperCommand.AddCommand(rsyncWrapperCommand)
+	wrapperCommand.AddCommand(moshWrapperCommand)
 }
 
 func runSSHWrapperCommand(cmd *cobra.Command, args []string) error {
+	// `alias ssh="assh wrapper ssh --"` passes a leading separator
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
 	if len(args) < 1 {
 		return fmt.Errorf("missing <target> argument. See usage with 'assh wrapper %s -h'", cmd.Name())
 	}
 
-	// prepare variables
-	target := args[0]
-	command := args[1:]
-	options := []string{}
-	for _, flag := range config.SSHBoolFlags {
-		if viper.GetBool(flag) {
-			options = append(options, fmt.Sprintf("-%s", flag))
-		}
-	}
-	for _, flag := range config.SSHStringFlags {
-		for _, val := range viper.GetStringSlice(flag) {
-			if (flag == "o" || flag == "O") && val == "false" {
-				logger().Debug(
-					"Skip invalid option:",
-					zap.String("flag", flag),
-					zap.String("val", val),
-				)
-				continue
-			}
-			options = append(options, fmt.Sprintf("-%s", flag))
-			options = append(options, val)
-		}
-	}
-	sshArgs := []string{cmd.Name()}
-	sshArgs = append(sshArgs, options...)
-	sshArgs = append(sshArgs, target)
-	sshArgs = append(sshArgs, command...)
-	bin, err := exec.LookPath(cmd.Name())
+	// invalid arguments are passed anyway, ssh prints its own usage
+	targets := []string{}
+	sshArgs, err := sshargs.Parse(args)
 	if err != nil {
-		return errors.Wrapf(err, "failed to lookup %q", cmd.Name())
+		logger().Debug("Failed to parse ssh arguments", zap.Strings("args", args), zap.Error(err))
+	} else {
+		targets = append(jumpHosts(sshArgs.Flags["J"]), sshArgs.Host)
+		logger().Debug(
+			"Wrapper called",
+			zap.String("destination", sshArgs.Destination),
+			zap.String("host", sshArgs.Host),
+			zap.Strings("command", sshArgs.Command),
+			zap.Int("verbosity", sshArgs.Verbosity()),
+		)
 	}
+	return execWrapper(cmd.Name(), args, uniqueStrings(targets))
+}
 
+// execWrapper rebuilds the .ssh/config file if it is outdated for one of the targets, then replaces
+// the current process with the wrapped command
+func execWrapper(name string, args []string, targets []string) error {
+	bin, err := exec.LookPath(name)
+	if err != nil {
+		return errors.Wrapf(err, "failed to lookup %q", name)
+	}
 	logger().Debug(
-		"Wrapper called",
+		"Executing wrapped command",
 		zap.String("bin", bin),
-		zap.String("target", target),
-		zap.Any("command", command),
-		zap.Any("options", options),
-		zap.Any("sshArgs", sshArgs),
+		zap.Strings("args", args),
+		zap.Strings("targets", targets),
 	)
 
 	// check if config is up-to-date
@@ -87,15 +124,19 @@ func runSSHWrapperCommand(cmd *cobra.Command, args []string) error {
 		logger().Debug("Failed to load assh known_hosts", zap.Error(err))
 	}
 
-	// check if .ssh/config is outdated
-	isOutdated, err := conf.IsConfigOutdated(target)
-	if err != nil {
-		logger().Error("failed to check if config is outdated", zap.Error(err))
+	// check if .ssh/config is outdated, each target may be a new known host
+	outdated := false
+	for _, target := range targets {
+		isOutdated, err := conf.IsConfigOutdated(target)
+		if err != nil {
+			logger().Error("failed to check if config is outdated", zap.String("target", target), zap.Error(err))
+		}
+		outdated = outdated || isOutdated
 	}
-	if isOutdated {
+	if outdated {
 		logger().Debug(
 			"The configuration file is outdated, rebuilding it before calling command",
-			zap.String("command", cmd.Name()),
+			zap.String("command", name),
 		)
 		if err = conf.SaveSSHConfig(); err != nil {
 			logger().Error("failed to save ssh config file", zap.Error(err))
@@ -103,5 +144,5 @@ func runSSHWrapperCommand(cmd *cobra.Command, args []string) error {
 	}
 
 	// Execute Binary
-	return syscall.Exec(bin, sshArgs, os.Environ()) // #nosec
+	return syscall.Exec(bin, append([]string{name}, args...), os.Environ()) // #nosec
 }
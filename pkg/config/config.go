@@ -26,12 +26,15 @@ const defaultSSHConfigPath = "~/.ssh/config"
 
 // Config contains a list of Hosts sections and a Defaults section representing a configuration file
 type Config struct {
-	Hosts             HostsMap `yaml:"hosts,omitempty,flow" json:"hosts"`
-	Templates         HostsMap `yaml:"templates,omitempty,flow" json:"templates"`
-	Defaults          Host     `yaml:"defaults,omitempty,flow" json:"defaults,omitempty"`
-	Includes          []string `yaml:"includes,omitempty,flow" json:"includes,omitempty"`
-	ASSHKnownHostFile string   `yaml:"asshknownhostfile,omitempty,flow" json:"asshknownhostfile,omitempty"`
-	ASSHBinaryPath    string   `yaml:"asshbinarypath,omitempty,flow" json:"asshbinarypath,omitempty"`
+	Hosts             HostsMap       `yaml:"hosts,omitempty,flow" json:"hosts"`
+	Templates         HostsMap       `yaml:"templates,omitempty,flow" json:"templates"`
+	Defaults          Host           `yaml:"defaults,omitempty,flow" json:"defaults,omitempty"`
+	Includes          []string       `yaml:"includes,omitempty,flow" json:"includes,omitempty"`
+	ASSHKnownHostFile string         `yaml:"asshknownhostfile,omitempty,flow" json:"asshknownhostfile,omitempty"`
+	ASSHBinaryPath    string         `yaml:"asshbinarypath,omitempty,flow" json:"asshbinarypath,omitempty"`
+	ASSHHistoryFile   string         `yaml:"asshhistoryfile,omitempty,flow" json:"asshhistoryfile,omitempty"`
+	Sockets           *SocketsConfig `yaml:"sockets,omitempty,flow" json:"sockets,omitempty"`
+	Logging           *LoggingConfig `yaml:"logging,omitempty,flow" json:"logging,omitempty"`
 
 	includedFiles map[string]bool
 	sshConfigPath string
@@ -144,8 +147,41 @@ func (c *Config) JSONString() ([]byte, error) {
 	return json.MarshalIndent(c, "", "  ")
 }
 
-// computeHost returns a copy of the host with applied defaults, resolved inheritances and configured internal fields
+// computeHost returns a copy of the host with applied defaults, resolved inheritances, expanded environment
+// variables and configured internal fields
 func computeHost(host *Host, config *Config, name string, fullCompute bool) (*Host, error) {
+	computedHost := resolveHost(host, config, name, fullCompute)
+
+	// the environment is expanded once all the fields are inherited, a second pass would consume the `$$`
+	// escapes again
+	computedHost.expandEnv(func(_, value string) string { return utils.ExpandField(value) })
+
+	if fullCompute {
+		if computedHost.HostName == "" {
+			computedHost.HostName = name
+		}
+		// expands variables in host
+		// i.e: %h.some.zone -> {name}.some.zone
+		hostname := strings.ReplaceAll(computedHost.HostName, "%h", "%n")
+
+		// ssh resolve '%h' in hostnames
+		// -> we bypass the string expansion if the input matches
+		//    an already resolved hostname
+		// See https://github.com/moul/assh/issues/103
+		pattern := strings.ReplaceAll(hostname, "%n", "*")
+		if match, _ := path.Match(pattern, computedHost.inputName); match {
+			computedHost.HostName = computedHost.inputName
+		} else {
+			computedHost.HostName = computedHost.ExpandString(hostname, "")
+		}
+	}
+
+	return computedHost, nil
+}
+
+// resolveHost returns a copy of the host with applied defaults and resolved inheritances, the fields keep
+// their raw values
+func resolveHost(host *Host, config *Config, name string, fullCompute bool) *Host {
 	computedHost := NewHost(name)
 	computedHost.pattern = name
 	if host != nil {
@@ -171,7 +207,7 @@ func computeHost(host *Host, config *Config, name string, fullCompute bool) (*Ho
 		}
 		computedHost.inherited[name] = true
 
-		target, err := config.getHostByPath(name, false, false, true)
+		target, err := config.resolveHostByPath(name)
 		if err != nil {
 			logger().Warn(
 				"Cannot inherits",
@@ -189,33 +225,24 @@ func computeHost(host *Host, config *Config, name string, fullCompute bool) (*Ho
 	if fullCompute {
 		// apply defaults based on "Host *"
 		computedHost.ApplyDefaults(&config.Defaults)
-
-		if computedHost.HostName == "" {
-			computedHost.HostName = name
-		}
-		// expands variables in host
-		// i.e: %h.some.zone -> {name}.some.zone
-		hostname := strings.ReplaceAll(computedHost.HostName, "%h", "%n")
-
-		// ssh resolve '%h' in hostnames
-		// -> we bypass the string expansion if the input matches
-		//    an already resolved hostname
-		// See https://github.com/moul/assh/issues/103
-		pattern := strings.ReplaceAll(hostname, "%n", "*")
-		if match, _ := path.Match(pattern, computedHost.inputName); match {
-			computedHost.HostName = computedHost.inputName
-		} else {
-			computedHost.HostName = computedHost.ExpandString(hostname, "")
-		}
 	}
 
-	return computedHost, nil
+	return computedHost
 }
 
 func (c *Config) getHostByName(name string, safe bool, compute bool, allowTemplate bool) (*Host, error) {
+	host, err := c.findHost(name, safe, allowTemplate)
+	if err != nil {
+		return nil, err
+	}
+	return computeHost(host, c, name, compute)
+}
+
+// findHost returns the configured host or template matching the name, or a virtual host if safe
+func (c *Config) findHost(name string, safe bool, allowTemplate bool) (*Host, error) {
 	if host, ok := c.Hosts[name]; ok {
 		logger().Debug("getHostByName direct matching", zap.String("name", name))
-		return computeHost(host, c, name, compute)
+		return host, nil
 	}
 
 	for origPattern, host := range c.Hosts {
@@ -227,7 +254,7 @@ func (c *Config) getHostByName(name string, safe bool, compute bool, allowTempla
 			}
 			if matched {
 				logger().Debug("getHostByName pattern matching", zap.String("pattern", pattern), zap.String("name", name))
-				return computeHost(host, c, name, compute)
+				return host, nil
 			}
 		}
 	}
@@ -239,7 +266,7 @@ func (c *Config) getHostByName(name string, safe bool, compute bool, allowTempla
 				return nil, err
 			}
 			if matched {
-				return computeHost(template, c, name, compute)
+				return template, nil
 			}
 		}
 	}
@@ -247,7 +274,7 @@ func (c *Config) getHostByName(name string, safe bool, compute bool, allowTempla
 	if safe {
 		host := NewHost(name)
 		host.HostName = name
-		return computeHost(host, c, name, compute)
+		return host, nil
 	}
 
 	return nil, fmt.Errorf("no such host: %s", name)
@@ -268,6 +295,24 @@ func (c *Config) getHostByPath(path string, safe bool, compute bool, allowTempla
 	return host, nil
 }
 
+// resolveHostByPath returns the host inherited by another one, its environment variables are expanded with
+// the fields of the inheriting host
+func (c *Config) resolveHostByPath(path string) (*Host, error) {
+	parts := strings.SplitN(path, "/", 2)
+
+	host, err := c.findHost(parts[0], false, true)
+	if err != nil {
+		return nil, err
+	}
+	resolved := resolveHost(host, c, parts[0], false)
+
+	if len(parts) > 1 {
+		resolved.Gateways = []string{parts[1]}
+	}
+
+	return resolved, nil
+}
+
 // GetGatewaySafe returns gateway Host configuration, a gateway is like a Host, except, the host path is not resolved
 func (c *Config) GetGatewaySafe(name string) *Host {
 	host, err := c.getHostByName(name, true, true, false) // FIXME: fullCompute for gateway ?
@@ -291,6 +336,24 @@ func (c *Config) GetHostSafe(name string) *Host {
 	return host
 }
 
+// SelectHosts returns the computed hosts whose labels match the selector, wildcard entries are skipped
+func (c *Config) SelectHosts(selector Selector) (HostsList, error) {
+	list := HostsList{}
+	for _, name := range c.sortedNames() {
+		if isDynamicHostname(name) || strings.Contains(name, "?") {
+			continue
+		}
+		host, err := c.GetHost(name)
+		if err != nil {
+			return nil, err
+		}
+		if selector.Matches(host) {
+			list = append(list, host)
+		}
+	}
+	return list, nil
+}
+
 // isSSHConfigOutdated returns true if assh.yml or an included file has a
 // modification date more recent than .ssh/config
 func (c *Config) isSSHConfigOutdated() (bool, error) {
@@ -391,6 +454,45 @@ func (c *Config) LoadConfig(source io.Reader) error {
 	}
 	c.applyMissingNames()
 	c.mergeWildCardEntries()
+	if err := c.Sockets.Validate(); err != nil {
+		return fmt.Errorf("sockets: %v", err)
+	}
+	if err := c.Logging.Validate(); err != nil {
+		return fmt.Errorf("logging: %v", err)
+	}
+	if err := c.validateHooks(); err != nil {
+		return err
+	}
+	return c.validateEnv()
+}
+
+// validateEnv checks the environment variables required by every section, so they are reported at load time
+func (c *Config) validateEnv() error {
+	if err := c.Defaults.validateEnv(); err != nil {
+		return fmt.Errorf("defaults: %v", err)
+	}
+	for _, section := range []HostsMap{c.Hosts, c.Templates} {
+		for _, host := range section.SortedList() {
+			if err := host.validateEnv(); err != nil {
+				return fmt.Errorf("%q: %v", host.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateHooks parses the hooks of every section, so invalid templates are reported at load time
+func (c *Config) validateHooks() error {
+	if err := c.Defaults.Hooks.Validate(); err != nil {
+		return fmt.Errorf("defaults: invalid hook: %v", err)
+	}
+	for _, section := range []HostsMap{c.Hosts, c.Templates} {
+		for _, host := range section.SortedList() {
+			if err := host.Hooks.Validate(); err != nil {
+				return fmt.Errorf("%q: invalid hook: %v", host.name, err)
+			}
+		}
+	}
 	return nil
 }
 
@@ -648,6 +750,11 @@ func (c *Config) WriteSSHConfigTo(w io.Writer) error {
 	return c.Defaults.WriteSSHConfigTo(w)
 }
 
+// HistoryEnabled returns false when the connection history is disabled with `ASSHHistoryFile: none`
+func (c *Config) HistoryEnabled() bool {
+	return c.ASSHHistoryFile != "" && c.ASSHHistoryFile != "none"
+}
+
 // SSHConfigPath returns the ~/.ssh/config file path
 func (c *Config) SSHConfigPath() string { return c.sshConfigPath }
 
@@ -659,6 +766,7 @@ func New() *Config {
 	config.includedFiles = make(map[string]bool)
 	config.sshConfigPath = defaultSSHConfigPath
 	config.ASSHKnownHostFile = "~/.ssh/assh_known_hosts"
+	config.ASSHHistoryFile = "~/.ssh/assh_history"
 	config.ASSHBinaryPath = ""
 	return &config
 }
@@ -2,13 +2,17 @@ package config
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	composeyaml "github.com/docker/libcompose/yaml"
 	. "github.com/smartystreets/goconvey/convey"
+	"go.uber.org/zap/zapcore"
 )
 
 var (
@@ -372,6 +376,222 @@ func TestConfig_LoadConfig(t *testing.T) {
 			So(config.Defaults.User, ShouldEqual, "root")
 			So(len(config.Templates), ShouldEqual, 3)
 		})
+		Convey("hooks", func() {
+			config := New()
+			err := config.LoadConfig(strings.NewReader(`
+defaults:
+  Hooks:
+    OnConnect: write New SSH connection to {{.Host.Prototype}}
+    OnDisconnect:
+    - write SSH connection to {{.Host.Name}} closed
+    - If: '{{gt .Stats.WrittenBytes 1000000}}'
+      Run: notify Big transfer to {{.Host.Name}}
+`))
+			So(err, ShouldBeNil)
+			So(len(config.Defaults.Hooks.OnConnect), ShouldEqual, 1)
+			So(config.Defaults.Hooks.OnConnect[0].Expr, ShouldEqual, "write New SSH connection to {{.Host.Prototype}}")
+			So(config.Defaults.Hooks.OnConnect[0].If, ShouldEqual, "")
+			So(len(config.Defaults.Hooks.OnDisconnect), ShouldEqual, 2)
+			So(config.Defaults.Hooks.OnDisconnect[1].Expr, ShouldEqual, "notify Big transfer to {{.Host.Name}}")
+			So(config.Defaults.Hooks.OnDisconnect[1].If, ShouldEqual, "{{gt .Stats.WrittenBytes 1000000}}")
+
+			err = New().LoadConfig(strings.NewReader(`
+defaults:
+  Hooks:
+    OnConnect:
+    - If: '{{true}}'
+`))
+			So(err, ShouldNotBeNil)
+		})
+		Convey("invalid hooks", func() {
+			err := New().LoadConfig(strings.NewReader(`
+hosts:
+  aaa:
+    Hooks:
+      OnConnect: write New SSH connection to {{.Host.Prototype
+`))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, `"aaa": invalid hook: OnConnect: invalid hook`)
+
+			err = New().LoadConfig(strings.NewReader(`
+defaults:
+  Hooks:
+    OnDisconnect: write @/non-existing/assh/disconnect.tmpl
+`))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, `defaults: invalid hook: OnDisconnect: invalid hook`)
+
+			err = New().LoadConfig(strings.NewReader(`
+templates:
+  bbb:
+    Hooks:
+      OnDisconnect:
+      - If: '{{gt .Stats.WrittenBytes'
+        Run: write big transfer
+`))
+			So(err, ShouldNotBeNil)
+		})
+		Convey("sockets", func() {
+			config := New()
+			So(config.LoadConfig(strings.NewReader(`hosts: {}`)), ShouldBeNil)
+			interval, err := config.Sockets.GCIntervalDuration()
+			So(err, ShouldBeNil)
+			So(interval, ShouldEqual, time.Minute)
+			idleTimeout, err := config.Sockets.IdleTimeoutDuration()
+			So(err, ShouldBeNil)
+			So(idleTimeout, ShouldEqual, 0)
+
+			So(config.LoadConfig(strings.NewReader(`
+sockets:
+  GCInterval: 30s
+  IdleTimeout: 2h
+  CloseRemovedHosts: true
+`)), ShouldBeNil)
+			interval, err = config.Sockets.GCIntervalDuration()
+			So(err, ShouldBeNil)
+			So(interval, ShouldEqual, 30*time.Second)
+			idleTimeout, err = config.Sockets.IdleTimeoutDuration()
+			So(err, ShouldBeNil)
+			So(idleTimeout, ShouldEqual, 2*time.Hour)
+			So(config.Sockets.CloseRemovedHosts, ShouldBeTrue)
+
+			err = New().LoadConfig(strings.NewReader(`
+sockets:
+  IdleTimeout: forever
+`))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, `sockets: invalid value for 'IdleTimeout': "forever"`)
+			err = New().LoadConfig(strings.NewReader(`
+sockets:
+  GCInterval: 0s
+`))
+			So(err, ShouldNotBeNil)
+		})
+		Convey("logging", func() {
+			config := New()
+			So(config.LoadConfig(strings.NewReader(`
+logging:
+  Format: json
+  File: ~/.ssh/assh.log
+  MaxSize: 1MB
+  Level: info
+  Levels:
+    commands: debug
+    assh.pkg.hooks: error
+`)), ShouldBeNil)
+			So(config.Logging.IsJSON(), ShouldBeTrue)
+			So(config.Logging.File, ShouldEqual, "~/.ssh/assh.log")
+			size, err := config.Logging.MaxSizeBytes()
+			So(err, ShouldBeNil)
+			So(size, ShouldEqual, 1000000)
+			So(config.Logging.MaxBackupsCount(), ShouldEqual, 3)
+			level, ok, err := config.Logging.ParsedLevel()
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			So(level, ShouldEqual, zapcore.InfoLevel)
+			levels, err := config.Logging.PackageLevels()
+			So(err, ShouldBeNil)
+			So(levels, ShouldResemble, map[string]zapcore.Level{
+				"assh.pkg.commands": zapcore.DebugLevel,
+				"assh.pkg.hooks":    zapcore.ErrorLevel,
+			})
+
+			// the environment overrides the configuration
+			So(os.Setenv("ASSH_LOG_FORMAT", "console"), ShouldBeNil)
+			So(os.Setenv("ASSH_LOG_LEVELS", "commands=warn,config=debug"), ShouldBeNil)
+			defer os.Unsetenv("ASSH_LOG_FORMAT")
+			defer os.Unsetenv("ASSH_LOG_LEVELS")
+			config.Logging.ApplyEnv()
+			So(config.Logging.IsJSON(), ShouldBeFalse)
+			levels, err = config.Logging.PackageLevels()
+			So(err, ShouldBeNil)
+			So(levels["assh.pkg.commands"], ShouldEqual, zapcore.WarnLevel)
+			So(levels["assh.pkg.config"], ShouldEqual, zapcore.DebugLevel)
+
+			err = New().LoadConfig(strings.NewReader(`
+logging:
+  Format: xml
+`))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, `logging: invalid value for 'Format': "xml", should be 'console' or 'json'`)
+			err = New().LoadConfig(strings.NewReader(`
+logging:
+  Levels:
+    commands: loud
+`))
+			So(err, ShouldNotBeNil)
+		})
+		Convey("required environment variables", func() {
+			So(os.Unsetenv("ASSH_TEST_USER"), ShouldBeNil)
+			source := `
+hosts:
+  aaa:
+    User: ${ASSH_TEST_USER:-root}
+  bbb:
+    User: ${ASSH_TEST_USER:?set ASSH_TEST_USER to your login}
+    IdentityFile: $${NOT_EXPANDED:?}
+`
+			err := New().LoadConfig(strings.NewReader(source))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, `"bbb": invalid value for 'User': ASSH_TEST_USER: set ASSH_TEST_USER to your login`)
+
+			err = New().LoadConfig(strings.NewReader(`
+defaults:
+  ProxyCommand: nc ${ASSH_TEST_GATEWAY:?} %p
+`))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, `defaults: invalid value for 'ProxyCommand': ASSH_TEST_GATEWAY: parameter null or not set`)
+
+			So(os.Setenv("ASSH_TEST_USER", "moul"), ShouldBeNil)
+			defer os.Unsetenv("ASSH_TEST_USER")
+			config := New()
+			So(config.LoadConfig(strings.NewReader(source)), ShouldBeNil)
+			So(config.Hosts["bbb"].User, ShouldEqual, "${ASSH_TEST_USER:?set ASSH_TEST_USER to your login}")
+			computed, err := config.GetHost("bbb")
+			So(err, ShouldBeNil)
+			So(computed.User, ShouldEqual, "moul")
+			So(computed.IdentityFile, ShouldResemble, composeyaml.Stringorslice{"${NOT_EXPANDED:?}"})
+			computed, err = config.GetHost("aaa")
+			So(err, ShouldBeNil)
+			So(computed.User, ShouldEqual, "moul")
+
+			// the fields that are not expanded by computeHost are not checked
+			config = New()
+			So(config.LoadConfig(strings.NewReader(`
+hosts:
+  ccc:
+    Comment: ${ASSH_TEST_UNSET:?}
+    Record: ${ASSH_TEST_UNSET:?}/captures
+    ProxyCommand: nc $$HOME %h %p
+`)), ShouldBeNil)
+			computed, err = config.GetHost("ccc")
+			So(err, ShouldBeNil)
+			So(computed.ProxyCommand, ShouldEqual, "nc $HOME %h %p")
+
+			// the escapes are consumed once, whatever the amount of inherited hosts
+			config = New()
+			So(config.LoadConfig(strings.NewReader(`
+hosts:
+  base:
+    Port: 2222
+    IdentityFile: $${ASSH_TEST_UNSET:?}
+  middle:
+    Inherits: [base]
+  web:
+    Inherits: [middle]
+    User: a$$b
+    ProxyCommand: echo $$HOME
+defaults:
+  LocalCommand: echo $$USER
+`)), ShouldBeNil)
+			computed, err = config.GetHost("web")
+			So(err, ShouldBeNil)
+			So(computed.User, ShouldEqual, "a$b")
+			So(computed.ProxyCommand, ShouldEqual, "echo $HOME")
+			So(computed.LocalCommand, ShouldEqual, "echo $USER")
+			So(computed.Port, ShouldEqual, "2222")
+			So(computed.IdentityFile, ShouldResemble, composeyaml.Stringorslice{"${ASSH_TEST_UNSET:?}"})
+		})
 	})
 }
 
@@ -613,7 +833,8 @@ func TestConfig_JSONString(t *testing.T) {
     "User": "root",
     "Hooks": {}
   },
-  "asshknownhostfile": "~/.ssh/assh_known_hosts"
+  "asshknownhostfile": "~/.ssh/assh_known_hosts",
+  "asshhistoryfile": "~/.ssh/assh_history"
 }`
 			json, err := config.JSONString()
 			So(err, ShouldBeNil)
@@ -747,7 +968,8 @@ func TestConfig_JSONString(t *testing.T) {
     "/path/to/dir/*.yml",
     "/path/to/file.yml"
   ],
-  "asshknownhostfile": "~/.ssh/assh_known_hosts"
+  "asshknownhostfile": "~/.ssh/assh_known_hosts",
+  "asshhistoryfile": "~/.ssh/assh_history"
 }`
 			json, err := config.JSONString()
 			So(err, ShouldBeNil)
@@ -815,7 +1037,7 @@ func TestComputeHost(t *testing.T) {
 			So(computed.Port, ShouldEqual, "42")
 			So(len(computed.IdentityFile), ShouldEqual, 1)
 			So(computed.IdentityFile[0], ShouldEqual, "")
-			So(computed.LocalCommand, ShouldEqual, "") // FIXME: it should be "hello"
+			So(computed.LocalCommand, ShouldEqual, "hello")
 			So(computed.User, ShouldEqual, "user-ccc-user")
 		})
 	})
@@ -1049,6 +1271,40 @@ includes:
 			So(len(config.includedFiles), ShouldEqual, 2)
 
 		})
+		Convey("OpenLogging", func() {
+			tempDir, err := ioutil.TempDir(os.TempDir(), "assh-tests")
+			So(err, ShouldBeNil)
+			defer func() { So(os.RemoveAll(tempDir), ShouldBeNil) }()
+			// the hosts are not loaded, their errors are reported by Open
+			So(ioutil.WriteFile(filepath.Join(tempDir, "assh.yml"), []byte(fmt.Sprintf(`
+includes:
+- %s/assh.d/*.yml
+logging:
+  Level: error
+  Format: json
+hosts:
+  aaa:
+    User: ${ASSH_TEST_UNSET:?}
+`, tempDir)), 0600), ShouldBeNil)
+			So(os.Mkdir(filepath.Join(tempDir, "assh.d"), 0700), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(tempDir, "assh.d", "logging.yml"), []byte(`
+logging:
+  Level: info
+`), 0600), ShouldBeNil)
+
+			logging, err := OpenLogging(filepath.Join(tempDir, "assh.yml"))
+			So(err, ShouldBeNil)
+			So(logging.Level, ShouldEqual, "info")
+			So(logging.IsJSON(), ShouldBeTrue)
+
+			logging, err = OpenLogging(filepath.Join(tempDir, "missing.yml"))
+			So(err, ShouldBeNil)
+			So(logging.Level, ShouldEqual, "")
+
+			So(ioutil.WriteFile(filepath.Join(tempDir, "invalid.yml"), []byte("logging: [\n"), 0600), ShouldBeNil)
+			_, err = OpenLogging(filepath.Join(tempDir, "invalid.yml"))
+			So(err, ShouldNotBeNil)
+		})
 
 	})
 	// FIXME: test globbing
@@ -1288,7 +1544,7 @@ func TestConfig_GetHostSafe(t *testing.T) {
 func TestConfig_String(t *testing.T) {
 	Convey("Testing Config.String", t, func() {
 		config := dummyConfig()
-		So(config.String(), ShouldEqual, `{"hosts":{"*.ddd":{"PasswordAuthentication":"yes","HostName":"1.3.5.7"},"empty":{},"nnn":{"Port":"26","Inherits":["mmm"]},"ooo1":{"Port":"23","Aliases":["ooo11","ooo12"]},"ooo2":{"Port":"24","Aliases":["ooo21","ooo22"]},"tata":{"Inherits":["tutu","titi","toto","tutu"]},"titi":{"Port":"23","User":"moul","HostName":"tata","ProxyCommand":"nc -v 4242","ControlMasterMkdir":"true","Comment":["Hello World"]},"tonton":{"ResolveNameservers":["a.com","1.2.3.4"],"Comment":["AAA","BBB"]},"toto":{"HostName":"1.2.3.4"},"toto[1-5]toto":{"User":"toto1"},"toto[7-9]toto":{"User":"toto2"},"toutou":{"RemoteCommand":"date \u003e\u003e /tmp/logs","ResolveCommand":"dig -t %h","Comment":["First line Second line Third line\n"]},"tutu":{"Inherits":["toto","tutu","*.ddd"],"Gateways":["titi","direct","1.2.3.4"]},"zzz":{"AddressFamily":"any","AskPassGUI":"yes","BatchMode":"no","CanonicalDomains":"42.am","CanonicalizeFallbackLocal":"no","CanonicalizeHostname":"yes","CanonicalizeMaxDots":"1","CanonicalizePermittedCNAMEs":"*.a.example.com:*.b.example.com:*.c.example.com","ChallengeResponseAuthentication":"yes","CheckHostIP":"yes","Cipher":"blowfish","Ciphers":["aes128-ctr,aes192-ctr","aes256-ctr"],"ClearAllForwardings":"yes","Compression":"yes","CompressionLevel":6,"ConnectionAttempts":"1","ConnectTimeout":10,"ControlMaster":"yes","ControlPath":"/tmp/%L-%l-%n-%p-%u-%r-%C-%h","ControlPersist":"yes","DynamicForward":["0.0.0.0:4242","0.0.0.0:4343"],"EnableSSHKeysign":"yes","EscapeChar":"~","ExitOnForwardFailure":"yes","FingerprintHash":"sha256","ForwardAgent":"yes","ForwardX11":"yes","ForwardX11Timeout":42,"ForwardX11Trusted":"yes","GatewayPorts":"yes","GlobalKnownHostsFile":["/etc/ssh/ssh_known_hosts","/tmp/ssh_known_hosts"],"GSSAPIAuthentication":"no","GSSAPIClientIdentity":"moul","GSSAPIDelegateCredentials":"no","GSSAPIKeyExchange":"no","GSSAPIRenewalForcesRekey":"no","GSSAPIServerIdentity":"gssapi.example.com","GSSAPITrustDNS":"no","HashKnownHosts":"no","HostbasedAuthentication":"no","HostbasedKeyTypes":"*","HostKeyAlgorithms":["ecdsa-sha2-nistp256-cert-v01@openssh.com","test"],"HostKeyAlias":"z","IdentitiesOnly":"yes","IdentityFile":["~/.ssh/identity","~/.ssh/identity2"],"IgnoreUnknown":"testtest","IPQoS":["lowdelay","highdelay"],"KbdInteractiveAuthentication":"yes","KbdInteractiveDevices":["bsdauth","test"],"KexAlgorithms":["curve25519-sha256@libssh.org","test"],"KeychainIntegration":"yes","LocalCommand":"echo %h \u003e /tmp/logs","LocalForward":["0.0.0.0:1234","0.0.0.0:1235"],"LogLevel":"DEBUG3","MACs":["umac-64-etm@openssh.com,umac-128-etm@openssh.com","test"],"Match":"all","NoHostAuthenticationForLocalhost":"yes","NumberOfPasswordPrompts":"3","PasswordAuthentication":"yes","PermitLocalCommand":"yes","PKCS11Provider":"/a/b/c/pkcs11.so","Port":"22","PreferredAuthentications":"gssapi-with-mic,hostbased,publickey","Protocol":["2","3"],"ProxyJump":"proxy.host","ProxyUseFdpass":"no","PubkeyAuthentication":"yes","RekeyLimit":"default none","RemoteForward":["0.0.0.0:1234","0.0.0.0:1255"],"RequestTTY":"yes","RevokedHostKeys":"/a/revoked-keys","RhostsRSAAuthentication":"no","RSAAuthentication":"yes","SendEnv":["CUSTOM_*,TEST","TEST2"],"ServerAliveCountMax":3,"StreamLocalBindMask":"0177","StreamLocalBindUnlink":"no","StrictHostKeyChecking":"ask","TCPKeepAlive":"yes","Tunnel":"yes","TunnelDevice":"any:any","UpdateHostKeys":"ask","UseKeychain":"no","UsePrivilegedPort":"no","User":"moul","UserKnownHostsFile":["~/.ssh/known_hosts ~/.ssh/known_hosts2","/tmp/known_hosts"],"VerifyHostKeyDNS":"no","VisualHostKey":"yes","XAuthLocation":"xauth","HostName":"zzz.com","ProxyCommand":"nc %h %p"}},"templates":{"mmm":{"Port":"25","User":"mmmm","HostName":"5.5.5.5","Inherits":["tata"]}},"defaults":{"Port":"22","User":"root","Hooks":{}},"asshknownhostfile":"~/.ssh/assh_known_hosts"}`)
+		So(config.String(), ShouldEqual, `{"hosts":{"*.ddd":{"PasswordAuthentication":"yes","HostName":"1.3.5.7"},"empty":{},"nnn":{"Port":"26","Inherits":["mmm"]},"ooo1":{"Port":"23","Aliases":["ooo11","ooo12"]},"ooo2":{"Port":"24","Aliases":["ooo21","ooo22"]},"tata":{"Inherits":["tutu","titi","toto","tutu"]},"titi":{"Port":"23","User":"moul","HostName":"tata","ProxyCommand":"nc -v 4242","ControlMasterMkdir":"true","Comment":["Hello World"]},"tonton":{"ResolveNameservers":["a.com","1.2.3.4"],"Comment":["AAA","BBB"]},"toto":{"HostName":"1.2.3.4"},"toto[1-5]toto":{"User":"toto1"},"toto[7-9]toto":{"User":"toto2"},"toutou":{"RemoteCommand":"date \u003e\u003e /tmp/logs","ResolveCommand":"dig -t %h","Comment":["First line Second line Third line\n"]},"tutu":{"Inherits":["toto","tutu","*.ddd"],"Gateways":["titi","direct","1.2.3.4"]},"zzz":{"AddressFamily":"any","AskPassGUI":"yes","BatchMode":"no","CanonicalDomains":"42.am","CanonicalizeFallbackLocal":"no","CanonicalizeHostname":"yes","CanonicalizeMaxDots":"1","CanonicalizePermittedCNAMEs":"*.a.example.com:*.b.example.com:*.c.example.com","ChallengeResponseAuthentication":"yes","CheckHostIP":"yes","Cipher":"blowfish","Ciphers":["aes128-ctr,aes192-ctr","aes256-ctr"],"ClearAllForwardings":"yes","Compression":"yes","CompressionLevel":6,"ConnectionAttempts":"1","ConnectTimeout":10,"ControlMaster":"yes","ControlPath":"/tmp/%L-%l-%n-%p-%u-%r-%C-%h","ControlPersist":"yes","DynamicForward":["0.0.0.0:4242","0.0.0.0:4343"],"EnableSSHKeysign":"yes","EscapeChar":"~","ExitOnForwardFailure":"yes","FingerprintHash":"sha256","ForwardAgent":"yes","ForwardX11":"yes","ForwardX11Timeout":42,"ForwardX11Trusted":"yes","GatewayPorts":"yes","GlobalKnownHostsFile":["/etc/ssh/ssh_known_hosts","/tmp/ssh_known_hosts"],"GSSAPIAuthentication":"no","GSSAPIClientIdentity":"moul","GSSAPIDelegateCredentials":"no","GSSAPIKeyExchange":"no","GSSAPIRenewalForcesRekey":"no","GSSAPIServerIdentity":"gssapi.example.com","GSSAPITrustDNS":"no","HashKnownHosts":"no","HostbasedAuthentication":"no","HostbasedKeyTypes":"*","HostKeyAlgorithms":["ecdsa-sha2-nistp256-cert-v01@openssh.com","test"],"HostKeyAlias":"z","IdentitiesOnly":"yes","IdentityFile":["~/.ssh/identity","~/.ssh/identity2"],"IgnoreUnknown":"testtest","IPQoS":["lowdelay","highdelay"],"KbdInteractiveAuthentication":"yes","KbdInteractiveDevices":["bsdauth","test"],"KexAlgorithms":["curve25519-sha256@libssh.org","test"],"KeychainIntegration":"yes","LocalCommand":"echo %h \u003e /tmp/logs","LocalForward":["0.0.0.0:1234","0.0.0.0:1235"],"LogLevel":"DEBUG3","MACs":["umac-64-etm@openssh.com,umac-128-etm@openssh.com","test"],"Match":"all","NoHostAuthenticationForLocalhost":"yes","NumberOfPasswordPrompts":"3","PasswordAuthentication":"yes","PermitLocalCommand":"yes","PKCS11Provider":"/a/b/c/pkcs11.so","Port":"22","PreferredAuthentications":"gssapi-with-mic,hostbased,publickey","Protocol":["2","3"],"ProxyJump":"proxy.host","ProxyUseFdpass":"no","PubkeyAuthentication":"yes","RekeyLimit":"default none","RemoteForward":["0.0.0.0:1234","0.0.0.0:1255"],"RequestTTY":"yes","RevokedHostKeys":"/a/revoked-keys","RhostsRSAAuthentication":"no","RSAAuthentication":"yes","SendEnv":["CUSTOM_*,TEST","TEST2"],"ServerAliveCountMax":3,"StreamLocalBindMask":"0177","StreamLocalBindUnlink":"no","StrictHostKeyChecking":"ask","TCPKeepAlive":"yes","Tunnel":"yes","TunnelDevice":"any:any","UpdateHostKeys":"ask","UseKeychain":"no","UsePrivilegedPort":"no","User":"moul","UserKnownHostsFile":["~/.ssh/known_hosts ~/.ssh/known_hosts2","/tmp/known_hosts"],"VerifyHostKeyDNS":"no","VisualHostKey":"yes","XAuthLocation":"xauth","HostName":"zzz.com","ProxyCommand":"nc %h %p"}},"templates":{"mmm":{"Port":"25","User":"mmmm","HostName":"5.5.5.5","Inherits":["tata"]}},"defaults":{"Port":"22","User":"root","Hooks":{}},"asshknownhostfile":"~/.ssh/assh_known_hosts","asshhistoryfile":"~/.ssh/assh_history"}`)
 	})
 }
 
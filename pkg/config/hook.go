@@ -2,6 +2,7 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 
 	"moul.io/assh/v2/pkg/hooks"
 )
@@ -36,3 +37,27 @@ func (hh *HostHooks) String() string {
 	}
 	return string(s)
 }
+
+// Validate returns an error if a hook is invalid, i.e: a template cannot be parsed
+func (hh *HostHooks) Validate() error {
+	if hh == nil {
+		return nil
+	}
+	events := []struct {
+		name  string
+		hooks hooks.Hooks
+	}{
+		{"AfterConfigWrite", hh.AfterConfigWrite},
+		{"BeforeConfigWrite", hh.BeforeConfigWrite},
+		{"BeforeConnect", hh.BeforeConnect},
+		{"OnConnect", hh.OnConnect},
+		{"OnConnectError", hh.OnConnectError},
+		{"OnDisconnect", hh.OnDisconnect},
+	}
+	for _, event := range events {
+		if err := event.hooks.Validate(); err != nil {
+			return fmt.Errorf("%s: %v", event.name, err)
+		}
+	}
+	return nil
+}
@@ -5,12 +5,21 @@ import (
 	"fmt"
 	"io"
 	"os/user"
+	"sort"
 	"strings"
 
 	composeyaml "github.com/docker/libcompose/yaml"
+	humanize "github.com/dustin/go-humanize"
+	"moul.io/assh/v2/pkg/ratelimit"
 	"moul.io/assh/v2/pkg/utils"
 )
 
+// the captures of `Record` are capped and rotated by default
+const (
+	defaultRecordMaxSize  = 100 * 1000 * 1000
+	defaultRecordMaxFiles = 10
+)
+
 // Host defines the configuration flags of a host
 type Host struct {
 	// ssh-config fields
@@ -127,7 +136,15 @@ type Host struct {
 	Hooks                 *HostHooks                `yaml:"hooks,omitempty,flow" json:"Hooks,omitempty"`
 	Comment               composeyaml.Stringorslice `yaml:"comment,omitempty,flow" json:"Comment,omitempty"`
 	RateLimit             string                    `yaml:"ratelimit,omitempty,flow" json:"RateLimit,omitempty"`
+	RateLimitUp           string                    `yaml:"ratelimitup,omitempty,flow" json:"RateLimitUp,omitempty"`
+	RateLimitDown         string                    `yaml:"ratelimitdown,omitempty,flow" json:"RateLimitDown,omitempty"`
+	RateLimitBurst        string                    `yaml:"ratelimitburst,omitempty,flow" json:"RateLimitBurst,omitempty"`
+	RateLimitGroup        string                    `yaml:"ratelimitgroup,omitempty,flow" json:"RateLimitGroup,omitempty"`
+	Record                string                    `yaml:"record,omitempty,flow" json:"Record,omitempty"`
+	RecordMaxSize         string                    `yaml:"recordmaxsize,omitempty,flow" json:"RecordMaxSize,omitempty"`
+	RecordMaxFiles        int                       `yaml:"recordmaxfiles,omitempty,flow" json:"RecordMaxFiles,omitempty"`
 	GatewayConnectTimeout int                       `yaml:"gatewayconnecttimeout,omitempty,flow" json:"GatewayConnectTimeout,omitempty"`
+	Labels                map[string]string         `yaml:"labels,omitempty,flow" json:"Labels,omitempty"`
 
 	// private assh fields
 	noAutomaticRewrite bool
@@ -165,9 +182,79 @@ func (h *Host) Validate() []error {
 		errs = append(errs, fmt.Errorf("%q: invalid value for 'ControlMaster': %q", h.name, h.ControlMaster))
 	}
 
+	for _, field := range []struct{ name, value string }{
+		{"RateLimit", h.RateLimit},
+		{"RateLimitUp", h.RateLimitUp},
+		{"RateLimitDown", h.RateLimitDown},
+	} {
+		if _, err := ratelimit.ParseSchedule(field.value); err != nil {
+			errs = append(errs, fmt.Errorf("%q: invalid value for '%s': %v", h.name, field.name, err))
+		}
+	}
+	if _, err := h.RateLimitBurstBytes(); err != nil {
+		errs = append(errs, fmt.Errorf("%q: invalid value for 'RateLimitBurst': %v", h.name, err))
+	}
+	if h.RateLimitGroup != "" {
+		if err := ratelimit.ValidateGroupName(h.RateLimitGroup); err != nil {
+			errs = append(errs, fmt.Errorf("%q: invalid value for 'RateLimitGroup': %v", h.name, err))
+		}
+	}
+	if _, err := h.RecordMaxSizeBytes(); err != nil {
+		errs = append(errs, fmt.Errorf("%q: invalid value for 'RecordMaxSize': %v", h.name, err))
+	}
+	if h.RecordMaxFiles < 0 {
+		errs = append(errs, fmt.Errorf("%q: invalid value for 'RecordMaxFiles': %d", h.name, h.RecordMaxFiles))
+	}
+
 	return errs
 }
 
+// validateEnv checks that the environment variables required with ${VAR:?message} in the fields expanded
+// by computeHost are set, the fields keep their raw values until the host is computed
+func (h *Host) validateEnv() error {
+	var firstErr error
+	validated := *h
+	validated.expandEnv(func(field, value string) string {
+		if err := utils.ValidateEnv(value); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("invalid value for '%s': %v", field, err)
+		}
+		return value
+	})
+	return firstErr
+}
+
+// RateLimitBurstBytes returns the size of the rate limit buckets, 0 when not configured
+func (h *Host) RateLimitBurstBytes() (int, error) {
+	if h.RateLimitBurst == "" {
+		return 0, nil
+	}
+	bytes, err := humanize.ParseBytes(h.RateLimitBurst)
+	if err != nil {
+		return 0, err
+	}
+	return int(bytes), nil
+}
+
+// RecordMaxSizeBytes returns the size cap of the captures, 100MB when not configured
+func (h *Host) RecordMaxSizeBytes() (int64, error) {
+	if h.RecordMaxSize == "" {
+		return defaultRecordMaxSize, nil
+	}
+	bytes, err := humanize.ParseBytes(h.RecordMaxSize)
+	if err != nil {
+		return 0, err
+	}
+	return int64(bytes), nil
+}
+
+// RecordMaxFilesCount returns the amount of captures kept per host, 10 when not configured
+func (h *Host) RecordMaxFilesCount() int {
+	if h.RecordMaxFiles == 0 {
+		return defaultRecordMaxFiles
+	}
+	return h.RecordMaxFiles
+}
+
 // String returns the JSON output
 func (h *Host) String() string {
 	s, _ := json.Marshal(h)
@@ -211,6 +298,16 @@ func (h *Host) RawName() string {
 	return h.pattern
 }
 
+// LabelsList returns the labels of a host as a sorted list of key=value strings
+func (h *Host) LabelsList() []string {
+	list := make([]string, 0, len(h.Labels))
+	for key, value := range h.Labels {
+		list = append(list, key+"="+value)
+	}
+	sort.Strings(list)
+	return list
+}
+
 // Clone returns a copy of an existing Host
 func (h *Host) Clone() *Host {
 	newHost := *h
@@ -545,6 +642,7 @@ func (h *Host) Options() OptionsList {
 	// Aliases
 	// Comment
 	// Hooks
+	// Labels
 
 	// private assh fields
 	// knownHosts
@@ -575,528 +673,419 @@ func (h *Host) ApplyDefaults(defaults *Host) {
 	if h.AddKeysToAgent == "" {
 		h.AddKeysToAgent = defaults.AddKeysToAgent
 	}
-	h.AddKeysToAgent = utils.ExpandField(h.AddKeysToAgent)
 
 	if h.AddressFamily == "" {
 		h.AddressFamily = defaults.AddressFamily
 	}
-	h.AddressFamily = utils.ExpandField(h.AddressFamily)
 
 	if h.AskPassGUI == "" {
 		h.AskPassGUI = defaults.AskPassGUI
 	}
-	h.AskPassGUI = utils.ExpandField(h.AskPassGUI)
 
 	if h.BatchMode == "" {
 		h.BatchMode = defaults.BatchMode
 	}
-	h.BatchMode = utils.ExpandField(h.BatchMode)
 
 	if h.BindAddress == "" {
 		h.BindAddress = defaults.BindAddress
 	}
-	h.BindAddress = utils.ExpandField(h.BindAddress)
 
 	if h.CanonicalDomains == "" {
 		h.CanonicalDomains = defaults.CanonicalDomains
 	}
-	h.CanonicalDomains = utils.ExpandField(h.CanonicalDomains)
 
 	if h.CanonicalizeFallbackLocal == "" {
 		h.CanonicalizeFallbackLocal = defaults.CanonicalizeFallbackLocal
 	}
-	h.CanonicalizeFallbackLocal = utils.ExpandField(h.CanonicalizeFallbackLocal)
 
 	if h.CanonicalizeHostname == "" {
 		h.CanonicalizeHostname = defaults.CanonicalizeHostname
 	}
-	h.CanonicalizeHostname = utils.ExpandField(h.CanonicalizeHostname)
 
 	if h.CanonicalizeMaxDots == "" {
 		h.CanonicalizeMaxDots = defaults.CanonicalizeMaxDots
 	}
-	h.CanonicalizeMaxDots = utils.ExpandField(h.CanonicalizeMaxDots)
 
 	if h.CanonicalizePermittedCNAMEs == "" {
 		h.CanonicalizePermittedCNAMEs = defaults.CanonicalizePermittedCNAMEs
 	}
-	h.CanonicalizePermittedCNAMEs = utils.ExpandField(h.CanonicalizePermittedCNAMEs)
 
 	if len(h.CASignatureAlgorithms) == 0 {
 		h.CASignatureAlgorithms = defaults.CASignatureAlgorithms
 	}
-	h.CASignatureAlgorithms = utils.ExpandSliceField(h.CASignatureAlgorithms)
 
 	if len(h.CertificateFile) == 0 {
 		h.CertificateFile = defaults.CertificateFile
 	}
-	h.CertificateFile = utils.ExpandSliceField(h.CertificateFile)
 
 	if h.ChallengeResponseAuthentication == "" {
 		h.ChallengeResponseAuthentication = defaults.ChallengeResponseAuthentication
 	}
-	h.ChallengeResponseAuthentication = utils.ExpandField(h.ChallengeResponseAuthentication)
 
 	if h.CheckHostIP == "" {
 		h.CheckHostIP = defaults.CheckHostIP
 	}
-	h.CheckHostIP = utils.ExpandField(h.CheckHostIP)
 
 	if h.Cipher == "" {
 		h.Cipher = defaults.Cipher
 	}
-	h.Cipher = utils.ExpandField(h.Cipher)
 
 	if len(h.Ciphers) == 0 {
 		h.Ciphers = defaults.Ciphers
 	}
-	h.Ciphers = utils.ExpandSliceField(h.Ciphers)
 
 	if h.ClearAllForwardings == "" {
 		h.ClearAllForwardings = defaults.ClearAllForwardings
 	}
-	h.ClearAllForwardings = utils.ExpandField(h.ClearAllForwardings)
 
 	if h.Compression == "" {
 		h.Compression = defaults.Compression
 	}
-	h.Compression = utils.ExpandField(h.Compression)
 
 	if h.CompressionLevel == 0 {
 		h.CompressionLevel = defaults.CompressionLevel
 	}
-	// h.CompressionLevel = utils.ExpandField(h.CompressionLevel)
 
 	if h.ConnectionAttempts == "" {
 		h.ConnectionAttempts = defaults.ConnectionAttempts
 	}
-	h.ConnectionAttempts = utils.ExpandField(h.ConnectionAttempts)
 
 	if h.ConnectTimeout == 0 {
 		h.ConnectTimeout = defaults.ConnectTimeout
 	}
-	// h.ConnectTimeout = utils.ExpandField(h.ConnectTimeout)
 
 	if h.ControlMaster == "" {
 		h.ControlMaster = defaults.ControlMaster
 	}
-	h.ControlMaster = utils.ExpandField(h.ControlMaster)
 
 	if h.ControlPath == "" {
 		h.ControlPath = defaults.ControlPath
 	}
-	h.ControlPath = utils.ExpandField(h.ControlPath)
 
 	if h.ControlPersist == "" {
 		h.ControlPersist = defaults.ControlPersist
 	}
-	h.ControlPersist = utils.ExpandField(h.ControlPersist)
 
 	if len(h.DynamicForward) == 0 {
 		h.DynamicForward = defaults.DynamicForward
 	}
-	h.DynamicForward = utils.ExpandSliceField(h.DynamicForward)
 
 	if h.EnableSSHKeysign == "" {
 		h.EnableSSHKeysign = defaults.EnableSSHKeysign
 	}
-	h.EnableSSHKeysign = utils.ExpandField(h.EnableSSHKeysign)
 
 	if h.EscapeChar == "" {
 		h.EscapeChar = defaults.EscapeChar
 	}
-	h.EscapeChar = utils.ExpandField(h.EscapeChar)
 
 	if h.ExitOnForwardFailure == "" {
 		h.ExitOnForwardFailure = defaults.ExitOnForwardFailure
 	}
-	h.ExitOnForwardFailure = utils.ExpandField(h.ExitOnForwardFailure)
 
 	if h.FingerprintHash == "" {
 		h.FingerprintHash = defaults.FingerprintHash
 	}
-	h.FingerprintHash = utils.ExpandField(h.FingerprintHash)
 
 	if h.ForwardAgent == "" {
 		h.ForwardAgent = defaults.ForwardAgent
 	}
-	h.ForwardAgent = utils.ExpandField(h.ForwardAgent)
 
 	if h.ForwardX11 == "" {
 		h.ForwardX11 = defaults.ForwardX11
 	}
-	h.ForwardX11 = utils.ExpandField(h.ForwardX11)
 
 	if h.ForwardX11Timeout == 0 {
 		h.ForwardX11Timeout = defaults.ForwardX11Timeout
 	}
-	// h.ForwardX11Timeout = utils.ExpandField(h.ForwardX11Timeout)
 
 	if h.ForwardX11Trusted == "" {
 		h.ForwardX11Trusted = defaults.ForwardX11Trusted
 	}
-	h.ForwardX11Trusted = utils.ExpandField(h.ForwardX11Trusted)
 
 	if h.GatewayPorts == "" {
 		h.GatewayPorts = defaults.GatewayPorts
 	}
-	h.GatewayPorts = utils.ExpandField(h.GatewayPorts)
 
 	if len(h.GlobalKnownHostsFile) == 0 {
 		h.GlobalKnownHostsFile = defaults.GlobalKnownHostsFile
 	}
-	h.GlobalKnownHostsFile = utils.ExpandSliceField(h.GlobalKnownHostsFile)
 
 	if h.GSSAPIAuthentication == "" {
 		h.GSSAPIAuthentication = defaults.GSSAPIAuthentication
 	}
-	h.GSSAPIAuthentication = utils.ExpandField(h.GSSAPIAuthentication)
 
 	if h.GSSAPIClientIdentity == "" {
 		h.GSSAPIClientIdentity = defaults.GSSAPIClientIdentity
 	}
-	h.GSSAPIClientIdentity = utils.ExpandField(h.GSSAPIClientIdentity)
 
 	if h.GSSAPIDelegateCredentials == "" {
 		h.GSSAPIDelegateCredentials = defaults.GSSAPIDelegateCredentials
 	}
-	h.GSSAPIDelegateCredentials = utils.ExpandField(h.GSSAPIDelegateCredentials)
 
 	if h.GSSAPIKeyExchange == "" {
 		h.GSSAPIKeyExchange = defaults.GSSAPIKeyExchange
 	}
-	h.GSSAPIKeyExchange = utils.ExpandField(h.GSSAPIKeyExchange)
 
 	if h.GSSAPIRenewalForcesRekey == "" {
 		h.GSSAPIRenewalForcesRekey = defaults.GSSAPIRenewalForcesRekey
 	}
-	h.GSSAPIRenewalForcesRekey = utils.ExpandField(h.GSSAPIRenewalForcesRekey)
 
 	if h.GSSAPIServerIdentity == "" {
 		h.GSSAPIServerIdentity = defaults.GSSAPIServerIdentity
 	}
-	h.GSSAPIServerIdentity = utils.ExpandField(h.GSSAPIServerIdentity)
 
 	if h.GSSAPITrustDNS == "" {
 		h.GSSAPITrustDNS = defaults.GSSAPITrustDNS
 	}
-	h.GSSAPITrustDNS = utils.ExpandField(h.GSSAPITrustDNS)
 
 	if h.HashKnownHosts == "" {
 		h.HashKnownHosts = defaults.HashKnownHosts
 	}
-	h.HashKnownHosts = utils.ExpandField(h.HashKnownHosts)
 
 	if h.HostbasedAuthentication == "" {
 		h.HostbasedAuthentication = defaults.HostbasedAuthentication
 	}
-	h.HostbasedAuthentication = utils.ExpandField(h.HostbasedAuthentication)
 
 	if h.HostbasedKeyTypes == "" {
 		h.HostbasedKeyTypes = defaults.HostbasedKeyTypes
 	}
-	h.HostbasedKeyTypes = utils.ExpandField(h.HostbasedKeyTypes)
 
 	if len(h.HostKeyAlgorithms) == 0 {
 		h.HostKeyAlgorithms = defaults.HostKeyAlgorithms
 	}
-	h.HostKeyAlgorithms = utils.ExpandSliceField(h.HostKeyAlgorithms)
 
 	if h.HostKeyAlias == "" {
 		h.HostKeyAlias = defaults.HostKeyAlias
 	}
-	h.HostKeyAlias = utils.ExpandField(h.HostKeyAlias)
 
 	if h.HostName == "" {
 		h.HostName = defaults.HostName
 	}
-	h.HostName = utils.ExpandField(h.HostName)
 
 	if h.IdentitiesOnly == "" {
 		h.IdentitiesOnly = defaults.IdentitiesOnly
 	}
-	h.IdentitiesOnly = utils.ExpandField(h.IdentitiesOnly)
 
 	if h.IdentityAgent == "" {
 		h.IdentityAgent = defaults.IdentityAgent
 	}
-	h.IdentityAgent = utils.ExpandField(h.IdentityAgent)
 
 	if len(h.IdentityFile) == 0 {
 		h.IdentityFile = defaults.IdentityFile
 	}
-	h.IdentityFile = utils.ExpandSliceField(h.IdentityFile)
 
 	if h.IgnoreUnknown == "" {
 		h.IgnoreUnknown = defaults.IgnoreUnknown
 	}
-	h.IgnoreUnknown = utils.ExpandField(h.IgnoreUnknown)
 
 	if len(h.IPQoS) == 0 {
 		h.IPQoS = defaults.IPQoS
 	}
-	h.IPQoS = utils.ExpandSliceField(h.IPQoS)
 
 	if h.KbdInteractiveAuthentication == "" {
 		h.KbdInteractiveAuthentication = defaults.KbdInteractiveAuthentication
 	}
-	h.KbdInteractiveAuthentication = utils.ExpandField(h.KbdInteractiveAuthentication)
 
 	if len(h.KbdInteractiveDevices) == 0 {
 		h.KbdInteractiveDevices = defaults.KbdInteractiveDevices
 	}
-	h.KbdInteractiveDevices = utils.ExpandSliceField(h.KbdInteractiveDevices)
 
 	if len(h.KexAlgorithms) == 0 {
 		h.KexAlgorithms = defaults.KexAlgorithms
 	}
-	h.KexAlgorithms = utils.ExpandSliceField(h.KexAlgorithms)
 
 	if h.KeychainIntegration == "" {
 		h.KeychainIntegration = defaults.KeychainIntegration
 	}
-	h.KeychainIntegration = utils.ExpandField(h.KeychainIntegration)
 
 	if h.LocalCommand == "" {
 		h.LocalCommand = defaults.LocalCommand
 	}
-	h.LocalCommand = utils.ExpandField(h.LocalCommand)
 	if h.RemoteCommand == "" {
 		h.RemoteCommand = defaults.RemoteCommand
 	}
-	h.RemoteCommand = utils.ExpandField(h.RemoteCommand)
 
 	if len(h.LocalForward) == 0 {
 		h.LocalForward = defaults.LocalForward
 	}
-	h.LocalForward = utils.ExpandSliceField(h.LocalForward)
 
 	if h.LogLevel == "" {
 		h.LogLevel = defaults.LogLevel
 	}
-	h.LogLevel = utils.ExpandField(h.LogLevel)
 
 	if len(h.MACs) == 0 {
 		h.MACs = defaults.MACs
 	}
-	h.MACs = utils.ExpandSliceField(h.MACs)
 
 	if h.Match == "" {
 		h.Match = defaults.Match
 	}
-	h.Match = utils.ExpandField(h.Match)
 
 	if h.NoHostAuthenticationForLocalhost == "" {
 		h.NoHostAuthenticationForLocalhost = defaults.NoHostAuthenticationForLocalhost
 	}
-	h.NoHostAuthenticationForLocalhost = utils.ExpandField(h.NoHostAuthenticationForLocalhost)
 
 	if h.NumberOfPasswordPrompts == "" {
 		h.NumberOfPasswordPrompts = defaults.NumberOfPasswordPrompts
 	}
-	h.NumberOfPasswordPrompts = utils.ExpandField(h.NumberOfPasswordPrompts)
 
 	if h.PasswordAuthentication == "" {
 		h.PasswordAuthentication = defaults.PasswordAuthentication
 	}
-	h.PasswordAuthentication = utils.ExpandField(h.PasswordAuthentication)
 
 	if h.PermitLocalCommand == "" {
 		h.PermitLocalCommand = defaults.PermitLocalCommand
 	}
-	h.PermitLocalCommand = utils.ExpandField(h.PermitLocalCommand)
 
 	if h.PKCS11Provider == "" {
 		h.PKCS11Provider = defaults.PKCS11Provider
 	}
-	h.PKCS11Provider = utils.ExpandField(h.PKCS11Provider)
 
 	if h.Port == "" {
 		h.Port = defaults.Port
 	}
-	h.Port = utils.ExpandField(h.Port)
 
 	if h.PreferredAuthentications == "" {
 		h.PreferredAuthentications = defaults.PreferredAuthentications
 	}
-	h.PreferredAuthentications = utils.ExpandField(h.PreferredAuthentications)
 
 	if len(h.Protocol) == 0 {
 		h.Protocol = defaults.Protocol
 	}
-	h.Protocol = utils.ExpandSliceField(h.Protocol)
-
-	if h.ProxyCommand == "" {
-		h.ProxyCommand = defaults.ProxyCommand
-	}
-	h.ProxyCommand = utils.ExpandField(h.ProxyCommand)
 
 	if h.ProxyJump == "" {
 		h.ProxyJump = defaults.ProxyJump
 	}
-	h.ProxyJump = utils.ExpandField(h.ProxyJump)
 
 	if h.ProxyUseFdpass == "" {
 		h.ProxyUseFdpass = defaults.ProxyUseFdpass
 	}
-	h.ProxyUseFdpass = utils.ExpandField(h.ProxyUseFdpass)
 
 	if h.PubkeyAcceptedAlgorithms == "" {
 		h.PubkeyAcceptedAlgorithms = defaults.PubkeyAcceptedAlgorithms
 	}
-	h.PubkeyAcceptedAlgorithms = utils.ExpandField(h.PubkeyAcceptedAlgorithms)
 
 	if h.PubkeyAcceptedKeyTypes == "" {
 		h.PubkeyAcceptedKeyTypes = defaults.PubkeyAcceptedKeyTypes
 	}
-	h.PubkeyAcceptedKeyTypes = utils.ExpandField(h.PubkeyAcceptedKeyTypes)
 
 	if h.PubkeyAuthentication == "" {
 		h.PubkeyAuthentication = defaults.PubkeyAuthentication
 	}
-	h.PubkeyAuthentication = utils.ExpandField(h.PubkeyAuthentication)
 
 	if h.RekeyLimit == "" {
 		h.RekeyLimit = defaults.RekeyLimit
 	}
-	h.RekeyLimit = utils.ExpandField(h.RekeyLimit)
 
 	if len(h.RemoteForward) == 0 {
 		h.RemoteForward = defaults.RemoteForward
 	}
-	h.RemoteForward = utils.ExpandSliceField(h.RemoteForward)
 
 	if h.RequestTTY == "" {
 		h.RequestTTY = defaults.RequestTTY
 	}
-	h.RequestTTY = utils.ExpandField(h.RequestTTY)
 
 	if h.RevokedHostKeys == "" {
 		h.RevokedHostKeys = defaults.RevokedHostKeys
 	}
-	h.RevokedHostKeys = utils.ExpandField(h.RevokedHostKeys)
 
 	if h.RhostsRSAAuthentication == "" {
 		h.RhostsRSAAuthentication = defaults.RhostsRSAAuthentication
 	}
-	h.RhostsRSAAuthentication = utils.ExpandField(h.RhostsRSAAuthentication)
 
 	if h.RSAAuthentication == "" {
 		h.RSAAuthentication = defaults.RSAAuthentication
 	}
-	h.RSAAuthentication = utils.ExpandField(h.RSAAuthentication)
 
 	if len(h.SendEnv) == 0 {
 		h.SendEnv = defaults.SendEnv
 	}
-	h.SendEnv = utils.ExpandSliceField(h.SendEnv)
 
 	if h.ServerAliveCountMax == 0 {
 		h.ServerAliveCountMax = defaults.ServerAliveCountMax
 	}
-	// h.ServerAliveCountMax = utils.ExpandField(h.ServerAliveCountMax)
 
 	if h.ServerAliveInterval == 0 {
 		h.ServerAliveInterval = defaults.ServerAliveInterval
 	}
-	// h.ServerAliveInterval = utils.ExpandField(h.ServerAliveInterval)
 
 	if h.StreamLocalBindMask == "" {
 		h.StreamLocalBindMask = defaults.StreamLocalBindMask
 	}
-	h.StreamLocalBindMask = utils.ExpandField(h.StreamLocalBindMask)
 
 	if h.StreamLocalBindUnlink == "" {
 		h.StreamLocalBindUnlink = defaults.StreamLocalBindUnlink
 	}
-	h.StreamLocalBindUnlink = utils.ExpandField(h.StreamLocalBindUnlink)
 
 	if h.StrictHostKeyChecking == "" {
 		h.StrictHostKeyChecking = defaults.StrictHostKeyChecking
 	}
-	h.StrictHostKeyChecking = utils.ExpandField(h.StrictHostKeyChecking)
 
 	if h.TCPKeepAlive == "" {
 		h.TCPKeepAlive = defaults.TCPKeepAlive
 	}
-	h.TCPKeepAlive = utils.ExpandField(h.TCPKeepAlive)
 
 	if h.Tunnel == "" {
 		h.Tunnel = defaults.Tunnel
 	}
-	h.Tunnel = utils.ExpandField(h.Tunnel)
 
 	if h.TunnelDevice == "" {
 		h.TunnelDevice = defaults.TunnelDevice
 	}
-	h.TunnelDevice = utils.ExpandField(h.TunnelDevice)
 
 	if h.UpdateHostKeys == "" {
 		h.UpdateHostKeys = defaults.UpdateHostKeys
 	}
-	h.UpdateHostKeys = utils.ExpandField(h.UpdateHostKeys)
 
 	if h.UseKeychain == "" {
 		h.UseKeychain = defaults.UseKeychain
 	}
-	h.UseKeychain = utils.ExpandField(h.UseKeychain)
 
 	if h.UsePrivilegedPort == "" {
 		h.UsePrivilegedPort = defaults.UsePrivilegedPort
 	}
-	h.UsePrivilegedPort = utils.ExpandField(h.UsePrivilegedPort)
 
 	if h.User == "" {
 		h.User = defaults.User
 	}
-	h.User = utils.ExpandField(h.User)
 
 	if len(h.UserKnownHostsFile) == 0 {
 		h.UserKnownHostsFile = defaults.UserKnownHostsFile
 	}
-	h.UserKnownHostsFile = utils.ExpandSliceField(h.UserKnownHostsFile)
 
 	if h.VerifyHostKeyDNS == "" {
 		h.VerifyHostKeyDNS = defaults.VerifyHostKeyDNS
 	}
-	h.VerifyHostKeyDNS = utils.ExpandField(h.VerifyHostKeyDNS)
 
 	if h.VisualHostKey == "" {
 		h.VisualHostKey = defaults.VisualHostKey
 	}
-	h.VisualHostKey = utils.ExpandField(h.VisualHostKey)
 
 	if h.XAuthLocation == "" {
 		h.XAuthLocation = defaults.XAuthLocation
 	}
-	h.XAuthLocation = utils.ExpandField(h.XAuthLocation)
 
 	// ssh-config fields with a different behavior
 	if h.ProxyCommand == "" {
 		h.ProxyCommand = defaults.ProxyCommand
 	}
-	h.ProxyCommand = utils.ExpandField(h.ProxyCommand)
 
 	// exposed assh fields
 	if len(h.ResolveNameservers) == 0 {
 		h.ResolveNameservers = defaults.ResolveNameservers
 	}
-	// h.ResolveNameservers = utils.ExpandField(h.ResolveNameservers)
 
 	if h.ResolveCommand == "" {
 		h.ResolveCommand = defaults.ResolveCommand
 	}
-	h.ResolveCommand = utils.ExpandField(h.ResolveCommand)
 
 	if h.ControlMasterMkdir == "" {
 		h.ControlMasterMkdir = defaults.ControlMasterMkdir
 	}
-	h.ControlMasterMkdir = utils.ExpandField(h.ControlMasterMkdir)
 
 	if len(h.Gateways) == 0 {
 		h.Gateways = defaults.Gateways
 	}
-	// h.Gateways = utils.ExpandField(h.Gateways)
 
 	if len(h.Aliases) == 0 {
 		h.Aliases = defaults.Aliases
@@ -1110,10 +1099,49 @@ func (h *Host) ApplyDefaults(defaults *Host) {
 		h.RateLimit = defaults.RateLimit
 	}
 
+	if len(h.RateLimitUp) == 0 {
+		h.RateLimitUp = defaults.RateLimitUp
+	}
+
+	if len(h.RateLimitDown) == 0 {
+		h.RateLimitDown = defaults.RateLimitDown
+	}
+
+	if len(h.RateLimitBurst) == 0 {
+		h.RateLimitBurst = defaults.RateLimitBurst
+	}
+
+	if len(h.RateLimitGroup) == 0 {
+		h.RateLimitGroup = defaults.RateLimitGroup
+	}
+
+	if len(h.Record) == 0 {
+		h.Record = defaults.Record
+	}
+
+	if len(h.RecordMaxSize) == 0 {
+		h.RecordMaxSize = defaults.RecordMaxSize
+	}
+
+	if h.RecordMaxFiles == 0 {
+		h.RecordMaxFiles = defaults.RecordMaxFiles
+	}
+
 	if h.GatewayConnectTimeout == 0 {
 		h.GatewayConnectTimeout = defaults.GatewayConnectTimeout
 	}
 
+	if len(defaults.Labels) > 0 {
+		labels := make(map[string]string, len(h.Labels)+len(defaults.Labels))
+		for key, value := range defaults.Labels {
+			labels[key] = value
+		}
+		for key, value := range h.Labels {
+			labels[key] = value
+		}
+		h.Labels = labels
+	}
+
 	if h.Hooks == nil {
 		h.Hooks = defaults.Hooks
 		if h.Hooks == nil {
@@ -1124,14 +1152,12 @@ func (h *Host) ApplyDefaults(defaults *Host) {
 	if len(h.Inherits) == 0 {
 		h.Inherits = defaults.Inherits
 	}
-	// h.Inherits = utils.ExpandField(h.Inherits)
 
 	// private assh fields
 	// h.inherited = make(map[string]bool, 0)
 	if h.inputName == "" {
 		h.inputName = h.name
 	}
-	h.inputName = utils.ExpandField(h.inputName)
 
 	// Extra defaults
 	if h.Port == "" {
@@ -1139,6 +1165,121 @@ func (h *Host) ApplyDefaults(defaults *Host) {
 	}
 }
 
+// expandEnv replaces the environment variables of the fields, it runs once on a computed host so the `$$`
+// escapes are consumed once; the other fields keep their raw values
+func (h *Host) expandEnv(expand func(field, value string) string) {
+	h.AddKeysToAgent = expand("AddKeysToAgent", h.AddKeysToAgent)
+	h.AddressFamily = expand("AddressFamily", h.AddressFamily)
+	h.AskPassGUI = expand("AskPassGUI", h.AskPassGUI)
+	h.BatchMode = expand("BatchMode", h.BatchMode)
+	h.BindAddress = expand("BindAddress", h.BindAddress)
+	h.CanonicalDomains = expand("CanonicalDomains", h.CanonicalDomains)
+	h.CanonicalizeFallbackLocal = expand("CanonicalizeFallbackLocal", h.CanonicalizeFallbackLocal)
+	h.CanonicalizeHostname = expand("CanonicalizeHostname", h.CanonicalizeHostname)
+	h.CanonicalizeMaxDots = expand("CanonicalizeMaxDots", h.CanonicalizeMaxDots)
+	h.CanonicalizePermittedCNAMEs = expand("CanonicalizePermittedCNAMEs", h.CanonicalizePermittedCNAMEs)
+	h.CASignatureAlgorithms = expandSliceEnv("CASignatureAlgorithms", h.CASignatureAlgorithms, expand)
+	h.CertificateFile = expandSliceEnv("CertificateFile", h.CertificateFile, expand)
+	h.ChallengeResponseAuthentication = expand("ChallengeResponseAuthentication", h.ChallengeResponseAuthentication)
+	h.CheckHostIP = expand("CheckHostIP", h.CheckHostIP)
+	h.Cipher = expand("Cipher", h.Cipher)
+	h.Ciphers = expandSliceEnv("Ciphers", h.Ciphers, expand)
+	h.ClearAllForwardings = expand("ClearAllForwardings", h.ClearAllForwardings)
+	h.Compression = expand("Compression", h.Compression)
+	h.ConnectionAttempts = expand("ConnectionAttempts", h.ConnectionAttempts)
+	h.ControlMaster = expand("ControlMaster", h.ControlMaster)
+	h.ControlPath = expand("ControlPath", h.ControlPath)
+	h.ControlPersist = expand("ControlPersist", h.ControlPersist)
+	h.DynamicForward = expandSliceEnv("DynamicForward", h.DynamicForward, expand)
+	h.EnableSSHKeysign = expand("EnableSSHKeysign", h.EnableSSHKeysign)
+	h.EscapeChar = expand("EscapeChar", h.EscapeChar)
+	h.ExitOnForwardFailure = expand("ExitOnForwardFailure", h.ExitOnForwardFailure)
+	h.FingerprintHash = expand("FingerprintHash", h.FingerprintHash)
+	h.ForwardAgent = expand("ForwardAgent", h.ForwardAgent)
+	h.ForwardX11 = expand("ForwardX11", h.ForwardX11)
+	h.ForwardX11Trusted = expand("ForwardX11Trusted", h.ForwardX11Trusted)
+	h.GatewayPorts = expand("GatewayPorts", h.GatewayPorts)
+	h.GlobalKnownHostsFile = expandSliceEnv("GlobalKnownHostsFile", h.GlobalKnownHostsFile, expand)
+	h.GSSAPIAuthentication = expand("GSSAPIAuthentication", h.GSSAPIAuthentication)
+	h.GSSAPIClientIdentity = expand("GSSAPIClientIdentity", h.GSSAPIClientIdentity)
+	h.GSSAPIDelegateCredentials = expand("GSSAPIDelegateCredentials", h.GSSAPIDelegateCredentials)
+	h.GSSAPIKeyExchange = expand("GSSAPIKeyExchange", h.GSSAPIKeyExchange)
+	h.GSSAPIRenewalForcesRekey = expand("GSSAPIRenewalForcesRekey", h.GSSAPIRenewalForcesRekey)
+	h.GSSAPIServerIdentity = expand("GSSAPIServerIdentity", h.GSSAPIServerIdentity)
+	h.GSSAPITrustDNS = expand("GSSAPITrustDNS", h.GSSAPITrustDNS)
+	h.HashKnownHosts = expand("HashKnownHosts", h.HashKnownHosts)
+	h.HostbasedAuthentication = expand("HostbasedAuthentication", h.HostbasedAuthentication)
+	h.HostbasedKeyTypes = expand("HostbasedKeyTypes", h.HostbasedKeyTypes)
+	h.HostKeyAlgorithms = expandSliceEnv("HostKeyAlgorithms", h.HostKeyAlgorithms, expand)
+	h.HostKeyAlias = expand("HostKeyAlias", h.HostKeyAlias)
+	h.HostName = expand("HostName", h.HostName)
+	h.IdentitiesOnly = expand("IdentitiesOnly", h.IdentitiesOnly)
+	h.IdentityAgent = expand("IdentityAgent", h.IdentityAgent)
+	h.IdentityFile = expandSliceEnv("IdentityFile", h.IdentityFile, expand)
+	h.IgnoreUnknown = expand("IgnoreUnknown", h.IgnoreUnknown)
+	h.IPQoS = expandSliceEnv("IPQoS", h.IPQoS, expand)
+	h.KbdInteractiveAuthentication = expand("KbdInteractiveAuthentication", h.KbdInteractiveAuthentication)
+	h.KbdInteractiveDevices = expandSliceEnv("KbdInteractiveDevices", h.KbdInteractiveDevices, expand)
+	h.KexAlgorithms = expandSliceEnv("KexAlgorithms", h.KexAlgorithms, expand)
+	h.KeychainIntegration = expand("KeychainIntegration", h.KeychainIntegration)
+	h.LocalCommand = expand("LocalCommand", h.LocalCommand)
+	h.RemoteCommand = expand("RemoteCommand", h.RemoteCommand)
+	h.LocalForward = expandSliceEnv("LocalForward", h.LocalForward, expand)
+	h.LogLevel = expand("LogLevel", h.LogLevel)
+	h.MACs = expandSliceEnv("MACs", h.MACs, expand)
+	h.Match = expand("Match", h.Match)
+	h.NoHostAuthenticationForLocalhost = expand("NoHostAuthenticationForLocalhost", h.NoHostAuthenticationForLocalhost)
+	h.NumberOfPasswordPrompts = expand("NumberOfPasswordPrompts", h.NumberOfPasswordPrompts)
+	h.PasswordAuthentication = expand("PasswordAuthentication", h.PasswordAuthentication)
+	h.PermitLocalCommand = expand("PermitLocalCommand", h.PermitLocalCommand)
+	h.PKCS11Provider = expand("PKCS11Provider", h.PKCS11Provider)
+	h.Port = expand("Port", h.Port)
+	h.PreferredAuthentications = expand("PreferredAuthentications", h.PreferredAuthentications)
+	h.Protocol = expandSliceEnv("Protocol", h.Protocol, expand)
+	h.ProxyJump = expand("ProxyJump", h.ProxyJump)
+	h.ProxyUseFdpass = expand("ProxyUseFdpass", h.ProxyUseFdpass)
+	h.PubkeyAcceptedAlgorithms = expand("PubkeyAcceptedAlgorithms", h.PubkeyAcceptedAlgorithms)
+	h.PubkeyAcceptedKeyTypes = expand("PubkeyAcceptedKeyTypes", h.PubkeyAcceptedKeyTypes)
+	h.PubkeyAuthentication = expand("PubkeyAuthentication", h.PubkeyAuthentication)
+	h.RekeyLimit = expand("RekeyLimit", h.RekeyLimit)
+	h.RemoteForward = expandSliceEnv("RemoteForward", h.RemoteForward, expand)
+	h.RequestTTY = expand("RequestTTY", h.RequestTTY)
+	h.RevokedHostKeys = expand("RevokedHostKeys", h.RevokedHostKeys)
+	h.RhostsRSAAuthentication = expand("RhostsRSAAuthentication", h.RhostsRSAAuthentication)
+	h.RSAAuthentication = expand("RSAAuthentication", h.RSAAuthentication)
+	h.SendEnv = expandSliceEnv("SendEnv", h.SendEnv, expand)
+	h.StreamLocalBindMask = expand("StreamLocalBindMask", h.StreamLocalBindMask)
+	h.StreamLocalBindUnlink = expand("StreamLocalBindUnlink", h.StreamLocalBindUnlink)
+	h.StrictHostKeyChecking = expand("StrictHostKeyChecking", h.StrictHostKeyChecking)
+	h.TCPKeepAlive = expand("TCPKeepAlive", h.TCPKeepAlive)
+	h.Tunnel = expand("Tunnel", h.Tunnel)
+	h.TunnelDevice = expand("TunnelDevice", h.TunnelDevice)
+	h.UpdateHostKeys = expand("UpdateHostKeys", h.UpdateHostKeys)
+	h.UseKeychain = expand("UseKeychain", h.UseKeychain)
+	h.UsePrivilegedPort = expand("UsePrivilegedPort", h.UsePrivilegedPort)
+	h.User = expand("User", h.User)
+	h.UserKnownHostsFile = expandSliceEnv("UserKnownHostsFile", h.UserKnownHostsFile, expand)
+	h.VerifyHostKeyDNS = expand("VerifyHostKeyDNS", h.VerifyHostKeyDNS)
+	h.VisualHostKey = expand("VisualHostKey", h.VisualHostKey)
+	h.XAuthLocation = expand("XAuthLocation", h.XAuthLocation)
+	h.ProxyCommand = expand("ProxyCommand", h.ProxyCommand)
+	h.ResolveCommand = expand("ResolveCommand", h.ResolveCommand)
+	h.ControlMasterMkdir = expand("ControlMasterMkdir", h.ControlMasterMkdir)
+	h.inputName = expand("inputName", h.inputName)
+}
+
+// expandSliceEnv replaces the environment variables of the entries of a slice field
+func expandSliceEnv(field string, values []string, expand func(field, value string) string) []string {
+	if len(values) == 0 {
+		return values
+	}
+	ret := make([]string, 0, len(values))
+	for _, value := range values {
+		ret = append(ret, expand(field, value))
+	}
+	return ret
+}
+
 // AddKnownHost append target to the host' known hosts list
 func (h *Host) AddKnownHost(target string) {
 	h.knownHosts = append(h.knownHosts, target)
@@ -1514,37 +1655,32 @@ func (h *Host) WriteSSHConfigTo(w io.Writer) error {
 		if h.RateLimit != "" {
 			_, _ = fmt.Fprint(w, stringComment("RateLimit", h.RateLimit))
 		}
+		if h.RateLimitUp != "" {
+			_, _ = fmt.Fprint(w, stringComment("RateLimitUp", h.RateLimitUp))
+		}
+		if h.RateLimitDown != "" {
+			_, _ = fmt.Fprint(w, stringComment("RateLimitDown", h.RateLimitDown))
+		}
+		if h.RateLimitBurst != "" {
+			_, _ = fmt.Fprint(w, stringComment("RateLimitBurst", h.RateLimitBurst))
+		}
+		if h.RateLimitGroup != "" {
+			_, _ = fmt.Fprint(w, stringComment("RateLimitGroup", h.RateLimitGroup))
+		}
+		if h.Record != "" {
+			_, _ = fmt.Fprint(w, stringComment("Record", h.Record))
+		}
+		if h.RecordMaxSize != "" {
+			_, _ = fmt.Fprint(w, stringComment("RecordMaxSize", h.RecordMaxSize))
+		}
+		if h.RecordMaxFiles != 0 {
+			_, _ = fmt.Fprint(w, stringComment("RecordMaxFiles", fmt.Sprintf("%d", h.RecordMaxFiles)))
+		}
+		if len(h.Labels) > 0 {
+			_, _ = fmt.Fprint(w, sliceComment("Labels", h.LabelsList()))
+		}
 
 		aliasIdx++
 	}
 	return nil
 }
-
-// ExpandString replaces elements in a format string with host variables.
-func (h *Host) ExpandString(input string, gateway string) string {
-	output := input
-
-	// name of the host in config
-	output = strings.ReplaceAll(output, "%name", h.Name())
-
-	// original target host name specified on the command line
-	output = strings.ReplaceAll(output, "%n", h.inputName)
-
-	// target host name
-	output = strings.ReplaceAll(output, "%h", h.HostName)
-
-	// port
-	output = strings.ReplaceAll(output, "%p", h.Port)
-
-	// gateway
-	output = strings.ReplaceAll(output, "%g", gateway)
-
-	// FIXME: add
-	//   %L -> first component of the local host name
-	//   %l -> local host name
-	//   %r -> remote login username
-	//   %u -> username of the user running assh
-	//   %r -> remote login username
-
-	return output
-}
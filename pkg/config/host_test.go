@@ -165,6 +165,49 @@ func TestHost_Validate(t *testing.T) {
 			errs = host.Validate()
 			So(len(errs), ShouldEqual, 1)
 		}
+		host.ControlMaster = ""
+
+		host.RateLimit = "10M"
+		host.RateLimitUp = "1MB/s 09:00-18:00 else unlimited"
+		host.RateLimitDown = "unlimited"
+		host.RateLimitBurst = "64K"
+		host.RateLimitGroup = "datacenter-eu"
+		So(len(host.Validate()), ShouldEqual, 0)
+		burst, err := host.RateLimitBurstBytes()
+		So(err, ShouldBeNil)
+		So(burst, ShouldEqual, 64000)
+
+		host.RateLimitUp = "1MB/s 09:00-18:00 else"
+		host.RateLimitBurst = "a lot"
+		host.RateLimitGroup = "../eu"
+		errs = host.Validate()
+		So(len(errs), ShouldEqual, 3)
+		So(errs[0].Error(), ShouldStartWith, `"abc": invalid value for 'RateLimitUp'`)
+		So(errs[1].Error(), ShouldStartWith, `"abc": invalid value for 'RateLimitBurst'`)
+		So(errs[2].Error(), ShouldStartWith, `"abc": invalid value for 'RateLimitGroup'`)
+		host.RateLimitUp = ""
+		host.RateLimitBurst = ""
+		host.RateLimitGroup = ""
+
+		size, err := host.RecordMaxSizeBytes()
+		So(err, ShouldBeNil)
+		So(size, ShouldEqual, 100*1000*1000)
+		So(host.RecordMaxFilesCount(), ShouldEqual, 10)
+		host.Record = "~/.ssh/captures"
+		host.RecordMaxSize = "1MB"
+		host.RecordMaxFiles = 3
+		So(len(host.Validate()), ShouldEqual, 0)
+		size, err = host.RecordMaxSizeBytes()
+		So(err, ShouldBeNil)
+		So(size, ShouldEqual, 1000*1000)
+		So(host.RecordMaxFilesCount(), ShouldEqual, 3)
+
+		host.RecordMaxSize = "huge"
+		host.RecordMaxFiles = -1
+		errs = host.Validate()
+		So(len(errs), ShouldEqual, 2)
+		So(errs[0].Error(), ShouldStartWith, `"abc": invalid value for 'RecordMaxSize'`)
+		So(errs[1].Error(), ShouldStartWith, `"abc": invalid value for 'RecordMaxFiles'`)
 	})
 }
 
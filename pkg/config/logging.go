@@ -0,0 +1,188 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/moul/flexyaml"
+	"go.uber.org/zap/zapcore"
+	"moul.io/assh/v2/pkg/utils"
+)
+
+const (
+	defaultLoggingMaxSize    = 10 * 1000 * 1000
+	defaultLoggingMaxBackups = 3
+)
+
+// LoggingConfig configures the logs of assh, the ASSH_LOG_* environment variables override it
+type LoggingConfig struct {
+	// Format is "console" (default) or "json", overridden by ASSH_LOG_FORMAT
+	Format string `yaml:"format,omitempty,flow" json:"Format,omitempty"`
+	// File writes the logs to a file instead of stderr, overridden by ASSH_LOG_FILE
+	File string `yaml:"file,omitempty,flow" json:"File,omitempty"`
+	// MaxSize rotates the file when it would exceed this size, i.e: 10MB (default)
+	MaxSize string `yaml:"maxsize,omitempty,flow" json:"MaxSize,omitempty"`
+	// MaxBackups is the amount of rotated files kept, 3 by default
+	MaxBackups int `yaml:"maxbackups,omitempty,flow" json:"MaxBackups,omitempty"`
+	// Level is the minimal level of the logs, overridden by ASSH_LOG_LEVEL, --debug and --verbose can lower it
+	Level string `yaml:"level,omitempty,flow" json:"Level,omitempty"`
+	// Levels overrides the level of some packages, i.e: {commands: debug, hooks: error}, overridden by
+	// ASSH_LOG_LEVELS=commands=debug,hooks=error
+	Levels map[string]string `yaml:"levels,omitempty,flow" json:"Levels,omitempty"`
+}
+
+// OpenLogging reads the logging section of a configuration file and of its includes, without loading
+// the hosts, so the logger can be initialized before the configuration is opened; a missing file is
+// an empty section and the errors of the includes are left to Open
+func OpenLogging(path string) (*LoggingConfig, error) {
+	logging := &LoggingConfig{}
+	if err := logging.loadFile(path, map[string]bool{}); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return logging, nil
+}
+
+func (l *LoggingConfig) loadFile(filename string, includedFiles map[string]bool) error {
+	path, err := utils.ExpandUser(filename)
+	if err != nil {
+		return err
+	}
+	if includedFiles[path] {
+		return nil
+	}
+	includedFiles[path] = true
+
+	buf, err := ioutil.ReadFile(path) // #nosec
+	if err != nil {
+		return err
+	}
+	// the later files override the fields of the section, like Config.LoadConfig does
+	section := struct {
+		Includes []string       `yaml:"includes,omitempty,flow"`
+		Logging  *LoggingConfig `yaml:"logging,omitempty,flow"`
+	}{Logging: l}
+	if err := flexyaml.Unmarshal(buf, &section); err != nil {
+		return err
+	}
+
+	for _, include := range section.Includes {
+		pattern, err := utils.ExpandUser(include)
+		if err != nil {
+			continue
+		}
+		filenames, _ := filepath.Glob(pattern)
+		for _, filename := range filenames {
+			_ = l.loadFile(filename, includedFiles)
+		}
+	}
+	return nil
+}
+
+// ApplyEnv overrides the configuration with the ASSH_LOG_* environment variables
+func (l *LoggingConfig) ApplyEnv() {
+	if format := os.Getenv("ASSH_LOG_FORMAT"); format != "" {
+		l.Format = format
+	}
+	if file := os.Getenv("ASSH_LOG_FILE"); file != "" {
+		l.File = file
+	}
+	if level := os.Getenv("ASSH_LOG_LEVEL"); level != "" {
+		l.Level = level
+	}
+	if levels := os.Getenv("ASSH_LOG_LEVELS"); levels != "" {
+		if l.Levels == nil {
+			l.Levels = map[string]string{}
+		}
+		for _, pair := range strings.Split(levels, ",") {
+			parts := strings.SplitN(pair, "=", 2)
+			if len(parts) == 2 {
+				l.Levels[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+			} else {
+				// reported by Validate
+				l.Levels[pair] = ""
+			}
+		}
+	}
+}
+
+// IsJSON returns true when the logs are encoded in JSON
+func (l *LoggingConfig) IsJSON() bool {
+	return l != nil && strings.ToLower(l.Format) == "json"
+}
+
+// MaxSizeBytes returns the parsed MaxSize, or the default size
+func (l *LoggingConfig) MaxSizeBytes() (int64, error) {
+	if l == nil || l.MaxSize == "" {
+		return defaultLoggingMaxSize, nil
+	}
+	size, err := humanize.ParseBytes(l.MaxSize)
+	if err != nil || size == 0 {
+		return 0, fmt.Errorf("invalid value for 'MaxSize': %q", l.MaxSize)
+	}
+	return int64(size), nil
+}
+
+// MaxBackupsCount returns MaxBackups, or the default amount
+func (l *LoggingConfig) MaxBackupsCount() int {
+	if l == nil || l.MaxBackups == 0 {
+		return defaultLoggingMaxBackups
+	}
+	return l.MaxBackups
+}
+
+// ParsedLevel returns the parsed Level, ok is false when it is not configured
+func (l *LoggingConfig) ParsedLevel() (level zapcore.Level, ok bool, err error) {
+	if l == nil || l.Level == "" {
+		return zapcore.WarnLevel, false, nil
+	}
+	if err := level.UnmarshalText([]byte(l.Level)); err != nil {
+		return zapcore.WarnLevel, false, fmt.Errorf("invalid value for 'Level': %q", l.Level)
+	}
+	return level, true, nil
+}
+
+// PackageLevels returns the parsed Levels, indexed by logger name, i.e: "assh.pkg.commands"
+func (l *LoggingConfig) PackageLevels() (map[string]zapcore.Level, error) {
+	levels := map[string]zapcore.Level{}
+	if l == nil {
+		return levels, nil
+	}
+	for name, value := range l.Levels {
+		var level zapcore.Level
+		if err := level.UnmarshalText([]byte(value)); err != nil || value == "" {
+			return nil, fmt.Errorf("invalid value for 'Levels': %q for %q", value, name)
+		}
+		if !strings.HasPrefix(name, "assh.") {
+			name = "assh.pkg." + name
+		}
+		levels[name] = level
+	}
+	return levels, nil
+}
+
+// Validate checks for values errors
+func (l *LoggingConfig) Validate() error {
+	if l == nil {
+		return nil
+	}
+	switch strings.ToLower(l.Format) {
+	case "", "console", "json":
+	default:
+		return fmt.Errorf("invalid value for 'Format': %q, should be 'console' or 'json'", l.Format)
+	}
+	if l.MaxBackups < 0 {
+		return fmt.Errorf("invalid value for 'MaxBackups': %d", l.MaxBackups)
+	}
+	if _, err := l.MaxSizeBytes(); err != nil {
+		return err
+	}
+	if _, _, err := l.ParsedLevel(); err != nil {
+		return err
+	}
+	_, err := l.PackageLevels()
+	return err
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// selectorRequirement is a single `key=value`, `key!=value`, `key` or `!key` condition
+type selectorRequirement struct {
+	key      string
+	value    string
+	operator string
+}
+
+// Selector filters hosts based on their labels, i.e: "env=prod,role!=db"
+type Selector []selectorRequirement
+
+// ParseSelector parses a comma-separated list of label requirements
+func ParseSelector(input string) (Selector, error) {
+	selector := Selector{}
+	for _, part := range strings.Split(input, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		var requirement selectorRequirement
+		switch {
+		case strings.Contains(part, "!="):
+			kv := strings.SplitN(part, "!=", 2)
+			requirement = selectorRequirement{key: kv[0], value: kv[1], operator: "!="}
+		case strings.Contains(part, "=="):
+			kv := strings.SplitN(part, "==", 2)
+			requirement = selectorRequirement{key: kv[0], value: kv[1], operator: "="}
+		case strings.Contains(part, "="):
+			kv := strings.SplitN(part, "=", 2)
+			requirement = selectorRequirement{key: kv[0], value: kv[1], operator: "="}
+		case strings.HasPrefix(part, "!"):
+			requirement = selectorRequirement{key: part[1:], operator: "!"}
+		default:
+			requirement = selectorRequirement{key: part, operator: ""}
+		}
+
+		// label keys are case insensitive, like all the other keys of the configuration
+		requirement.key = strings.ToLower(strings.TrimSpace(requirement.key))
+		requirement.value = strings.TrimSpace(requirement.value)
+		if requirement.key == "" {
+			return nil, fmt.Errorf("invalid selector %q: missing label name", part)
+		}
+		selector = append(selector, requirement)
+	}
+	return selector, nil
+}
+
+// Matches returns true if the labels of the host match all the requirements
+func (s Selector) Matches(host *Host) bool {
+	for _, requirement := range s {
+		value, found := host.Labels[requirement.key]
+		switch requirement.operator {
+		case "=":
+			if !found || value != requirement.value {
+				return false
+			}
+		case "!=":
+			if found && value == requirement.value {
+				return false
+			}
+		case "!":
+			if found {
+				return false
+			}
+		default:
+			if !found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// String returns the canonical representation of the selector
+func (s Selector) String() string {
+	parts := make([]string, 0, len(s))
+	for _, requirement := range s {
+		switch requirement.operator {
+		case "=", "!=":
+			parts = append(parts, requirement.key+requirement.operator+requirement.value)
+		default:
+			parts = append(parts, requirement.operator+requirement.key)
+		}
+	}
+	return strings.Join(parts, ",")
+}
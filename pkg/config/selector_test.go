@@ -0,0 +1,98 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseSelector(t *testing.T) {
+	Convey("Testing ParseSelector()", t, func() {
+		selector, err := ParseSelector("Env=prod, role!=db,,monitored,!legacy,zone==eu")
+		So(err, ShouldBeNil)
+		So(len(selector), ShouldEqual, 5)
+		So(selector.String(), ShouldEqual, "env=prod,role!=db,monitored,!legacy,zone=eu")
+
+		selector, err = ParseSelector("")
+		So(err, ShouldBeNil)
+		So(len(selector), ShouldEqual, 0)
+
+		_, err = ParseSelector("=prod")
+		So(err, ShouldNotBeNil)
+		_, err = ParseSelector("!")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestSelector_Matches(t *testing.T) {
+	Convey("Testing Selector.Matches()", t, func() {
+		host := NewHost("web1")
+		host.Labels = map[string]string{"env": "prod", "role": "web"}
+
+		for input, expected := range map[string]bool{
+			"":                   true,
+			"env=prod":           true,
+			"env=staging":        false,
+			"env=prod,role=web":  true,
+			"env=prod,role!=web": false,
+			"role!=db":           true,
+			"owner!=moul":        true,
+			"role":               true,
+			"owner":              false,
+			"!owner":             true,
+			"!env":               false,
+		} {
+			selector, err := ParseSelector(input)
+			So(err, ShouldBeNil)
+			So(selector.Matches(host), ShouldEqual, expected)
+		}
+	})
+}
+
+func TestConfig_SelectHosts(t *testing.T) {
+	Convey("Testing Config.SelectHosts()", t, func() {
+		config := New()
+		err := config.LoadConfig(strings.NewReader(`
+hosts:
+  web1:
+    Inherits: web
+    Labels:
+      Env: prod
+  web2:
+    Inherits: web
+  db1:
+    Labels:
+      role: db
+  "*.lan":
+    Labels:
+      env: dev
+templates:
+  web:
+    Labels:
+      role: web
+defaults:
+  Labels:
+    env: staging
+`))
+		So(err, ShouldBeNil)
+
+		hostNames := func(input string) []string {
+			selector, err := ParseSelector(input)
+			So(err, ShouldBeNil)
+			hosts, err := config.SelectHosts(selector)
+			So(err, ShouldBeNil)
+			names := []string{}
+			for _, host := range hosts {
+				names = append(names, host.Name())
+			}
+			return names
+		}
+
+		So(hostNames(""), ShouldResemble, []string{"db1", "web1", "web2"})
+		So(hostNames("role=web"), ShouldResemble, []string{"web1", "web2"})
+		So(hostNames("env=prod"), ShouldResemble, []string{"web1"})
+		So(hostNames("env=staging"), ShouldResemble, []string{"db1", "web2"})
+		So(hostNames("env=dev"), ShouldResemble, []string{})
+	})
+}
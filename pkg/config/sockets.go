@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+const defaultSocketsGCInterval = time.Minute
+
+// SocketsConfig configures the garbage collection of the control sockets
+type SocketsConfig struct {
+	// GCInterval is the delay between two scans of `assh sockets gc --watch`
+	GCInterval string `yaml:"gcinterval,omitempty,flow" json:"GCInterval,omitempty"`
+	// IdleTimeout closes the masters without sessions for this duration, empty disables it
+	IdleTimeout string `yaml:"idletimeout,omitempty,flow" json:"IdleTimeout,omitempty"`
+	// CloseRemovedHosts closes the masters of hosts that are not configured anymore
+	CloseRemovedHosts bool `yaml:"closeremovedhosts,omitempty,flow" json:"CloseRemovedHosts,omitempty"`
+}
+
+// GCIntervalDuration returns the parsed GCInterval, or the default interval
+func (s *SocketsConfig) GCIntervalDuration() (time.Duration, error) {
+	if s == nil || s.GCInterval == "" {
+		return defaultSocketsGCInterval, nil
+	}
+	interval, err := time.ParseDuration(s.GCInterval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for 'GCInterval': %q", s.GCInterval)
+	}
+	if interval <= 0 {
+		return 0, fmt.Errorf("invalid value for 'GCInterval': %q, should be positive", s.GCInterval)
+	}
+	return interval, nil
+}
+
+// IdleTimeoutDuration returns the parsed IdleTimeout, zero means that idle masters are kept
+func (s *SocketsConfig) IdleTimeoutDuration() (time.Duration, error) {
+	if s == nil || s.IdleTimeout == "" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(s.IdleTimeout)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid value for 'IdleTimeout': %q", s.IdleTimeout)
+	}
+	return timeout, nil
+}
+
+// Validate checks for values errors
+func (s *SocketsConfig) Validate() error {
+	if _, err := s.GCIntervalDuration(); err != nil {
+		return err
+	}
+	_, err := s.IdleTimeoutDuration()
+	return err
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"os/user"
+	"strings"
+
+	"moul.io/assh/v2/pkg/controlsockets"
+)
+
+// Tokens are the values of the `%` tokens of the commands and paths of a host, see TOKENS in
+// ssh_config(5), plus the assh-specific `%name` (name of the host in the config) and `%g` (gateway)
+type Tokens struct {
+	Local   controlsockets.LocalTokens
+	Remote  controlsockets.RemoteTokens
+	Name    string
+	Gateway string
+}
+
+// ExpandTokens replaces the tokens of input in a single pass, so that the replaced values are never
+// expanded again; `%%` is a literal `%` and the unknown tokens are kept as is
+func ExpandTokens(input string, tokens Tokens) string {
+	if !strings.Contains(input, "%") {
+		return input
+	}
+
+	keyAlias := tokens.Remote.HostKeyAlias
+	if keyAlias == "" {
+		keyAlias = tokens.Remote.OriginalHost
+	}
+
+	var output strings.Builder
+	for i := 0; i < len(input); i++ {
+		if input[i] != '%' || i+1 == len(input) {
+			output.WriteByte(input[i])
+			continue
+		}
+		// `%name` takes precedence over `%n`
+		if strings.HasPrefix(input[i+1:], "name") {
+			output.WriteString(tokens.Name)
+			i += len("name")
+			continue
+		}
+
+		var value string
+		switch input[i+1] {
+		case '%':
+			value = "%"
+		case 'C':
+			value = controlsockets.ConnectionHash(tokens.Local, tokens.Remote)
+		case 'd':
+			value = tokens.Local.HomeDir
+		case 'g':
+			value = tokens.Gateway
+		case 'h':
+			value = tokens.Remote.Host
+		case 'i':
+			value = tokens.Local.UID
+		case 'j':
+			value = tokens.Remote.ProxyJump
+		case 'k':
+			value = keyAlias
+		case 'L':
+			value = tokens.Local.ShortHostname
+		case 'l':
+			value = tokens.Local.Hostname
+		case 'n':
+			value = tokens.Remote.OriginalHost
+		case 'p':
+			value = tokens.Remote.Port
+		case 'r':
+			value = tokens.Remote.User
+		case 'T':
+			value = "NONE"
+		case 'u':
+			value = tokens.Local.Username
+		default:
+			output.WriteByte(input[i])
+			continue
+		}
+		output.WriteString(value)
+		i++
+	}
+	return output.String()
+}
+
+// remoteUser returns the User of the host, ssh defaults to the local username
+func (h *Host) remoteUser() string {
+	if h.User != "" {
+		return h.User
+	}
+	if userdata, err := user.Current(); err == nil {
+		return userdata.Username
+	}
+	return "username"
+}
+
+// Tokens returns the values of the tokens of the commands of the host, such as ProxyCommand and
+// ResolveCommand, reached through the gateway
+func (h *Host) Tokens(gateway string) Tokens {
+	return Tokens{
+		Local: controlsockets.CurrentLocalTokens(),
+		Remote: controlsockets.RemoteTokens{
+			Host:         h.HostName,
+			OriginalHost: h.inputName,
+			HostKeyAlias: h.HostKeyAlias,
+			Port:         h.Port,
+			User:         h.remoteUser(),
+			ProxyJump:    h.ProxyJump,
+		},
+		Name:    h.Name(),
+		Gateway: gateway,
+	}
+}
+
+// ControlPathTokens returns the values of the tokens of the ControlPath of the host; assh does not write
+// the HostName in the generated ssh config, so ssh sees the name of the host as the remote hostname
+func (h *Host) ControlPathTokens() Tokens {
+	tokens := h.Tokens("")
+	tokens.Remote.Host = h.Name()
+	tokens.Remote.OriginalHost = h.Name()
+	return tokens
+}
+
+// ExpandString replaces the tokens of a command with the values of the host reached through the gateway
+func (h *Host) ExpandString(input string, gateway string) string {
+	if !strings.Contains(input, "%") {
+		return input
+	}
+	return ExpandTokens(input, h.Tokens(gateway))
+}
+
+// ExpandControlPath returns the path of the control socket of the host, like ssh computes it
+func (h *Host) ExpandControlPath() string {
+	tokens := h.ControlPathTokens()
+	controlPath := h.ControlPath
+	if strings.HasPrefix(controlPath, "~") {
+		controlPath = tokens.Local.HomeDir + controlPath[1:]
+	}
+	return ExpandTokens(controlPath, tokens)
+}
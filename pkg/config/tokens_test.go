@@ -0,0 +1,85 @@
+package config
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"moul.io/assh/v2/pkg/controlsockets"
+)
+
+func TestExpandTokens(t *testing.T) {
+	Convey("Testing ExpandTokens()", t, func() {
+		tokens := Tokens{
+			Local: controlsockets.LocalTokens{
+				HomeDir:       "/home/moul",
+				UID:           "1000",
+				ShortHostname: "laptop",
+				Hostname:      "laptop.example.com",
+				Username:      "moul",
+			},
+			Remote: controlsockets.RemoteTokens{
+				Host:         "1.2.3.4",
+				OriginalHost: "bart",
+				Port:         "2222",
+				User:         "root",
+				ProxyJump:    "jump",
+			},
+			Name:    "bart*",
+			Gateway: "homer",
+		}
+		hash := controlsockets.ConnectionHash(tokens.Local, tokens.Remote)
+
+		for _, tt := range []struct {
+			input    string
+			expected string
+		}{
+			{"ls -la", "ls -la"},
+			{"", ""},
+			{"nc %h %p", "nc 1.2.3.4 2222"},
+			{"%d/.ssh/cm/%r@%h:%p", "/home/moul/.ssh/cm/root@1.2.3.4:2222"},
+			{"%L %l %u %i", "laptop laptop.example.com moul 1000"},
+			{"%n %k %j %T", "bart bart jump NONE"},
+			{"/tmp/%C", "/tmp/" + hash},
+			{"/tmp/%%C", "/tmp/%C"},
+			{"/tmp/%%%C", "/tmp/%" + hash},
+			{"%%%%h", "%%h"},
+			{"ssh %name", "ssh bart*"},
+			{"%name%n%names", "bart*bartbart*s"},
+			{"%%name", "%name"},
+			{"echo %g", "echo homer"},
+			{"unknown %z %Z", "unknown %z %Z"},
+			{"trailing %", "trailing %"},
+			{"%", "%"},
+			{"100%", "100%"},
+		} {
+			So(ExpandTokens(tt.input, tokens), ShouldEqual, tt.expected)
+		}
+
+		Convey("host key alias", func() {
+			tokens.Remote.HostKeyAlias = "alias"
+			So(ExpandTokens("%k", tokens), ShouldEqual, "alias")
+		})
+
+		Convey("values are not expanded again", func() {
+			tokens.Remote.Host = "%p"
+			tokens.Name = "%h"
+			So(ExpandTokens("%h %name", tokens), ShouldEqual, "%p %h")
+		})
+	})
+}
+
+func TestHost_ExpandControlPath(t *testing.T) {
+	Convey("Testing Host.ExpandControlPath()", t, func() {
+		host := NewHost("gw/bart")
+		host.HostName = "1.2.3.4"
+		host.Port = "22"
+		host.User = "root"
+		host.ControlPath = "/tmp/cm/%h-%p-%r-%n"
+		So(host.ExpandControlPath(), ShouldEqual, "/tmp/cm/gw/bart-22-root-gw/bart")
+		So(host.ExpandString("%h-%name", "gw"), ShouldEqual, "1.2.3.4-gw/bart")
+
+		host.ControlPath = "~/.ssh/cm/%C"
+		tokens := host.ControlPathTokens()
+		So(host.ExpandControlPath(), ShouldEqual, tokens.Local.HomeDir+"/.ssh/cm/"+controlsockets.ConnectionHash(tokens.Local, tokens.Remote))
+	})
+}
@@ -0,0 +1,48 @@
+// +build linux
+
+package controlsockets
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+)
+
+var (
+	// procNetUnixPath lists the unix-domain sockets of the system
+	procNetUnixPath = "/proc/net/unix"
+	// procNetUnixLine matches the state and the path of a socket, the path is the rest of the line and may
+	// contain spaces, the inode is padded with spaces
+	procNetUnixLine = regexp.MustCompile(`^\s*\S+:(?:\s+\S+){4}\s+(\S+)\s+\S+ (.+)$`)
+)
+
+// countConnections returns the amount of connected sockets accepted on a listening unix-domain socket
+func countConnections(socketPath string) (int, error) {
+	file, err := os.Open(procNetUnixPath)
+	if err != nil {
+		return -1, err
+	}
+	defer file.Close()
+	return countConnectionsFrom(file, socketPath)
+}
+
+// countConnectionsFrom parses a /proc/net/unix table:
+//   Num       RefCount Protocol Flags    Type St Inode Path
+//   0000000000000000: 00000002 00000000 00000000 0001 03 12345 /tmp/ssh.sock
+func countConnectionsFrom(r io.Reader, socketPath string) (int, error) {
+	const connectedState = "03"
+
+	count := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		match := procNetUnixLine.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		if match[1] == connectedState && match[2] == socketPath {
+			count++
+		}
+	}
+	return count, scanner.Err()
+}
@@ -0,0 +1,41 @@
+// +build linux
+
+package controlsockets
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCountConnectionsFrom(t *testing.T) {
+	Convey("Testing countConnectionsFrom()", t, func() {
+		table := `Num       RefCount Protocol Flags    Type St Inode Path
+0000000000000000: 00000002 00000000 00010000 0001 01 20001 /home/moul/.ssh/cm/bart-22-root.sock
+0000000000000000: 00000003 00000000 00000000 0001 03 20002 /home/moul/.ssh/cm/bart-22-root.sock
+0000000000000000: 00000003 00000000 00000000 0001 03 20003 /home/moul/.ssh/cm/bart-22-root.sock
+0000000000000000: 00000003 00000000 00000000 0001 03 20004 /home/moul/.ssh/cm/marge-22-root.sock
+0000000000000000: 00000003 00000000 00000000 0001 03 20005
+0000000000000000: 00000003 00000000 00000000 0001 03   876 /home/moul/My Sockets/bart-22-root.sock
+0000000000000000: 00000003 00000000 00000000 0001 03 20006 /home/moul/My Sockets/bart-22-root.sock
+0000000000000000: 00000002 00000000 00010000 0001 01 20007 /home/moul/My Sockets/bart-22-root.sock
+0000000000000000: 00000003 00000000 00000000 0001 03 20008 /home/moul/My
+`
+		count, err := countConnectionsFrom(strings.NewReader(table), "/home/moul/.ssh/cm/bart-22-root.sock")
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 2)
+
+		count, err = countConnectionsFrom(strings.NewReader(table), "/home/moul/.ssh/cm/lisa-22-root.sock")
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 0)
+
+		// the paths may contain spaces
+		count, err = countConnectionsFrom(strings.NewReader(table), "/home/moul/My Sockets/bart-22-root.sock")
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 2)
+		count, err = countConnectionsFrom(strings.NewReader(table), "/home/moul/My")
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 1)
+	})
+}
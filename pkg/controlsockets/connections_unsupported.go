@@ -0,0 +1,10 @@
+// +build !linux
+
+package controlsockets
+
+import "fmt"
+
+// countConnections returns the amount of connected sockets accepted on a listening unix-domain socket
+func countConnections(_ string) (int, error) {
+	return -1, fmt.Errorf("not supported on this platform")
+}
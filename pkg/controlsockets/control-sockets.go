@@ -1,43 +1,42 @@
 package controlsockets
 
 import (
-	"fmt"
+	"errors"
 	"os"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/mattn/go-zglob"
-	"moul.io/assh/v2/pkg/utils"
 )
 
 // ControlSocket defines a unix-domain socket controlled by a master SSH process
 type ControlSocket struct {
 	path        string
 	controlPath string
+	pattern     *ControlPathPattern
 }
 
 // ControlSockets is a list of ControlSocket
 type ControlSockets []ControlSocket
 
-func translateControlPath(input string) string {
-	controlPath, err := utils.ExpandUser(input)
-	if err != nil {
-		return input
-	}
-
-	controlPath = strings.ReplaceAll(controlPath, "%h", "**/*")
-
-	for _, component := range []string{"%L", "%p", "%n", "%C", "%l", "%r"} {
-		controlPath = strings.ReplaceAll(controlPath, component, "*")
+// NewControlSocket returns the ControlSocket of an expanded ControlPath
+func NewControlSocket(path string) ControlSocket {
+	return ControlSocket{
+		path:        path,
+		controlPath: filepath.Join(filepath.Dir(path), "*"),
 	}
-	return controlPath
 }
 
 // LookupControlPathDir returns the ControlSockets in the ControlPath directory
 func LookupControlPathDir(controlPath string) (ControlSockets, error) {
-	controlPath = translateControlPath(controlPath)
+	pattern, err := CompileControlPath(controlPath, CurrentLocalTokens())
+	if err != nil {
+		return nil, err
+	}
 
-	matches, err := zglob.Glob(controlPath)
+	matches, err := zglob.Glob(pattern.Glob())
 	if err != nil {
 		return nil, err
 	}
@@ -46,7 +45,8 @@ func LookupControlPathDir(controlPath string) (ControlSockets, error) {
 	for _, socketPath := range matches {
 		list = append(list, ControlSocket{
 			path:        socketPath,
-			controlPath: controlPath,
+			controlPath: pattern.Glob(),
+			pattern:     pattern,
 		})
 	}
 	return list, nil
@@ -63,6 +63,14 @@ func (s *ControlSocket) RelativePath() string {
 	return s.path[idx:]
 }
 
+// RemoteTokens returns the destination of the socket, parsed from its path
+func (s *ControlSocket) RemoteTokens() (RemoteTokens, bool) {
+	if s.pattern == nil {
+		return RemoteTokens{}, false
+	}
+	return s.pattern.Parse(s.path)
+}
+
 // CreatedAt returns the modification time of the sock file
 func (s *ControlSocket) CreatedAt() (time.Time, error) {
 	stat, err := os.Stat(s.path)
@@ -75,5 +83,72 @@ func (s *ControlSocket) CreatedAt() (time.Time, error) {
 
 // ActiveConnections returns the amount of active connections using a control socket
 func (s *ControlSocket) ActiveConnections() (int, error) {
-	return -1, fmt.Errorf("not implemented")
+	return countConnections(s.path)
+}
+
+// MasterPID asks the master process if it is alive and returns its pid
+func (s *ControlSocket) MasterPID() (int, error) {
+	client, err := dialMux(s.path)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+	return client.aliveCheck()
+}
+
+// Terminate asks the master process to exit, the master removes the socket itself
+func (s *ControlSocket) Terminate() error {
+	client, err := dialMux(s.path)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.terminate()
+}
+
+// Remove deletes the socket file
+func (s *ControlSocket) Remove() error {
+	return os.Remove(s.path)
+}
+
+// Status describes a control socket and the state of its master process
+type Status struct {
+	Path              string    `json:"path"`
+	RelativePath      string    `json:"relative_path"`
+	CreatedAt         time.Time `json:"created_at"`
+	Alive             bool      `json:"alive"`
+	Stale             bool      `json:"stale"`
+	MasterPID         int       `json:"master_pid,omitempty"`
+	ActiveConnections int       `json:"active_connections"`
+	Error             string    `json:"error,omitempty"`
+}
+
+// Status inspects the socket file and its master process
+func (s *ControlSocket) Status() Status {
+	status := Status{
+		Path:              s.path,
+		RelativePath:      s.RelativePath(),
+		ActiveConnections: -1,
+	}
+
+	createdAt, err := s.CreatedAt()
+	if err == nil {
+		status.CreatedAt = createdAt
+	}
+
+	// count before dialing the master, so our own connection is not counted
+	if count, err := s.ActiveConnections(); err == nil {
+		status.ActiveConnections = count
+	}
+
+	pid, err := s.MasterPID()
+	if err != nil {
+		// nobody is listening anymore, the master process is gone
+		status.Stale = errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, os.ErrNotExist)
+		status.Error = err.Error()
+		return status
+	}
+	status.Alive = true
+	status.MasterPID = pid
+	return status
 }
@@ -0,0 +1,177 @@
+package controlsockets
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// OpenSSH multiplexing protocol, see PROTOCOL.mux in the OpenSSH sources
+const (
+	muxProtocolVersion = 4
+
+	muxMsgHello    = 0x00000001
+	muxCAliveCheck = 0x10000004
+	muxCTerminate  = 0x10000005
+
+	muxSOk               = 0x80000001
+	muxSPermissionDenied = 0x80000002
+	muxSFailure          = 0x80000003
+	muxSAlive            = 0x80000005
+)
+
+// muxTimeout is the maximum duration of a request to a master process
+var muxTimeout = 2 * time.Second
+
+// muxClient speaks the OpenSSH multiplexing protocol with a master process
+type muxClient struct {
+	conn      net.Conn
+	requestID uint32
+}
+
+// dialMux connects to a control socket and exchanges the hello messages
+func dialMux(path string) (*muxClient, error) {
+	conn, err := net.DialTimeout("unix", path, muxTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Now().Add(muxTimeout)); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	client := &muxClient{conn: conn}
+	if err := client.send(muxMsgHello, muxProtocolVersion); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	reply, err := client.recv()
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	msgType, err := readUint32(reply)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if msgType != muxMsgHello {
+		_ = conn.Close()
+		return nil, fmt.Errorf("expected mux hello, got message type %#x", msgType)
+	}
+	return client, nil
+}
+
+func (c *muxClient) Close() error {
+	return c.conn.Close()
+}
+
+// aliveCheck returns the pid of the master process
+func (c *muxClient) aliveCheck() (int, error) {
+	msgType, reply, err := c.request(muxCAliveCheck)
+	if err != nil {
+		return 0, err
+	}
+	if msgType != muxSAlive {
+		return 0, replyError(msgType, reply)
+	}
+	pid, err := readUint32(reply)
+	if err != nil {
+		return 0, err
+	}
+	return int(pid), nil
+}
+
+// terminate asks the master process to exit
+func (c *muxClient) terminate() error {
+	msgType, reply, err := c.request(muxCTerminate)
+	if err != nil {
+		return err
+	}
+	if msgType != muxSOk {
+		return replyError(msgType, reply)
+	}
+	return nil
+}
+
+// request sends a message with a new request id and returns the type of the reply
+// and its remaining payload, after checking the request id
+func (c *muxClient) request(msgType uint32) (uint32, *bytes.Reader, error) {
+	c.requestID++
+	if err := c.send(msgType, c.requestID); err != nil {
+		return 0, nil, err
+	}
+	reply, err := c.recv()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	replyType, err := readUint32(reply)
+	if err != nil {
+		return 0, nil, err
+	}
+	requestID, err := readUint32(reply)
+	if err != nil {
+		return 0, nil, err
+	}
+	if requestID != c.requestID {
+		return 0, nil, fmt.Errorf("unexpected mux request id %d, expected %d", requestID, c.requestID)
+	}
+	return replyType, reply, nil
+}
+
+func (c *muxClient) send(values ...uint32) error {
+	packet := make([]byte, 4*(len(values)+1))
+	binary.BigEndian.PutUint32(packet, uint32(4*len(values)))
+	for idx, value := range values {
+		binary.BigEndian.PutUint32(packet[4*(idx+1):], value)
+	}
+	_, err := c.conn.Write(packet)
+	return err
+}
+
+func (c *muxClient) recv() (*bytes.Reader, error) {
+	var length uint32
+	if err := binary.Read(c.conn, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length > 256*1024 {
+		return nil, fmt.Errorf("mux packet too large (%d bytes)", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.conn, payload); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(payload), nil
+}
+
+func readUint32(r io.Reader) (uint32, error) {
+	var value uint32
+	err := binary.Read(r, binary.BigEndian, &value)
+	return value, err
+}
+
+func remaining(r *bytes.Reader) []byte {
+	buf := make([]byte, r.Len())
+	_, _ = io.ReadFull(r, buf)
+	return buf
+}
+
+// replyError converts a failure reply to an error
+func replyError(msgType uint32, reply *bytes.Reader) error {
+	var reason string
+	if length, err := readUint32(reply); err == nil && int(length) <= reply.Len() {
+		reason = string(remaining(reply)[:length])
+	}
+	switch msgType {
+	case muxSPermissionDenied:
+		return fmt.Errorf("mux permission denied: %s", reason)
+	case muxSFailure:
+		return fmt.Errorf("mux failure: %s", reason)
+	default:
+		return fmt.Errorf("unexpected mux message type %#x", msgType)
+	}
+}
@@ -0,0 +1,107 @@
+package controlsockets
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// fakeMaster answers the mux requests like an OpenSSH master process would
+func fakeMaster(t *testing.T, path string, pid uint32) net.Listener {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets are not supported: %v", err)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				client := &muxClient{conn: conn}
+				if err := client.send(muxMsgHello, muxProtocolVersion); err != nil {
+					return
+				}
+				for {
+					packet, err := client.recv()
+					if err != nil {
+						return
+					}
+					msgType, _ := readUint32(packet)
+					requestID, _ := readUint32(packet)
+					switch msgType {
+					case muxMsgHello:
+						continue
+					case muxCAliveCheck:
+						_ = client.send(muxSAlive, requestID, pid)
+					case muxCTerminate:
+						_ = client.send(muxSOk, requestID)
+					default:
+						reason := "unsupported"
+						header := make([]byte, 16)
+						binary.BigEndian.PutUint32(header, uint32(12+len(reason)))
+						binary.BigEndian.PutUint32(header[4:], muxSFailure)
+						binary.BigEndian.PutUint32(header[8:], requestID)
+						binary.BigEndian.PutUint32(header[12:], uint32(len(reason)))
+						_, _ = conn.Write(append(header, reason...))
+					}
+				}
+			}(conn)
+		}
+	}()
+	return listener
+}
+
+func TestControlSocket_MasterPID(t *testing.T) {
+	Convey("Testing ControlSocket.MasterPID()", t, func() {
+		dir, err := ioutil.TempDir("", "assh-cm")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "bart-22-root.sock")
+		socket := ControlSocket{path: path, controlPath: filepath.Join(dir, "*")}
+
+		Convey("stale socket", func() {
+			_, err := socket.MasterPID()
+			So(err, ShouldNotBeNil)
+
+			status := socket.Status()
+			So(status.Alive, ShouldBeFalse)
+			So(status.Stale, ShouldBeTrue)
+			So(status.Error, ShouldNotBeEmpty)
+			So(status.RelativePath, ShouldEqual, "bart-22-root.sock")
+		})
+
+		Convey("alive master", func() {
+			listener := fakeMaster(t, path, 4242)
+			defer listener.Close()
+
+			pid, err := socket.MasterPID()
+			So(err, ShouldBeNil)
+			So(pid, ShouldEqual, 4242)
+
+			status := socket.Status()
+			So(status.Alive, ShouldBeTrue)
+			So(status.Stale, ShouldBeFalse)
+			So(status.MasterPID, ShouldEqual, 4242)
+			So(status.Error, ShouldBeEmpty)
+
+			So(socket.Terminate(), ShouldBeNil)
+
+			client, err := dialMux(path)
+			So(err, ShouldBeNil)
+			defer client.Close()
+			msgType, reply, err := client.request(0x10000099)
+			So(err, ShouldBeNil)
+			So(replyError(msgType, reply).Error(), ShouldEqual, "mux failure: unsupported")
+		})
+	})
+}
@@ -0,0 +1,187 @@
+package controlsockets
+
+import (
+	"crypto/sha1" // #nosec, used by OpenSSH to compute %C, not for security
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/user"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"moul.io/assh/v2/pkg/utils"
+)
+
+// OpenSSH ControlPath tokens, see TOKENS in ssh_config(5)
+//
+//   %%    A literal `%'.
+//   %C    Hash of %l%h%p%r%j (%l%h%p%r before OpenSSH 9.1).
+//   %d    Local user's home directory.
+//   %h    The remote hostname.
+//   %i    The local user ID.
+//   %j    The contents of the ProxyJump option, or the empty string.
+//   %k    The host key alias if specified, otherwise the original remote hostname.
+//   %L    The local hostname.
+//   %l    The local hostname, including the domain name.
+//   %n    The original remote hostname, as given on the command line.
+//   %p    The remote port.
+//   %r    The remote username.
+//   %T    The local tun/tap network interface assigned.
+//   %u    The local username.
+
+// LocalTokens are the ControlPath tokens depending on the local machine
+type LocalTokens struct {
+	HomeDir       string // %d
+	UID           string // %i
+	ShortHostname string // %L
+	Hostname      string // %l
+	Username      string // %u
+}
+
+// CurrentLocalTokens returns the LocalTokens of the current user and machine
+func CurrentLocalTokens() LocalTokens {
+	tokens := LocalTokens{
+		HomeDir:       utils.GetHomeDir(),
+		UID:           strconv.Itoa(os.Geteuid()),
+		ShortHostname: "hostname",
+		Hostname:      "hostname",
+		Username:      "username",
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		tokens.Hostname = hostname
+		tokens.ShortHostname = strings.SplitN(hostname, ".", 2)[0]
+	}
+	if userdata, err := user.Current(); err == nil {
+		tokens.Username = userdata.Username
+	}
+	return tokens
+}
+
+// RemoteTokens are the ControlPath tokens depending on the destination
+type RemoteTokens struct {
+	Host         string `json:"host,omitempty"`           // %h
+	OriginalHost string `json:"original_host,omitempty"`  // %n
+	HostKeyAlias string `json:"host_key_alias,omitempty"` // %k
+	Port         string `json:"port,omitempty"`           // %p
+	User         string `json:"user,omitempty"`           // %r
+	ProxyJump    string `json:"proxy_jump,omitempty"`     // %j
+	Hash         string `json:"hash,omitempty"`           // %C
+}
+
+// ConnectionHash returns the %C token, without ProxyJump it matches the hash of OpenSSH < 9.1
+func ConnectionHash(local LocalTokens, remote RemoteTokens) string {
+	sum := sha1.Sum([]byte(local.Hostname + remote.Host + remote.Port + remote.User + remote.ProxyJump)) // #nosec
+	return hex.EncodeToString(sum[:])
+}
+
+// ControlPathPattern is a ControlPath compiled to find the sockets on disk and to parse their paths
+type ControlPathPattern struct {
+	glob     string
+	regex    *regexp.Regexp
+	captures []byte
+}
+
+// CompileControlPath converts the local tokens of a ControlPath to their values, and the remote
+// tokens to wildcards in a glob pattern and to capture groups in a regular expression
+func CompileControlPath(controlPath string, local LocalTokens) (*ControlPathPattern, error) {
+	expanded, err := utils.ExpandUser(controlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	pattern := &ControlPathPattern{}
+	var glob, regex strings.Builder
+	regex.WriteString("^")
+	literal := func(value string) {
+		glob.WriteString(value)
+		regex.WriteString(regexp.QuoteMeta(strings.ReplaceAll(value, `\ `, " ")))
+	}
+	capture := func(token byte, globPattern, regexPattern string) {
+		glob.WriteString(globPattern)
+		regex.WriteString("(" + regexPattern + ")")
+		pattern.captures = append(pattern.captures, token)
+	}
+
+	for i := 0; i < len(expanded); i++ {
+		if expanded[i] != '%' || i+1 == len(expanded) {
+			literal(expanded[i : i+1])
+			continue
+		}
+		i++
+		switch token := expanded[i]; token {
+		case '%':
+			literal("%")
+		case 'd':
+			literal(local.HomeDir)
+		case 'i':
+			literal(local.UID)
+		case 'L':
+			literal(local.ShortHostname)
+		case 'l':
+			literal(local.Hostname)
+		case 'u':
+			literal(local.Username)
+		case 'h', 'n', 'k':
+			// assh host names may contain slashes, i.e: "gateway/host"
+			capture(token, "**/*", ".+")
+		case 'p':
+			capture(token, "*", "[0-9]+")
+		case 'C':
+			capture(token, "*", "[0-9a-f]{40}")
+		case 'r', 'j', 'T':
+			capture(token, "*", ".*")
+		default:
+			return nil, fmt.Errorf("invalid ControlPath %q: unknown token %%%c", controlPath, token)
+		}
+	}
+	regex.WriteString("$")
+
+	pattern.glob = glob.String()
+	pattern.regex, err = regexp.Compile(regex.String())
+	if err != nil {
+		return nil, err
+	}
+	return pattern, nil
+}
+
+// Glob returns a pattern matching all the sockets of the ControlPath
+func (p *ControlPathPattern) Glob() string {
+	return p.glob
+}
+
+// Parse extracts the remote tokens from the path of a socket
+func (p *ControlPathPattern) Parse(path string) (RemoteTokens, bool) {
+	tokens := RemoteTokens{}
+	matches := p.regex.FindStringSubmatch(path)
+	if matches == nil {
+		return tokens, false
+	}
+	for idx, token := range p.captures {
+		value := matches[idx+1]
+		switch token {
+		case 'h':
+			tokens.Host = value
+		case 'n':
+			tokens.OriginalHost = value
+		case 'k':
+			tokens.HostKeyAlias = value
+		case 'p':
+			tokens.Port = value
+		case 'r':
+			tokens.User = value
+		case 'j':
+			tokens.ProxyJump = value
+		case 'C':
+			tokens.Hash = value
+		}
+	}
+	if tokens.Host == "" {
+		if tokens.OriginalHost != "" {
+			tokens.Host = tokens.OriginalHost
+		} else {
+			tokens.Host = tokens.HostKeyAlias
+		}
+	}
+	return tokens, true
+}
@@ -0,0 +1,77 @@
+package controlsockets
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var (
+	testLocalTokens = LocalTokens{
+		HomeDir:       "/home/moul",
+		UID:           "1000",
+		ShortHostname: "laptop",
+		Hostname:      "laptop.example.com",
+		Username:      "moul",
+	}
+	testRemoteTokens = RemoteTokens{
+		Host:         "bart/homer",
+		OriginalHost: "bart/homer",
+		Port:         "2222",
+		User:         "root",
+	}
+)
+
+func TestConnectionHash(t *testing.T) {
+	Convey("Testing ConnectionHash()", t, func() {
+		// sha1("laptop.example.com" + "bart/homer" + "2222" + "root")
+		So(ConnectionHash(testLocalTokens, testRemoteTokens), ShouldEqual, "b46b3438e82fadbdb149035d752ea255272597bf")
+	})
+}
+
+func TestCompileControlPath(t *testing.T) {
+	Convey("Testing CompileControlPath()", t, func() {
+		Convey("glob", func() {
+			for input, expected := range map[string]string{
+				"/tmp/%h-%p-%r.sock":    "/tmp/**/*-*-*.sock",
+				"/tmp/%L/%l/%u/%i/%C":   "/tmp/laptop/laptop.example.com/moul/1000/*",
+				"/tmp/%%h-%n%%":         "/tmp/%h-**/*%",
+				"/tmp/%d/%k-%j-%T.sock": "/tmp//home/moul/**/*-*-*.sock",
+			} {
+				pattern, err := CompileControlPath(input, testLocalTokens)
+				So(err, ShouldBeNil)
+				So(pattern.Glob(), ShouldEqual, expected)
+			}
+
+			_, err := CompileControlPath("/tmp/%z", testLocalTokens)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("parse", func() {
+			pattern, err := CompileControlPath("/tmp/cm/%u/%h-%p-%r.sock", testLocalTokens)
+			So(err, ShouldBeNil)
+
+			tokens, ok := pattern.Parse("/tmp/cm/moul/bart/homer-2222-root.sock")
+			So(ok, ShouldBeTrue)
+			So(tokens, ShouldResemble, RemoteTokens{Host: "bart/homer", Port: "2222", User: "root"})
+
+			tokens, ok = pattern.Parse("/tmp/cm/moul/my-host-22-r00t.sock")
+			So(ok, ShouldBeTrue)
+			So(tokens.Host, ShouldEqual, "my-host")
+			So(tokens.Port, ShouldEqual, "22")
+			So(tokens.User, ShouldEqual, "r00t")
+
+			_, ok = pattern.Parse("/tmp/cm/toto/bart-22-root.sock")
+			So(ok, ShouldBeFalse)
+			_, ok = pattern.Parse("/tmp/cm/moul/bart-ssh-root.sock")
+			So(ok, ShouldBeFalse)
+
+			pattern, err = CompileControlPath("/tmp/cm/%n.%C", testLocalTokens)
+			So(err, ShouldBeNil)
+			hash := ConnectionHash(testLocalTokens, testRemoteTokens)
+			tokens, ok = pattern.Parse("/tmp/cm/bart/homer." + hash)
+			So(ok, ShouldBeTrue)
+			So(tokens, ShouldResemble, RemoteTokens{Host: "bart/homer", OriginalHost: "bart/homer", Hash: hash})
+		})
+	})
+}
@@ -0,0 +1 @@
+package history // import "moul.io/assh/v2/pkg/history"
@@ -0,0 +1,193 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+	"moul.io/assh/v2/pkg/utils"
+)
+
+// Entry is a connection attempt of `assh connect`
+type Entry struct {
+	// Host is the name of the host in the configuration, HostName and Port where assh connected
+	Host     string `json:"host"`
+	HostName string `json:"hostname,omitempty"`
+	Port     string `json:"port,omitempty"`
+	// Gateway is "direct", the name of the gateway used to reach the host, or empty without gateways
+	Gateway string `json:"gateway,omitempty"`
+	// GatewayPath lists the hosts reached through this connection, i.e: [bastion, web]
+	GatewayPath   []string      `json:"gateway_path,omitempty"`
+	StartedAt     time.Time     `json:"started_at"`
+	ConnectedAt   *time.Time    `json:"connected_at,omitempty"`
+	EndedAt       time.Time     `json:"ended_at"`
+	Duration      time.Duration `json:"duration"`
+	SentBytes     uint64        `json:"sent_bytes,omitempty"`
+	ReceivedBytes uint64        `json:"received_bytes,omitempty"`
+	Success       bool          `json:"success"`
+	Error         string        `json:"error,omitempty"`
+	ErrorClass    string        `json:"error_class,omitempty"`
+}
+
+// Connected returns true if the TCP connection was established, even if it failed later
+func (e *Entry) Connected() bool {
+	return e.ConnectedAt != nil
+}
+
+const (
+	// DefaultMaxSize is the size above which the file of a store is rotated
+	DefaultMaxSize = 10 * 1000 * 1000
+	// DefaultMaxBackups is the amount of rotated files kept, the older entries are dropped
+	DefaultMaxBackups = 1
+)
+
+// Store is an append-only file of entries, one JSON object per line
+//
+// Each entry is appended with a single write on a file opened with O_APPEND, so the concurrent
+// `assh connect` processes do not interleave their lines; the file is renamed to file.1, file.2...
+// when it would exceed maxSize, like the log files
+type Store struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+}
+
+// Open returns the store of a file, the file is created on the first Append
+func Open(filename string) (*Store, error) {
+	expanded, err := utils.ExpandUser(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{path: expanded, maxSize: DefaultMaxSize, maxBackups: DefaultMaxBackups}, nil
+}
+
+// Path returns the path of the file of the store
+func (s *Store) Path() string { return s.path }
+
+// Append writes an entry at the end of the store
+func (s *Store) Append(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if info, err := file.Stat(); err == nil && info.Size() > 0 && info.Size()+int64(len(line)) > s.maxSize {
+		_ = file.Close()
+		if err := s.rotate(info); err != nil {
+			return err
+		}
+		if file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600); err != nil {
+			return err
+		}
+	}
+	if _, err := file.Write(line); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// rotate renames the file of the store to file.1, unless another process rotated it already
+func (s *Store) rotate(opened os.FileInfo) error {
+	if info, err := os.Stat(s.path); err != nil || !os.SameFile(info, opened) {
+		return nil
+	}
+	if s.maxBackups < 1 {
+		return os.Truncate(s.path, 0)
+	}
+	for idx := s.maxBackups - 1; idx > 0; idx-- {
+		_ = os.Rename(s.backupPath(idx), s.backupPath(idx+1))
+	}
+	if err := os.Rename(s.path, s.backupPath(1)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *Store) backupPath(idx int) string {
+	return fmt.Sprintf("%s.%d", s.path, idx)
+}
+
+// Filter selects entries, the zero value selects all of them
+type Filter struct {
+	// Host is a shell pattern matched against the host, i.e: "*.prod"
+	Host    string
+	Gateway string
+	Since   time.Time
+	Until   time.Time
+	Failed  bool
+}
+
+// Match returns true if the filter selects the entry
+func (f Filter) Match(entry Entry) bool {
+	if f.Host != "" {
+		if ok, err := path.Match(f.Host, entry.Host); err != nil || !ok {
+			return false
+		}
+	}
+	switch {
+	case f.Gateway != "" && entry.Gateway != f.Gateway,
+		!f.Since.IsZero() && entry.StartedAt.Before(f.Since),
+		!f.Until.IsZero() && !entry.StartedAt.Before(f.Until),
+		f.Failed && entry.Success:
+		return false
+	}
+	return true
+}
+
+// Entries returns the entries selected by the filter, oldest first, including the rotated files; a
+// missing file is an empty store and the lines that cannot be parsed (i.e: truncated by a crash) are skipped
+func (s *Store) Entries(filter Filter) ([]Entry, error) {
+	entries := []Entry{}
+	for idx := s.maxBackups; idx > 0; idx-- {
+		if err := s.readFile(s.backupPath(idx), filter, &entries); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.readFile(s.path, filter, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (s *Store) readFile(path string, filter Filter, entries *[]Entry) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			logger().Warn("Skipping invalid history entry", zap.String("file", path), zap.Int("line", lineNumber), zap.Error(err))
+			continue
+		}
+		if filter.Match(entry) {
+			*entries = append(*entries, entry)
+		}
+	}
+	return scanner.Err()
+}
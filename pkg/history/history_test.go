@@ -0,0 +1,139 @@
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStore(t *testing.T) {
+	Convey("Testing Store", t, func() {
+		dir, err := ioutil.TempDir("", "assh-history")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		store, err := Open(filepath.Join(dir, "sub", "assh_history"))
+		So(err, ShouldBeNil)
+		entries, err := store.Entries(Filter{})
+		So(err, ShouldBeNil)
+		So(entries, ShouldBeEmpty)
+
+		start := time.Date(2020, 1, 6, 10, 0, 0, 0, time.UTC)
+		connectedAt := start.Add(time.Second)
+		for _, entry := range []Entry{
+			{Host: "web.prod", Gateway: "bastion", StartedAt: start, ConnectedAt: &connectedAt, Success: true, SentBytes: 10},
+			{Host: "db.prod", Gateway: "bastion", StartedAt: start.Add(time.Hour), Error: "exit status 255"},
+			{Host: "db.prod", Gateway: "direct", StartedAt: start.Add(2 * time.Hour), Success: true},
+			{Host: "laptop", StartedAt: start.AddDate(0, 0, 7), Success: true, ReceivedBytes: 5},
+		} {
+			So(store.Append(entry), ShouldBeNil)
+		}
+		// a line truncated by a crash
+		file, err := os.OpenFile(store.Path(), os.O_WRONLY|os.O_APPEND, 0600)
+		So(err, ShouldBeNil)
+		_, err = file.WriteString(`{"host":"trunc`)
+		So(err, ShouldBeNil)
+		So(file.Close(), ShouldBeNil)
+
+		entries, err = store.Entries(Filter{})
+		So(err, ShouldBeNil)
+		So(len(entries), ShouldEqual, 4)
+		So(entries[0].Connected(), ShouldBeTrue)
+		So(entries[0].ConnectedAt.Equal(connectedAt), ShouldBeTrue)
+		So(entries[1].Connected(), ShouldBeFalse)
+
+		for filter, expected := range map[*Filter]int{
+			{Host: "*.prod"}:                    3,
+			{Host: "db.prod", Failed: true}:     1,
+			{Gateway: "bastion"}:                2,
+			{Since: start.Add(time.Hour)}:       3,
+			{Until: start.Add(time.Hour)}:       1,
+			{Host: "["}:                         0,
+			{Since: start, Until: start.Add(1)}: 1,
+		} {
+			entries, err = store.Entries(*filter)
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, expected)
+		}
+	})
+}
+
+func TestStoreRotation(t *testing.T) {
+	Convey("Testing Store rotation", t, func() {
+		dir, err := ioutil.TempDir("", "assh-history")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		store, err := Open(filepath.Join(dir, "assh_history"))
+		So(err, ShouldBeNil)
+		store.maxSize = 200
+		start := time.Date(2020, 1, 6, 10, 0, 0, 0, time.UTC)
+		for idx := 0; idx < 10; idx++ {
+			So(store.Append(Entry{Host: "web.prod", StartedAt: start.Add(time.Duration(idx) * time.Hour)}), ShouldBeNil)
+		}
+
+		info, err := os.Stat(store.Path())
+		So(err, ShouldBeNil)
+		So(info.Size(), ShouldBeLessThanOrEqualTo, 200)
+		_, err = os.Stat(store.Path() + ".1")
+		So(err, ShouldBeNil)
+		// only DefaultMaxBackups files are kept
+		_, err = os.Stat(store.Path() + ".2")
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		// the rotated entries are read first, the oldest ones are dropped
+		entries, err := store.Entries(Filter{})
+		So(err, ShouldBeNil)
+		So(len(entries), ShouldBeBetween, 1, 10)
+		So(entries[len(entries)-1].StartedAt.Equal(start.Add(9*time.Hour)), ShouldBeTrue)
+		for idx := 1; idx < len(entries); idx++ {
+			So(entries[idx].StartedAt.After(entries[idx-1].StartedAt), ShouldBeTrue)
+		}
+	})
+}
+
+func TestAggregateBy(t *testing.T) {
+	Convey("Testing AggregateBy()", t, func() {
+		start := time.Date(2020, 1, 6, 10, 0, 0, 0, time.UTC)
+		entries := []Entry{
+			{Host: "web", Gateway: "bastion", StartedAt: start, Success: true, SentBytes: 10, Duration: time.Minute},
+			{Host: "db", Gateway: "bastion", StartedAt: start.Add(time.Hour)},
+			{Host: "db", Gateway: "direct", StartedAt: start.Add(2 * time.Hour), Success: true},
+			{Host: "db", Gateway: "bastion", StartedAt: start.Add(3 * time.Hour)},
+			{Host: "laptop", StartedAt: start.AddDate(0, 0, 7), Success: true, ReceivedBytes: 5},
+		}
+
+		aggregates, err := AggregateBy(entries, "gateway")
+		So(err, ShouldBeNil)
+		So(len(aggregates), ShouldEqual, 2)
+		So(aggregates[0].Key, ShouldEqual, "bastion")
+		So(aggregates[0].Attempts, ShouldEqual, 3)
+		So(aggregates[0].Failures, ShouldEqual, 2)
+		So(aggregates[0].FailureRate, ShouldAlmostEqual, 66.666, 0.01)
+		So(aggregates[0].SentBytes, ShouldEqual, 10)
+		So(aggregates[0].Duration, ShouldEqual, time.Minute)
+		So(aggregates[0].LastSeen, ShouldEqual, start.Add(3*time.Hour))
+		So(aggregates[1].Key, ShouldEqual, "direct")
+		So(aggregates[1].FailureRate, ShouldEqual, 0)
+
+		aggregates, err = AggregateBy(entries, "host")
+		So(err, ShouldBeNil)
+		So(len(aggregates), ShouldEqual, 3)
+		So(aggregates[0].Key, ShouldEqual, "db")
+		So(aggregates[0].Attempts, ShouldEqual, 3)
+
+		aggregates, err = AggregateBy(entries, "week")
+		So(err, ShouldBeNil)
+		So(len(aggregates), ShouldEqual, 2)
+		So(aggregates[0].Key, ShouldEqual, "2020-W02")
+		So(aggregates[0].Attempts, ShouldEqual, 4)
+		So(aggregates[1].Key, ShouldEqual, "2020-W03")
+
+		_, err = AggregateBy(entries, "month")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,74 @@
+package history
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Aggregate are the statistics of a group of entries
+type Aggregate struct {
+	Key      string `json:"key"`
+	Attempts int    `json:"attempts"`
+	Failures int    `json:"failures"`
+	// FailureRate is the percentage of failed attempts
+	FailureRate   float64       `json:"failure_rate"`
+	SentBytes     uint64        `json:"sent_bytes"`
+	ReceivedBytes uint64        `json:"received_bytes"`
+	Duration      time.Duration `json:"duration"`
+	LastSeen      time.Time     `json:"last_seen"`
+}
+
+// groupKeys returns the key of the groups of an entry, ok is false if the entry is not in any group
+var groupKeys = map[string]func(Entry) (string, bool){
+	"host": func(entry Entry) (string, bool) {
+		return entry.Host, true
+	},
+	"week": func(entry Entry) (string, bool) {
+		year, week := entry.StartedAt.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week), true
+	},
+	// the connections without gateways are not a gateway failure
+	"gateway": func(entry Entry) (string, bool) {
+		return entry.Gateway, entry.Gateway != ""
+	},
+}
+
+// AggregateBy groups the entries by "host", "week" or "gateway", the groups are sorted by key
+func AggregateBy(entries []Entry, by string) ([]Aggregate, error) {
+	groupKey, found := groupKeys[by]
+	if !found {
+		return nil, fmt.Errorf("invalid aggregation %q, expected 'host', 'week' or 'gateway'", by)
+	}
+
+	groups := map[string]*Aggregate{}
+	for _, entry := range entries {
+		key, ok := groupKey(entry)
+		if !ok {
+			continue
+		}
+		group, found := groups[key]
+		if !found {
+			group = &Aggregate{Key: key}
+			groups[key] = group
+		}
+		group.Attempts++
+		if !entry.Success {
+			group.Failures++
+		}
+		group.SentBytes += entry.SentBytes
+		group.ReceivedBytes += entry.ReceivedBytes
+		group.Duration += entry.Duration
+		if entry.StartedAt.After(group.LastSeen) {
+			group.LastSeen = entry.StartedAt
+		}
+	}
+
+	aggregates := make([]Aggregate, 0, len(groups))
+	for _, group := range groups {
+		group.FailureRate = float64(group.Failures) / float64(group.Attempts) * 100
+		aggregates = append(aggregates, *group)
+	}
+	sort.Slice(aggregates, func(i, j int) bool { return aggregates[i].Key < aggregates[j].Key })
+	return aggregates, nil
+}
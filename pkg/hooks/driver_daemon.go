@@ -4,33 +4,31 @@ import (
 	"bytes"
 	"os"
 	"os/exec"
+	"text/template"
 
 	"go.uber.org/zap"
-	"moul.io/assh/v2/pkg/templates"
 )
 
 // DaemonDriver is a driver that daemons some texts to the terminal
 type DaemonDriver struct {
 	line string
+	tmpl *template.Template
 	cmd  *exec.Cmd
 }
 
 // NewDaemonDriver returns a DaemonDriver instance
 func NewDaemonDriver(line string) (DaemonDriver, error) {
+	tmpl, err := newTemplate(line)
 	return DaemonDriver{
 		line: line,
-	}, nil
+		tmpl: tmpl,
+	}, err
 }
 
 // Run daemons a line to the terminal
 func (d DaemonDriver) Run(args RunArgs) error {
 	var buff bytes.Buffer
-	tmpl, err := templates.New(d.line + "\n")
-	if err != nil {
-		return err
-	}
-
-	if err := tmpl.Execute(&buff, args); err != nil {
+	if err := d.tmpl.Execute(&buff, args); err != nil {
 		return err
 	}
 
@@ -6,31 +6,28 @@ import (
 	"os"
 	"os/exec"
 	"strings"
-
-	"moul.io/assh/v2/pkg/templates"
+	"text/template"
 )
 
 // ExecDriver is a driver that execs some texts to the terminal
 type ExecDriver struct {
 	line string
+	tmpl *template.Template
 }
 
 // NewExecDriver returns a ExecDriver instance
 func NewExecDriver(line string) (ExecDriver, error) {
+	tmpl, err := newTemplate(line)
 	return ExecDriver{
 		line: line,
-	}, nil
+		tmpl: tmpl,
+	}, err
 }
 
 // Run execs a line to the terminal
 func (d ExecDriver) Run(args RunArgs) error {
 	var buff bytes.Buffer
-	tmpl, err := templates.New(d.line + "\n")
-	if err != nil {
-		return err
-	}
-
-	if err := tmpl.Execute(&buff, args); err != nil {
+	if err := d.tmpl.Execute(&buff, args); err != nil {
 		return err
 	}
 
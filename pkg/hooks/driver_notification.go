@@ -7,32 +7,30 @@ package hooks
 
 import (
 	"bytes"
+	"text/template"
 
 	"github.com/haklop/gnotifier"
-	"moul.io/assh/v2/pkg/templates"
 )
 
 // NotificationDriver is a driver that notifications some texts to the terminal
 type NotificationDriver struct {
 	line string
+	tmpl *template.Template
 }
 
 // NewNotificationDriver returns a NotificationDriver instance
 func NewNotificationDriver(line string) (NotificationDriver, error) {
+	tmpl, err := newTemplate(line)
 	return NotificationDriver{
 		line: line,
-	}, nil
+		tmpl: tmpl,
+	}, err
 }
 
 // Run notifications a line to the terminal
 func (d NotificationDriver) Run(args RunArgs) error {
 	var buff bytes.Buffer
-	tmpl, err := templates.New(d.line + "\n")
-	if err != nil {
-		return err
-	}
-
-	if err := tmpl.Execute(&buff, args); err != nil {
+	if err := d.tmpl.Execute(&buff, args); err != nil {
 		return err
 	}
 
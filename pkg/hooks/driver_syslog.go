@@ -0,0 +1,215 @@
+package hooks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var (
+	// syslogSocketPaths are the local syslog sockets, tried in order
+	syslogSocketPaths = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+	// journaldSocketPath is the journald native protocol socket
+	journaldSocketPath = "/run/systemd/journal/socket"
+
+	syslogFacilities = map[string]int{
+		"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+		"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11, "local0": 16, "local1": 17, "local2": 18,
+		"local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+	}
+	syslogSeverities = map[string]int{
+		"emerg": 0, "alert": 1, "crit": 2, "err": 3, "error": 3, "warning": 4, "warn": 4,
+		"notice": 5, "info": 6, "debug": 7,
+	}
+
+	syslogOptions = map[string]bool{"facility": true, "severity": true, "tag": true, "socket": true}
+
+	journaldInvalidFieldChars = regexp.MustCompile(`[^A-Z0-9_]+`)
+)
+
+// SyslogDriver is a driver that sends some texts to the local syslog or journald
+type SyslogDriver struct {
+	line     string
+	tmpl     *template.Template
+	facility int
+	severity int
+	tag      string
+	socket   string
+}
+
+// NewSyslogDriver returns a SyslogDriver instance
+//
+// The line may start with `key=value` options: facility, severity, tag and socket, the first other word
+// starts the message, i.e: `syslog user=root logged in`.
+func NewSyslogDriver(line string) (SyslogDriver, error) {
+	driver := SyslogDriver{
+		facility: syslogFacilities["user"],
+		severity: syslogSeverities["info"],
+		tag:      "assh",
+	}
+
+	words := strings.Split(line, " ")
+	for len(words) > 0 {
+		parts := strings.SplitN(words[0], "=", 2)
+		if len(parts) != 2 || !syslogOptions[parts[0]] {
+			break
+		}
+		switch key, value := parts[0], parts[1]; key {
+		case "facility":
+			facility, found := syslogFacilities[strings.ToLower(value)]
+			if !found {
+				return driver, fmt.Errorf("invalid syslog facility %q", value)
+			}
+			driver.facility = facility
+		case "severity":
+			severity, found := syslogSeverities[strings.ToLower(value)]
+			if !found {
+				return driver, fmt.Errorf("invalid syslog severity %q", value)
+			}
+			driver.severity = severity
+		case "tag":
+			driver.tag = value
+		case "socket":
+			driver.socket = value
+		}
+		words = words[1:]
+	}
+	driver.line = strings.Join(words, " ")
+
+	// the trailing newline of the inline templates is trimmed by Run
+	var err error
+	driver.tmpl, err = newTemplate(driver.line)
+	return driver, err
+}
+
+// Run sends a line to journald if available, else to the local syslog socket
+func (d SyslogDriver) Run(args RunArgs) error {
+	var buff bytes.Buffer
+	if err := d.tmpl.Execute(&buff, args); err != nil {
+		return err
+	}
+	message := strings.TrimRight(buff.String(), "\n")
+
+	if d.socket == "" {
+		if _, err := os.Stat(journaldSocketPath); err == nil {
+			return d.sendJournald(message, args)
+		}
+	}
+	return d.sendSyslog(message)
+}
+
+func (d SyslogDriver) sendSyslog(message string) error {
+	paths := syslogSocketPaths
+	if d.socket != "" {
+		paths = []string{d.socket}
+	}
+
+	var lastErr error
+	for _, path := range paths {
+		conn, err := net.Dial("unixgram", path)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		// local syslog sockets expect the RFC 3164 format, without hostname
+		_, err = fmt.Fprintf(
+			conn, "<%d>%s %s[%d]: %s",
+			d.facility*8+d.severity, time.Now().Format(time.Stamp), d.tag, os.Getpid(), message,
+		)
+		if closeErr := conn.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+	return fmt.Errorf("no available syslog socket (tried %s): %v", strings.Join(paths, ", "), lastErr)
+}
+
+func (d SyslogDriver) sendJournald(message string, args RunArgs) error {
+	fields := map[string]string{
+		"MESSAGE":           message,
+		"PRIORITY":          fmt.Sprintf("%d", d.severity),
+		"SYSLOG_FACILITY":   fmt.Sprintf("%d", d.facility),
+		"SYSLOG_IDENTIFIER": d.tag,
+		"SYSLOG_PID":        fmt.Sprintf("%d", os.Getpid()),
+	}
+	for key, value := range journaldFields(args) {
+		fields[key] = value
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buff bytes.Buffer
+	for _, key := range keys {
+		value := fields[key]
+		if !strings.Contains(value, "\n") {
+			_, _ = fmt.Fprintf(&buff, "%s=%s\n", key, value)
+			continue
+		}
+		// multi-line values use the binary-safe serialization
+		buff.WriteString(key + "\n")
+		_ = binary.Write(&buff, binary.LittleEndian, uint64(len(value)))
+		buff.WriteString(value + "\n")
+	}
+
+	conn, err := net.Dial("unixgram", journaldSocketPath)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(buff.Bytes())
+	if closeErr := conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// journaldFields flattens the hook arguments into ASSH_* journald fields,
+// i.e: {"Host":{"User":"moul"}} -> ASSH_HOST_USER=moul
+func journaldFields(args RunArgs) map[string]string {
+	fields := map[string]string{}
+
+	raw, err := json.Marshal(args)
+	if err != nil {
+		return fields
+	}
+	var decoded interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&decoded); err != nil {
+		return fields
+	}
+
+	var walk func(prefix string, value interface{})
+	walk = func(prefix string, value interface{}) {
+		switch typed := value.(type) {
+		case map[string]interface{}:
+			for key, sub := range typed {
+				walk(prefix+"_"+key, sub)
+			}
+		case []interface{}:
+			for idx, sub := range typed {
+				walk(fmt.Sprintf("%s_%d", prefix, idx), sub)
+			}
+		case nil:
+		default:
+			key := journaldInvalidFieldChars.ReplaceAllString(strings.ToUpper(prefix), "_")
+			fields[key] = fmt.Sprintf("%v", typed)
+		}
+	}
+	walk("ASSH", decoded)
+	return fields
+}
+
+// Close is mandatory for the interface, here it does nothing
+func (d SyslogDriver) Close() error { return nil }
@@ -0,0 +1,104 @@
+package hooks
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func listenUnixgram(t *testing.T, path string) *net.UnixConn {
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unix datagram sockets are not supported: %v", err)
+	}
+	return conn
+}
+
+func readDatagram(conn *net.UnixConn) string {
+	buf := make([]byte, 65536)
+	n, _, err := conn.ReadFromUnix(buf)
+	So(err, ShouldBeNil)
+	return string(buf[:n])
+}
+
+func TestNewSyslogDriver(t *testing.T) {
+	Convey("Testing NewSyslogDriver()", t, func() {
+		driver, err := NewSyslogDriver("hello {{.User}}")
+		So(err, ShouldBeNil)
+		So(driver.line, ShouldEqual, "hello {{.User}}")
+		So(driver.facility, ShouldEqual, 1)
+		So(driver.severity, ShouldEqual, 6)
+		So(driver.tag, ShouldEqual, "assh")
+
+		driver, err = NewSyslogDriver("facility=local3 severity=warning tag=ssh-audit hello a=b")
+		So(err, ShouldBeNil)
+		So(driver.line, ShouldEqual, "hello a=b")
+		So(driver.facility, ShouldEqual, 19)
+		So(driver.severity, ShouldEqual, 4)
+		So(driver.tag, ShouldEqual, "ssh-audit")
+
+		_, err = NewSyslogDriver("facility=blah hello")
+		So(err, ShouldNotBeNil)
+		_, err = NewSyslogDriver("severity=blah hello")
+		So(err, ShouldNotBeNil)
+
+		// the other words are part of the message
+		driver, err = NewSyslogDriver("tag=audit user=root logged in")
+		So(err, ShouldBeNil)
+		So(driver.line, ShouldEqual, "user=root logged in")
+		So(driver.tag, ShouldEqual, "audit")
+		driver, err = NewSyslogDriver("color=blue hello")
+		So(err, ShouldBeNil)
+		So(driver.line, ShouldEqual, "color=blue hello")
+	})
+}
+
+func TestSyslogDriver_Run(t *testing.T) {
+	Convey("Testing SyslogDriver.Run()", t, func() {
+		dir, err := ioutil.TempDir("", "assh-syslog")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		args := testArgs{User: "moul", WrittenBytes: 1234567}
+
+		Convey("syslog socket", func() {
+			socketPath := filepath.Join(dir, "log")
+			listener := listenUnixgram(t, socketPath)
+			defer listener.Close()
+
+			driver, err := New("syslog facility=local0 severity=err socket=" + socketPath + " hello {{.User}}")
+			So(err, ShouldBeNil)
+			So(driver.Run(args), ShouldBeNil)
+
+			message := readDatagram(listener)
+			So(message, ShouldStartWith, "<131>")
+			So(message, ShouldEndWith, fmt.Sprintf("assh[%d]: hello moul", os.Getpid()))
+		})
+
+		Convey("journald socket", func() {
+			oldPath := journaldSocketPath
+			journaldSocketPath = filepath.Join(dir, "journal")
+			defer func() { journaldSocketPath = oldPath }()
+			listener := listenUnixgram(t, journaldSocketPath)
+			defer listener.Close()
+
+			driver, err := New("syslog tag=ssh-audit hello {{.User}}\nbye")
+			So(err, ShouldBeNil)
+			So(driver.Run(args), ShouldBeNil)
+
+			message := readDatagram(listener)
+			So(message, ShouldContainSubstring, "ASSH_USER=moul\n")
+			So(message, ShouldContainSubstring, "ASSH_WRITTENBYTES=1234567\n")
+			So(message, ShouldContainSubstring, "PRIORITY=6\n")
+			So(message, ShouldContainSubstring, "SYSLOG_FACILITY=1\n")
+			So(message, ShouldContainSubstring, "SYSLOG_IDENTIFIER=ssh-audit\n")
+			So(message, ShouldContainSubstring, "MESSAGE\n")
+			So(strings.Contains(message, "hello moul\nbye\n"), ShouldBeTrue)
+		})
+	})
+}
@@ -1,14 +1,26 @@
 package hooks
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"strings"
+	"text/template"
 
-	composeyaml "github.com/docker/libcompose/yaml"
+	"github.com/pkg/errors"
+	"moul.io/assh/v2/pkg/templates"
 )
 
+// Hook represents a hook expression, optionally guarded by a condition
+type Hook struct {
+	// Expr is the driver expression, i.e: "write New SSH connection to {{.Host.Prototype}}"
+	Expr string `yaml:"run" json:"run"`
+	// If is a Go template; the hook only runs when it renders "true"
+	If string `yaml:"if,omitempty" json:"if,omitempty"`
+}
+
 // Hooks represents a slice of Hook
-type Hooks composeyaml.Stringorslice
+type Hooks []Hook
 
 // HookDriver represents a hook driver
 type HookDriver interface {
@@ -22,12 +34,83 @@ type HookDrivers []HookDriver
 // RunArgs is a map of interface{}
 type RunArgs interface{}
 
+// UnmarshalYAML accepts either a plain expression or an `if`/`run` mapping
+func (h *Hook) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var expr string
+	if err := unmarshal(&expr); err == nil {
+		*h = Hook{Expr: expr}
+		return nil
+	}
+
+	type rawHook Hook
+	var raw rawHook
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	if raw.Expr == "" {
+		return fmt.Errorf("missing 'run' expression in conditional hook")
+	}
+	*h = Hook(raw)
+	return nil
+}
+
+// MarshalJSON returns a plain string for unconditional hooks
+func (h Hook) MarshalJSON() ([]byte, error) {
+	if h.If == "" {
+		return json.Marshal(h.Expr)
+	}
+	type rawHook Hook
+	return json.Marshal(rawHook(h))
+}
+
+// UnmarshalYAML accepts either a single hook or a slice of hooks
+func (h *Hooks) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var slice []Hook
+	if err := unmarshal(&slice); err == nil {
+		*h = slice
+		return nil
+	}
+
+	var single Hook
+	if err := unmarshal(&single); err != nil {
+		return err
+	}
+	*h = Hooks{single}
+	return nil
+}
+
+// Matches returns true if the hook has no condition or if its condition renders "true"
+func (h *Hook) Matches(args RunArgs) (bool, error) {
+	if h.If == "" {
+		return true, nil
+	}
+
+	tmpl, err := templates.New(h.If)
+	if err != nil {
+		return false, err
+	}
+
+	var buff bytes.Buffer
+	if err := tmpl.Execute(&buff, args); err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(buff.String()) == "true", nil
+}
+
 // InvokeAll calls all hooks
 func (h *Hooks) InvokeAll(args RunArgs) (HookDrivers, error) {
 	drivers := HookDrivers{}
 
-	for _, expr := range *h {
-		driver, err := New(expr)
+	for _, hook := range *h {
+		matches, err := hook.Matches(args)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to evaluate condition %q", hook.If)
+		}
+		if !matches {
+			continue
+		}
+
+		driver, err := New(hook.Expr)
 		if err != nil {
 			return nil, err
 		}
@@ -53,6 +136,30 @@ func (hd *HookDrivers) Close() []error {
 	return errs
 }
 
+// Validate returns an error if a hook expression or condition is invalid
+func (h *Hooks) Validate() error {
+	for _, hook := range *h {
+		if hook.If != "" {
+			if _, err := templates.New(hook.If); err != nil {
+				return errors.Wrapf(err, "invalid condition %q", hook.If)
+			}
+		}
+		if _, err := New(hook.Expr); err != nil {
+			return errors.Wrapf(err, "invalid hook %q", hook.Expr)
+		}
+	}
+	return nil
+}
+
+// newTemplate parses the template of a driver expression;
+// an expression starting with '@' references a template file, i.e: "@~/.ssh/assh-templates/disconnect.tmpl"
+func newTemplate(line string) (*template.Template, error) {
+	if strings.HasPrefix(line, "@") {
+		return templates.NewFromFile(strings.TrimSpace(line[1:]))
+	}
+	return templates.New(line + "\n")
+}
+
 // New returns an HookDriver instance
 func New(expr string) (HookDriver, error) {
 	driverName := strings.Split(expr, " ")[0]
@@ -70,6 +177,9 @@ func New(expr string) (HookDriver, error) {
 	case "daemon":
 		driver, err := NewDaemonDriver(param)
 		return driver, err
+	case "syslog":
+		driver, err := NewSyslogDriver(param)
+		return driver, err
 	default:
 		return nil, fmt.Errorf("no such driver %q", driverName)
 	}
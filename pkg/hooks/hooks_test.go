@@ -0,0 +1,77 @@
+package hooks
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testArgs struct {
+	User         string
+	WrittenBytes uint64
+}
+
+func TestHook_Matches(t *testing.T) {
+	Convey("Testing Hook.Matches()", t, func() {
+		args := testArgs{User: "root", WrittenBytes: 4242}
+
+		hook := Hook{Expr: "write hello"}
+		matches, err := hook.Matches(args)
+		So(err, ShouldBeNil)
+		So(matches, ShouldBeTrue)
+
+		hook.If = `{{eq .User "root"}}`
+		matches, err = hook.Matches(args)
+		So(err, ShouldBeNil)
+		So(matches, ShouldBeTrue)
+
+		hook.If = `{{gt .WrittenBytes 1000000}}`
+		matches, err = hook.Matches(args)
+		So(err, ShouldBeNil)
+		So(matches, ShouldBeFalse)
+
+		hook.If = `{{if gt .WrittenBytes 1000}} true {{end}}`
+		matches, err = hook.Matches(args)
+		So(err, ShouldBeNil)
+		So(matches, ShouldBeTrue)
+
+		hook.If = `{{.User}}`
+		matches, err = hook.Matches(args)
+		So(err, ShouldBeNil)
+		So(matches, ShouldBeFalse)
+
+		hook.If = `{{.User`
+		_, err = hook.Matches(args)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestHooks_InvokeAll(t *testing.T) {
+	Convey("Testing Hooks.InvokeAll()", t, func() {
+		args := testArgs{User: "bob"}
+
+		hooks := Hooks{
+			{Expr: "unknown-driver", If: `{{eq .User "root"}}`},
+			{Expr: "exec true", If: `{{eq .User "bob"}}`},
+		}
+		drivers, err := hooks.InvokeAll(args)
+		So(err, ShouldBeNil)
+		So(len(drivers), ShouldEqual, 1)
+
+		hooks = Hooks{{Expr: "unknown-driver"}}
+		_, err = hooks.InvokeAll(args)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestHook_MarshalJSON(t *testing.T) {
+	Convey("Testing Hook.MarshalJSON()", t, func() {
+		out, err := json.Marshal(Hooks{
+			{Expr: "write hello"},
+			{Expr: "write world", If: "{{true}}"},
+		})
+		So(err, ShouldBeNil)
+		So(string(out), ShouldEqual, `["write hello",{"run":"write world","if":"{{true}}"}]`)
+	})
+}
@@ -4,19 +4,25 @@ import "go.uber.org/zap/zapcore"
 
 // MustLogLevel returns a log level based on both user input and parent SSH process
 func MustLogLevel(debug, verbose bool) zapcore.Level {
-	parentLevel, err := LogLevelFromParentSSHProcess()
-	if err != nil {
-		parentLevel = zapcore.WarnLevel
-	}
-	asshLevel := zapcore.WarnLevel
+	return BaseLogLevel(zapcore.WarnLevel, debug, verbose)
+}
+
+// BaseLogLevel returns the configured base level, lowered by --debug, --verbose and the verbosity
+// of the parent SSH process; the flags can only make assh more verbose
+func BaseLogLevel(base zapcore.Level, debug, verbose bool) zapcore.Level {
+	level := base
 	switch {
 	case debug:
-		asshLevel = zapcore.DebugLevel
+		level = zapcore.DebugLevel
 	case verbose:
-		asshLevel = zapcore.InfoLevel
+		level = zapcore.InfoLevel
+	}
+	if level > base {
+		level = base
 	}
-	if parentLevel < asshLevel {
-		return parentLevel
+	// ssh without -v reports the warn level, only an explicit verbosity lowers the base
+	if parentLevel, err := LogLevelFromParentSSHProcess(); err == nil && parentLevel < zapcore.WarnLevel && parentLevel < level {
+		level = parentLevel
 	}
-	return asshLevel
+	return level
 }
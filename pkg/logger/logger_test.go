@@ -1,18 +1,137 @@
 package logger
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
+	"go.uber.org/zap/zapcore"
 )
 
-// FIXME: test MustLogLevel
+type mockProcess struct {
+	argv []string
+	exe  string
+}
+
+func (p mockProcess) CmdlineSlice() ([]string, error) { return p.argv, nil }
+
+func (p mockProcess) Exe() (string, error) {
+	if p.exe == "" {
+		return "", fmt.Errorf("permission denied")
+	}
+	return p.exe, nil
+}
+
+func TestMustLogLevel(t *testing.T) {
+	Convey("Testing MustLogLevel()", t, func() {
+		defer func(previous func() (Process, error)) { parentProcess = previous }(parentProcess)
+		parentProcess = func() (Process, error) {
+			return mockProcess{argv: []string{"ssh", "-v", "-F", "none", "example.com"}}, nil
+		}
+		So(MustLogLevel(false, false), ShouldEqual, zapcore.InfoLevel)
+		So(MustLogLevel(true, false), ShouldEqual, zapcore.DebugLevel)
+
+		parentProcess = func() (Process, error) { return nil, fmt.Errorf("no parent") }
+		So(MustLogLevel(false, false), ShouldEqual, zapcore.WarnLevel)
+		So(MustLogLevel(false, true), ShouldEqual, zapcore.InfoLevel)
+	})
+}
+
+func TestBaseLogLevel(t *testing.T) {
+	Convey("Testing BaseLogLevel()", t, func() {
+		defer func(previous func() (Process, error)) { parentProcess = previous }(parentProcess)
+		parentProcess = func() (Process, error) { return nil, fmt.Errorf("no parent") }
+		So(BaseLogLevel(zapcore.ErrorLevel, false, false), ShouldEqual, zapcore.ErrorLevel)
+		So(BaseLogLevel(zapcore.ErrorLevel, false, true), ShouldEqual, zapcore.InfoLevel)
+		So(BaseLogLevel(zapcore.ErrorLevel, true, false), ShouldEqual, zapcore.DebugLevel)
+		// the flags cannot raise the base
+		So(BaseLogLevel(zapcore.DebugLevel, false, true), ShouldEqual, zapcore.DebugLevel)
+
+		// ssh without -v does not lower the base, -q does not raise it
+		parentProcess = func() (Process, error) {
+			return mockProcess{argv: []string{"ssh", "-F", "none", "example.com"}}, nil
+		}
+		So(BaseLogLevel(zapcore.ErrorLevel, false, false), ShouldEqual, zapcore.ErrorLevel)
+		parentProcess = func() (Process, error) {
+			return mockProcess{argv: []string{"ssh", "-q", "-F", "none", "example.com"}}, nil
+		}
+		So(BaseLogLevel(zapcore.InfoLevel, false, false), ShouldEqual, zapcore.InfoLevel)
+		parentProcess = func() (Process, error) {
+			return mockProcess{argv: []string{"ssh", "-v", "-F", "none", "example.com"}}, nil
+		}
+		So(BaseLogLevel(zapcore.ErrorLevel, false, false), ShouldEqual, zapcore.InfoLevel)
+	})
+}
 
 func TestLogLevelFromParentSSHProcess(t *testing.T) {
 	Convey("Testing LogLevelFromParentSSHProcess()", t, func() {
-		_, err := LogLevelFromParentSSHProcess()
-		So(err, ShouldBeNil)
-		// FIXME: mock process
-		// So(level, ShouldEqual, zapcore.InfoLevel)
+		// the parent of the tests is not ssh
+		level, _ := LogLevelFromParentSSHProcess()
+		So(level, ShouldEqual, zapcore.WarnLevel)
+	})
+}
+
+func TestLogLevelFromSSHProcess(t *testing.T) {
+	Convey("Testing LogLevelFromSSHProcess()", t, func() {
+		for _, test := range []struct {
+			argv     []string
+			exe      string
+			expected zapcore.Level
+		}{
+			{[]string{"ssh", "-F", "none", "example.com"}, "", zapcore.WarnLevel},
+			{[]string{"ssh", "-v", "-F", "none", "example.com"}, "", zapcore.InfoLevel},
+			{[]string{"/usr/bin/ssh", "-vvv", "-F", "none", "example.com"}, "", zapcore.DebugLevel},
+			{[]string{"ssh", "-F", "none", "example.com", "-v", "-v"}, "", zapcore.DebugLevel},
+			{[]string{"ssh", "-Av", "-F", "none", "example.com"}, "", zapcore.InfoLevel},
+			{[]string{"ssh", "-q", "-F", "none", "example.com"}, "", zapcore.ErrorLevel},
+			{[]string{"ssh", "-F", "none", "-o", "LogLevel=DEBUG2", "example.com"}, "", zapcore.DebugLevel},
+			{[]string{"ssh", "-F", "none", "-oLogLevel VERBOSE", "example.com"}, "", zapcore.InfoLevel},
+			// the command and the host are not options
+			{[]string{"ssh", "-F", "none", "my-vhost", "ls", "-v"}, "", zapcore.WarnLevel},
+			{[]string{"ssh", "-F", "none", "--verbose-foo.example.com"}, "", zapcore.WarnLevel},
+			// not ssh
+			{[]string{"bash", "-c", "ssh -vv example.com"}, "/bin/bash", zapcore.WarnLevel},
+			{[]string{"rsync", "-v", "example.com:"}, "", zapcore.WarnLevel},
+			// argv[0] was changed
+			{[]string{"sshd: user@pts/0", "-v", "-F", "none", "example.com"}, "/usr/sbin/sshd", zapcore.WarnLevel},
+			{[]string{"renamed", "-v", "-F", "none", "example.com"}, "/usr/bin/ssh", zapcore.InfoLevel},
+		} {
+			level, _ := LogLevelFromSSHProcess(mockProcess{argv: test.argv, exe: test.exe})
+			So(level, ShouldEqual, test.expected)
+		}
+
+		_, err := LogLevelFromSSHProcess(mockProcess{})
+		So(err, ShouldNotBeNil)
+		_, err = LogLevelFromSSHProcess(mockProcess{argv: []string{"ssh", "-o", "LogLevel=LOUD", "example.com"}})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Testing findSSHConfigLogLevel()", t, func() {
+		sshConfig := `
+# generated by assh
+Host *.lan !gw.lan
+  LogLevel DEBUG
+
+Host gw.lan
+  User admin
+
+Match exec "true"
+  LogLevel QUIET
+
+Host *
+  LogLevel=VERBOSE
+`
+		for host, expected := range map[string]string{"web.lan": "DEBUG", "gw.lan": "VERBOSE", "example.com": "VERBOSE"} {
+			value, found := findSSHConfigLogLevel(strings.NewReader(sshConfig), host)
+			So(found, ShouldBeTrue)
+			So(value, ShouldEqual, expected)
+		}
+
+		value, found := findSSHConfigLogLevel(strings.NewReader("LogLevel ERROR\nHost *\n  LogLevel DEBUG\n"), "example.com")
+		So(found, ShouldBeTrue)
+		So(value, ShouldEqual, "ERROR")
+		_, found = findSSHConfigLogLevel(strings.NewReader("Host web\n  LogLevel DEBUG\n"), "example.com")
+		So(found, ShouldBeFalse)
 	})
 }
@@ -0,0 +1,124 @@
+package logger
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Options configures the logger built by New
+type Options struct {
+	// Level is the minimal level of the packages without a level in Levels
+	Level zapcore.Level
+	// Levels overrides the level of the loggers by name, i.e: {"assh.pkg.commands": zapcore.DebugLevel}
+	Levels map[string]zapcore.Level
+	// Debug adds the caller, the stacktraces, the time and the logger name of the entries
+	Debug bool
+	// JSON encodes the entries in JSON instead of the console format
+	JSON bool
+	// File writes the entries to a rotated file instead of stderr
+	File       string
+	MaxSize    int64
+	MaxBackups int
+}
+
+// New builds a logger from options
+func New(options Options) (*zap.Logger, error) {
+	var encoderConfig zapcore.EncoderConfig
+	if options.JSON {
+		encoderConfig = zap.NewProductionEncoderConfig()
+		encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	} else {
+		encoderConfig = zap.NewDevelopmentEncoderConfig()
+		encoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		if options.File != "" {
+			encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+		}
+		// the files are read later, the time and the name of the package matter
+		if !options.Debug && options.File == "" {
+			encoderConfig.TimeKey = ""
+			encoderConfig.NameKey = ""
+		}
+	}
+	if !options.Debug {
+		encoderConfig.CallerKey = ""
+	}
+
+	var encoder zapcore.Encoder
+	if options.JSON {
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	} else {
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	}
+
+	output := zapcore.Lock(os.Stderr)
+	if options.File != "" {
+		file, err := newRotatingFile(options.File, options.MaxSize, options.MaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		output = zapcore.Lock(file)
+	}
+
+	core := newLevelsCore(encoder, output, options.Level, options.Levels)
+	zapOptions := []zap.Option{zap.ErrorOutput(zapcore.Lock(os.Stderr))}
+	if options.Debug {
+		zapOptions = append(zapOptions, zap.AddCaller(), zap.AddStacktrace(zapcore.WarnLevel), zap.Development())
+	}
+	return zap.New(core, zapOptions...), nil
+}
+
+type namedLevel struct {
+	name  string
+	level zapcore.Level
+}
+
+// levelsCore filters the entries with the level of the longest matching logger name
+type levelsCore struct {
+	zapcore.Core
+	fallback zapcore.Level
+	levels   []namedLevel // longest names first
+}
+
+func newLevelsCore(encoder zapcore.Encoder, output zapcore.WriteSyncer, fallback zapcore.Level, levels map[string]zapcore.Level) *levelsCore {
+	core := &levelsCore{fallback: fallback}
+	min := fallback
+	for name, level := range levels {
+		core.levels = append(core.levels, namedLevel{name: name, level: level})
+		if level < min {
+			min = level
+		}
+	}
+	sort.Slice(core.levels, func(i, j int) bool {
+		if len(core.levels[i].name) != len(core.levels[j].name) {
+			return len(core.levels[i].name) > len(core.levels[j].name)
+		}
+		return core.levels[i].name < core.levels[j].name
+	})
+	core.Core = zapcore.NewCore(encoder, output, min)
+	return core
+}
+
+// levelOf returns the level of a logger, "assh.pkg.commands" matches "assh.pkg.commands.proxy" too
+func (c *levelsCore) levelOf(name string) zapcore.Level {
+	for _, level := range c.levels {
+		if name == level.name || strings.HasPrefix(name, level.name+".") {
+			return level.level
+		}
+	}
+	return c.fallback
+}
+
+func (c *levelsCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelsCore{Core: c.Core.With(fields), fallback: c.fallback, levels: c.levels}
+}
+
+func (c *levelsCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if entry.Level >= c.levelOf(entry.LoggerName) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
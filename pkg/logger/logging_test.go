@@ -0,0 +1,70 @@
+package logger
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLevelsCore(t *testing.T) {
+	Convey("Testing levelsCore", t, func() {
+		var buf bytes.Buffer
+		encoder := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+		core := newLevelsCore(encoder, zapcore.AddSync(&buf), zapcore.WarnLevel, map[string]zapcore.Level{
+			"assh.pkg.commands":       zapcore.DebugLevel,
+			"assh.pkg.commands.quiet": zapcore.ErrorLevel,
+		})
+		So(core.levelOf("assh.pkg.commands"), ShouldEqual, zapcore.DebugLevel)
+		So(core.levelOf("assh.pkg.commands.proxy"), ShouldEqual, zapcore.DebugLevel)
+		So(core.levelOf("assh.pkg.commands.quiet"), ShouldEqual, zapcore.ErrorLevel)
+		So(core.levelOf("assh.pkg.commandsfoo"), ShouldEqual, zapcore.WarnLevel)
+		So(core.levelOf("assh.pkg.config"), ShouldEqual, zapcore.WarnLevel)
+
+		l := zap.New(core)
+		l.Named("assh.pkg.commands").Debug("shown")
+		l.Named("assh.pkg.commands").With(zap.String("key", "value")).Info("shown with fields")
+		l.Named("assh.pkg.commands.quiet").Warn("hidden")
+		l.Named("assh.pkg.config").Info("hidden")
+		l.Named("assh.pkg.config").Warn("shown")
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		So(len(lines), ShouldEqual, 3)
+		So(lines[1], ShouldContainSubstring, `"key":"value"`)
+		So(buf.String(), ShouldNotContainSubstring, "hidden")
+	})
+}
+
+func TestRotatingFile(t *testing.T) {
+	Convey("Testing rotatingFile", t, func() {
+		dir, err := ioutil.TempDir("", "assh-logs")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "sub", "assh.log")
+
+		file, err := newRotatingFile(path, 10, 2)
+		So(err, ShouldBeNil)
+		for _, line := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n", "dddddd\n"} {
+			_, err = file.Write([]byte(line))
+			So(err, ShouldBeNil)
+		}
+
+		for suffix, expected := range map[string]string{"": "dddddd\n", ".1": "cccccc\n", ".2": "bbbbbb\n"} {
+			content, err := ioutil.ReadFile(path + suffix)
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, expected)
+		}
+		_, err = os.Stat(path + ".3")
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		// the size of an existing file is taken into account
+		file, err = newRotatingFile(path, 10, 2)
+		So(err, ShouldBeNil)
+		So(file.size, ShouldEqual, 7)
+	})
+}
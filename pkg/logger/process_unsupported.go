@@ -2,9 +2,8 @@
 
 package logger
 
-import "go.uber.org/zap/zapcore"
+import "fmt"
 
-// LogLevelFromParentSSHProcess inspects parent `ssh` process for eventual passed `-v` flags.
-func LogLevelFromParentSSHProcess() (zapcore.Level, error) {
-	return zapcore.WarnLevel, nil
+func defaultParentProcess() (Process, error) {
+	return nil, fmt.Errorf("not supported on this platform")
 }
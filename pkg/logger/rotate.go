@@ -0,0 +1,91 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// rotatingFile is an append-only log file renamed to file.1, file.2... when it would exceed maxSize
+//
+// several assh processes may append to the same file, the size is read again before rotating
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	lock       sync.Mutex
+	file       *os.File
+	size       int64
+}
+
+func newRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.size+int64(len(p)) > f.maxSize && f.size > 0 {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	// another process may have rotated the file already
+	if info, err := os.Stat(f.path); err == nil && os.SameFile(info, statOrNil(f.file)) && info.Size() > 0 {
+		if f.maxBackups > 0 {
+			for idx := f.maxBackups - 1; idx > 0; idx-- {
+				_ = os.Rename(fmt.Sprintf("%s.%d", f.path, idx), fmt.Sprintf("%s.%d", f.path, idx+1))
+			}
+			if err := os.Rename(f.path, f.path+".1"); err != nil {
+				return err
+			}
+		} else if err := os.Truncate(f.path, 0); err != nil {
+			return err
+		}
+	}
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) Sync() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.file.Sync()
+}
+
+func statOrNil(file *os.File) os.FileInfo {
+	info, _ := file.Stat()
+	return info
+}
@@ -0,0 +1,162 @@
+package logger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+	"moul.io/assh/v2/pkg/sshargs"
+	"moul.io/assh/v2/pkg/utils"
+)
+
+// Process is the part of a process inspected to find the log level of ssh, *process.Process of gopsutil
+// implements it
+type Process interface {
+	CmdlineSlice() ([]string, error)
+	Exe() (string, error)
+}
+
+// parentProcess returns the process that started assh, replaced in the tests
+var parentProcess = defaultParentProcess
+
+// LogLevelFromParentSSHProcess inspects parent `ssh` process for eventual passed `-v` flags.
+func LogLevelFromParentSSHProcess() (zapcore.Level, error) {
+	parent, err := parentProcess()
+	if err != nil {
+		return zapcore.WarnLevel, err
+	}
+	return LogLevelFromSSHProcess(parent)
+}
+
+// LogLevelFromSSHProcess returns the log level matching the verbosity of a ssh process: `-v` is info, `-vv`
+// and more are debug, `-q` is error; without `-v` nor `-q`, the LogLevel of the command line or of the ssh
+// configuration is used. Processes that are not ssh are warn.
+func LogLevelFromSSHProcess(proc Process) (zapcore.Level, error) {
+	argv, err := proc.CmdlineSlice()
+	if err != nil {
+		return zapcore.WarnLevel, err
+	}
+	if len(argv) == 0 {
+		return zapcore.WarnLevel, fmt.Errorf("empty command line")
+	}
+	if !isSSHExecutable(argv[0]) {
+		// argv[0] may be changed by the process, the executable cannot
+		if exe, err := proc.Exe(); err != nil || !isSSHExecutable(exe) {
+			return zapcore.WarnLevel, nil
+		}
+	}
+
+	args, err := sshargs.Parse(argv[1:])
+	if err != nil {
+		return zapcore.WarnLevel, err
+	}
+
+	switch verbosity := args.Verbosity(); {
+	case verbosity >= 2:
+		return zapcore.DebugLevel, nil
+	case verbosity == 1:
+		return zapcore.InfoLevel, nil
+	}
+	if value, found := args.Option("LogLevel"); found {
+		return levelFromSSHLogLevel(value)
+	}
+	if len(args.Flags["q"]) > 0 {
+		return zapcore.ErrorLevel, nil
+	}
+
+	configFile := "~/.ssh/config"
+	if files := args.Flags["F"]; len(files) > 0 {
+		configFile = files[len(files)-1]
+	}
+	if value, found := sshConfigLogLevel(configFile, args.Host); found {
+		return levelFromSSHLogLevel(value)
+	}
+	return zapcore.WarnLevel, nil
+}
+
+func isSSHExecutable(name string) bool {
+	base := strings.TrimSuffix(filepath.Base(name), ".exe")
+	return base == "ssh"
+}
+
+// levelFromSSHLogLevel converts a LogLevel of ssh_config(5), INFO is the default level of both ssh and assh
+func levelFromSSHLogLevel(value string) (zapcore.Level, error) {
+	switch strings.ToUpper(strings.TrimSpace(value)) {
+	case "QUIET", "FATAL", "ERROR":
+		return zapcore.ErrorLevel, nil
+	case "INFO":
+		return zapcore.WarnLevel, nil
+	case "VERBOSE":
+		return zapcore.InfoLevel, nil
+	case "DEBUG", "DEBUG1", "DEBUG2", "DEBUG3":
+		return zapcore.DebugLevel, nil
+	default:
+		return zapcore.WarnLevel, fmt.Errorf("invalid LogLevel %q", value)
+	}
+}
+
+// sshConfigLogLevel returns the first LogLevel of a ssh configuration file applying to host, like ssh the
+// first obtained value wins; Match blocks and Include directives are ignored
+func sshConfigLogLevel(configFile string, host string) (string, bool) {
+	if configFile == "none" {
+		return "", false
+	}
+	expanded, err := utils.ExpandUser(configFile)
+	if err != nil {
+		return "", false
+	}
+	file, err := os.Open(expanded)
+	if err != nil {
+		return "", false
+	}
+	defer file.Close()
+	return findSSHConfigLogLevel(file, host)
+}
+
+func findSSHConfigLogLevel(source io.Reader, host string) (string, bool) {
+	matching := true // the lines before the first Host apply to all the hosts
+	scanner := bufio.NewScanner(source)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(strings.Replace(line, "=", " ", 1))
+		if len(fields) < 2 {
+			continue
+		}
+		switch strings.ToLower(fields[0]) {
+		case "host":
+			matching = matchSSHHostPatterns(fields[1:], host)
+		case "match":
+			matching = false
+		case "loglevel":
+			if matching {
+				return fields[1], true
+			}
+		}
+	}
+	return "", false
+}
+
+// matchSSHHostPatterns matches a host against the patterns of a Host line, i.e: `*.lan !gw.lan`
+func matchSSHHostPatterns(patterns []string, host string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		ok, err := path.Match(strings.TrimPrefix(pattern, "!"), host)
+		if err != nil || !ok {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
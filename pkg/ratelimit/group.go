@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+var groupNameRegex = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// ValidateGroupName checks a rate limit group can be used as a file name
+func ValidateGroupName(name string) error {
+	if !groupNameRegex.MatchString(name) || name == "." || name == ".." {
+		return fmt.Errorf("invalid rate limit group %q: only letters, digits, '.', '_' and '-' are allowed", name)
+	}
+	return nil
+}
+
+// GroupDir returns the directory of the buckets shared by the assh processes of the user
+func GroupDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "assh", "ratelimit")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("assh-%d", os.Getuid()), "ratelimit")
+}
+
+// NewGroupLimiter returns a Limiter sharing its token bucket with the other assh processes of the machine
+// using the same group name
+func NewGroupLimiter(group string, schedule *Schedule, burst int) (*Limiter, error) {
+	if err := ValidateGroupName(group); err != nil {
+		return nil, err
+	}
+	dir := GroupDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	shared := &sharedBucket{path: filepath.Join(dir, group)}
+	if err := shared.check(); err != nil {
+		return nil, err
+	}
+	limiter := NewLimiter(schedule, burst)
+	limiter.shared = shared
+	return limiter, nil
+}
+
+// sharedBucket is a token bucket stored in a file, the processes take turns with an exclusive lock
+type sharedBucket struct {
+	path string
+}
+
+// sharedState is the content of the file of a sharedBucket
+type sharedState struct {
+	tokens float64
+	last   time.Time
+}
+
+// advance refills the bucket until now and takes n tokens, it returns the time to wait before using them
+func (s *sharedState) advance(now time.Time, n int, limit rate.Limit, burst int) time.Duration {
+	if s.last.IsZero() {
+		s.tokens = float64(burst)
+	} else if elapsed := now.Sub(s.last); elapsed > 0 {
+		s.tokens += elapsed.Seconds() * float64(limit)
+	}
+	if s.tokens > float64(burst) {
+		s.tokens = float64(burst)
+	}
+	if now.After(s.last) {
+		s.last = now
+	}
+	s.tokens -= float64(n)
+	if s.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-s.tokens / float64(limit) * float64(time.Second))
+}
+
+// sharedReservation gives its tokens back to the shared bucket when cancelled, like rate.Reservation, only the
+// tokens of the time not waited yet are given back
+type sharedReservation struct {
+	bucket     *sharedBucket
+	n          int
+	burst      int
+	limit      rate.Limit
+	reservedAt time.Time
+	delay      time.Duration
+}
+
+func (r *sharedReservation) Cancel() {
+	r.cancelAt(time.Now())
+}
+
+func (r *sharedReservation) cancelAt(now time.Time) {
+	remaining := r.delay - now.Sub(r.reservedAt)
+	if remaining <= 0 {
+		return
+	}
+	tokens := remaining.Seconds() * float64(r.limit)
+	if tokens > float64(r.n) {
+		tokens = float64(r.n)
+	}
+	if err := r.bucket.update(func(state *sharedState) {
+		state.tokens += tokens
+		if state.tokens > float64(r.burst) {
+			state.tokens = float64(r.burst)
+		}
+	}); err != nil {
+		logger().Warn("Failed to cancel a shared rate limit reservation", zap.Error(err))
+	}
+}
+
+// reserveN takes n tokens from the shared bucket
+func (b *sharedBucket) reserveN(now time.Time, n int, limit rate.Limit, burst int) (reservation, time.Duration, error) {
+	delay := time.Duration(0)
+	err := b.update(func(state *sharedState) {
+		delay = state.advance(now, n, limit, burst)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return &sharedReservation{bucket: b, n: n, burst: burst, limit: limit, reservedAt: now, delay: delay}, delay, nil
+}
+
+func parseSharedState(data []byte) sharedState {
+	state := sharedState{}
+	var nanos int64
+	// an empty or corrupted file is a full bucket
+	if _, err := fmt.Sscanf(string(data), "%g %d", &state.tokens, &nanos); err == nil {
+		state.last = time.Unix(0, nanos)
+	}
+	return state
+}
+
+func (s sharedState) String() string {
+	return fmt.Sprintf("%g %d\n", s.tokens, s.last.UnixNano())
+}
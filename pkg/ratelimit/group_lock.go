@@ -0,0 +1,41 @@
+// +build !windows
+
+package ratelimit
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+)
+
+// check makes sure the file of the bucket can be locked
+func (b *sharedBucket) check() error {
+	return b.update(func(*sharedState) {})
+}
+
+// update modifies the state of the bucket while holding an exclusive lock on its file
+func (b *sharedBucket) update(fn func(*sharedState)) error {
+	file, err := os.OpenFile(b.path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer func() { _ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN) }()
+
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	state := parseSharedState(data)
+	fn(&state)
+
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err = file.WriteAt([]byte(state.String()), 0)
+	return err
+}
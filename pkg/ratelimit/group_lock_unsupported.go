@@ -0,0 +1,15 @@
+// +build windows
+
+package ratelimit
+
+import "fmt"
+
+// check makes sure the file of the bucket can be locked
+func (b *sharedBucket) check() error {
+	return fmt.Errorf("shared rate limits are not supported on this platform")
+}
+
+// update modifies the state of the bucket while holding an exclusive lock on its file
+func (b *sharedBucket) update(func(*sharedState)) error {
+	return b.check()
+}
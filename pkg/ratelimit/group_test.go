@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGroupLimiter(t *testing.T) {
+	Convey("Testing NewGroupLimiter()", t, func() {
+		dir, err := ioutil.TempDir("", "assh-ratelimit")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		previous, wasSet := os.LookupEnv("XDG_RUNTIME_DIR")
+		So(os.Setenv("XDG_RUNTIME_DIR", dir), ShouldBeNil)
+		defer func() {
+			if wasSet {
+				_ = os.Setenv("XDG_RUNTIME_DIR", previous)
+			} else {
+				_ = os.Unsetenv("XDG_RUNTIME_DIR")
+			}
+		}()
+		So(GroupDir(), ShouldEqual, filepath.Join(dir, "assh", "ratelimit"))
+
+		_, err = NewGroupLimiter("../eu", nil, 0)
+		So(err, ShouldNotBeNil)
+
+		schedule, err := ParseSchedule("1K")
+		So(err, ShouldBeNil)
+		// two connections of the group, i.e: two assh processes
+		first, err := NewGroupLimiter("eu.both", schedule, 1000)
+		So(err, ShouldBeNil)
+		second, err := NewGroupLimiter("eu.both", schedule, 1000)
+		So(err, ShouldBeNil)
+		other, err := NewGroupLimiter("us.both", schedule, 1000)
+		So(err, ShouldBeNil)
+
+		now := time.Now()
+		_, delay := first.reserve(now, 1000)
+		So(delay, ShouldEqual, 0)
+		// the bucket is empty for the whole group
+		_, delay = second.reserve(now, 500)
+		So(delay, ShouldEqual, 500*time.Millisecond)
+		_, delay = other.reserve(now, 1000)
+		So(delay, ShouldEqual, 0)
+		// and refills over time
+		_, delay = second.reserve(now.Add(2*time.Second), 1000)
+		So(delay, ShouldEqual, 0)
+		_, delay = first.reserve(now.Add(2*time.Second), 500)
+		So(delay, ShouldEqual, 500*time.Millisecond)
+
+		// the tokens of an interrupted wait are given back
+		throttle := NewThrottle(first)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		So(throttle.WaitN(ctx, 1000), ShouldNotBeNil)
+		data, err := ioutil.ReadFile(filepath.Join(GroupDir(), "eu.both"))
+		So(err, ShouldBeNil)
+		So(parseSharedState(data).tokens, ShouldEqual, -500)
+
+		// only the tokens of the time not waited yet are given back
+		later := now.Add(2 * time.Second)
+		reservations, delay := second.reserve(later, 1000)
+		So(delay, ShouldEqual, 1500*time.Millisecond)
+		So(len(reservations), ShouldEqual, 1)
+		reservations[0].(*sharedReservation).cancelAt(later.Add(time.Second))
+		data, err = ioutil.ReadFile(filepath.Join(GroupDir(), "eu.both"))
+		So(err, ShouldBeNil)
+		So(parseSharedState(data).tokens, ShouldEqual, -1000)
+		reservations[0].(*sharedReservation).cancelAt(later.Add(2 * time.Second))
+		data, err = ioutil.ReadFile(filepath.Join(GroupDir(), "eu.both"))
+		So(err, ShouldBeNil)
+		So(parseSharedState(data).tokens, ShouldEqual, -1000)
+	})
+}
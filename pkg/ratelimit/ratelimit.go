@@ -3,73 +3,283 @@ package ratelimit
 // Package ratelimit based on http://hustcat.github.io/rate-limit-example-in-go/
 
 import (
-	"fmt"
+	"context"
 	"io"
+	"sync"
 	"time"
 
+	"go.uber.org/zap"
 	"golang.org/x/time/rate"
 )
 
+// Limiter is a token bucket following a Schedule, each token represents one byte
+type Limiter struct {
+	schedule *Schedule // nil for a fixed limit
+	limiter  *rate.Limiter
+	shared   *sharedBucket // nil for a bucket local to the process
+	lock     sync.Mutex
+}
+
+// reservation is a part of the tokens reserved by a Limiter
+type reservation interface {
+	Cancel()
+}
+
+// NewLimiter returns a Limiter following the schedule, a burst of 0 defaults to one second at the highest rate
+func NewLimiter(schedule *Schedule, burst int) *Limiter {
+	return &Limiter{
+		schedule: schedule,
+		limiter:  rate.NewLimiter(schedule.Limit(time.Now()), defaultBurst(schedule, burst)),
+	}
+}
+
+func defaultBurst(schedule *Schedule, burst int) int {
+	if burst > 0 {
+		return burst
+	}
+	if burst = int(schedule.maxFiniteLimit()); burst < 1 {
+		burst = 1
+	}
+	return burst
+}
+
+// SetSchedule replaces the schedule of the limiter, the current tokens are kept
+func (l *Limiter) SetSchedule(schedule *Schedule) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.schedule = schedule
+	l.limiter.SetLimit(schedule.Limit(time.Now()))
+}
+
+// SetBurst replaces the size of the bucket, 0 defaults to one second at the highest rate of the schedule
+func (l *Limiter) SetBurst(burst int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.schedule != nil {
+		burst = defaultBurst(l.schedule, burst)
+	}
+	l.limiter.SetBurst(burst)
+}
+
+// Burst returns the maximal amount of bytes allowed at once, 0 when unlimited
+func (l *Limiter) Burst() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.limiter.Limit() == rate.Inf {
+		return 0
+	}
+	return l.limiter.Burst()
+}
+
+// reserve reserves n tokens, in several parts if n exceeds the burst, and returns the time to wait
+// before using them
+func (l *Limiter) reserve(now time.Time, n int) ([]reservation, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// follow the schedule
+	if l.schedule != nil {
+		if limit := l.schedule.Limit(now); limit != l.limiter.Limit() {
+			logger().Debug("Following the rate limit schedule", zap.Float64("limit", float64(limit)))
+			l.limiter.SetLimitAt(now, limit)
+		}
+	}
+	if l.limiter.Limit() == rate.Inf {
+		return nil, 0
+	}
+
+	reservations := []reservation{}
+	delay := time.Duration(0)
+	burst := l.limiter.Burst()
+	for n > 0 {
+		chunk := n
+		if burst > 0 && chunk > burst {
+			chunk = burst
+		}
+		n -= chunk
+		if l.shared != nil {
+			rv, sharedDelay, err := l.shared.reserveN(now, chunk, l.limiter.Limit(), burst)
+			if err == nil {
+				reservations = append(reservations, rv)
+				delay = sharedDelay
+				continue
+			}
+			// the limit is still enforced for this process
+			logger().Warn("Failed to use the shared rate limit", zap.String("path", l.shared.path), zap.Error(err))
+		}
+		if rv := l.limiter.ReserveN(now, chunk); rv.OK() {
+			reservations = append(reservations, rv)
+			delay = rv.DelayFrom(now)
+		}
+	}
+	return reservations, delay
+}
+
+// ThrottleStats are the counters of a Throttle
+type ThrottleStats struct {
+	Bytes     uint64
+	Throttled time.Duration
+}
+
+// Throttle applies limiters to a stream, all of them must allow the bytes to pass
+type Throttle struct {
+	lock     sync.RWMutex
+	limiters []*Limiter
+	stats    ThrottleStats
+}
+
+// NewThrottle returns a Throttle applying the limiters, nil limiters are ignored
+func NewThrottle(limiters ...*Limiter) *Throttle {
+	throttle := &Throttle{}
+	throttle.SetLimiters(limiters...)
+	return throttle
+}
+
+// SetLimiters replaces the limiters at runtime, nil limiters are ignored
+func (t *Throttle) SetLimiters(limiters ...*Limiter) {
+	list := []*Limiter{}
+	for _, limiter := range limiters {
+		if limiter != nil {
+			list = append(list, limiter)
+		}
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.limiters = list
+}
+
+// Stats returns the amount of bytes throttled and the time spent waiting
+func (t *Throttle) Stats() ThrottleStats {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.stats
+}
+
+// chunkSize returns the largest amount of bytes allowed at once by all the limiters, 0 when unlimited
+func (t *Throttle) chunkSize() int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	size := 0
+	for _, limiter := range t.limiters {
+		if burst := limiter.Burst(); burst > 0 && (size == 0 || burst < size) {
+			size = burst
+		}
+	}
+	return size
+}
+
+// WaitN blocks until all the limiters allow n bytes, or until the context is done
+func (t *Throttle) WaitN(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	t.lock.RLock()
+	limiters := t.limiters
+	t.lock.RUnlock()
+
+	now := time.Now()
+	reservations := []reservation{}
+	max := time.Duration(0)
+	for _, limiter := range limiters {
+		rvs, delay := limiter.reserve(now, n)
+		reservations = append(reservations, rvs...)
+		if delay > max {
+			max = delay
+		}
+	}
+
+	if max > 0 {
+		timer := time.NewTimer(max)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			// give the tokens back to the other streams
+			for _, rv := range reservations {
+				rv.Cancel()
+			}
+			return ctx.Err()
+		}
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.stats.Bytes += uint64(n)
+	t.stats.Throttled += max
+	return nil
+}
+
 type reader struct {
-	r       io.Reader
-	limiter *rate.Limiter
+	ctx      context.Context
+	r        io.Reader
+	throttle *Throttle
 }
 
 // NewReader returns a reader that is rate limited by
 // the given token bucket. Each token in the bucket
 // represents one byte.
+//
+// Deprecated: use Throttle.Reader
 func NewReader(r io.Reader, l *rate.Limiter) io.Reader {
-	return &reader{
-		r:       r,
-		limiter: l,
-	}
+	return NewThrottle(&Limiter{limiter: l}).Reader(context.Background(), r)
+}
+
+// Reader returns a reader that is rate limited by the throttle, the reads are limited to the
+// size of the buckets and the waits are interrupted when the context is done
+func (t *Throttle) Reader(ctx context.Context, r io.Reader) io.Reader {
+	return &reader{ctx: ctx, r: r, throttle: t}
 }
 
 func (r *reader) Read(buf []byte) (int, error) {
+	if size := r.throttle.chunkSize(); size > 0 && len(buf) > size {
+		buf = buf[:size]
+	}
 	n, err := r.r.Read(buf)
 	if n <= 0 {
 		return n, err
 	}
-
-	now := time.Now()
-	rv := r.limiter.ReserveN(now, n)
-	if !rv.OK() {
-		return 0, fmt.Errorf("exceeds limiter's burst")
+	if waitErr := r.throttle.WaitN(r.ctx, n); waitErr != nil {
+		return n, waitErr
 	}
-	delay := rv.DelayFrom(now)
-	// fmt.Printf("Read %d bytes, delay %d\n", n, delay)
-	time.Sleep(delay)
 	return n, err
 }
 
 type writer struct {
-	w       io.Writer
-	limiter *rate.Limiter
+	ctx      context.Context
+	w        io.Writer
+	throttle *Throttle
 }
 
 // NewWriter returns a writer that is rate limited by
 // the given token bucket. Each token in the bucket
 // represents one byte.
+//
+// Deprecated: use Throttle.Writer
 func NewWriter(w io.Writer, l *rate.Limiter) io.Writer {
-	return &writer{
-		w:       w,
-		limiter: l,
-	}
+	return NewThrottle(&Limiter{limiter: l}).Writer(context.Background(), w)
 }
 
-func (w *writer) Write(buf []byte) (int, error) {
-	n, err := w.w.Write(buf)
-	if n <= 0 {
-		return n, err
-	}
+// Writer returns a writer that is rate limited by the throttle, the buffers are split to fit
+// the buckets and the waits are interrupted when the context is done
+func (t *Throttle) Writer(ctx context.Context, w io.Writer) io.Writer {
+	return &writer{ctx: ctx, w: w, throttle: t}
+}
 
-	now := time.Now()
-	rv := w.limiter.ReserveN(now, n)
-	if !rv.OK() {
-		return 0, fmt.Errorf("exceeds limiter's burst")
+func (w *writer) Write(buf []byte) (int, error) {
+	written := 0
+	for written < len(buf) {
+		chunk := buf[written:]
+		if size := w.throttle.chunkSize(); size > 0 && len(chunk) > size {
+			chunk = chunk[:size]
+		}
+		if err := w.throttle.WaitN(w.ctx, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
 	}
-	delay := rv.DelayFrom(now)
-	// fmt.Printf("Write %d bytes, delay %d\n", n, delay)
-	time.Sleep(delay)
-	return n, err
+	return written, nil
 }
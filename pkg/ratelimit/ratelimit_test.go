@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/time/rate"
+)
+
+type chunkWriter struct {
+	chunks []int
+}
+
+func (w *chunkWriter) Write(buf []byte) (int, error) {
+	w.chunks = append(w.chunks, len(buf))
+	return len(buf), nil
+}
+
+func TestLimiter(t *testing.T) {
+	Convey("Testing Limiter", t, func() {
+		schedule, err := ParseSchedule("1K 00:00-12:00 else 100K")
+		So(err, ShouldBeNil)
+
+		limiter := NewLimiter(schedule, 0)
+		So(limiter.Burst(), ShouldEqual, 100000)
+		limiter = NewLimiter(schedule, 2000)
+		So(limiter.Burst(), ShouldEqual, 2000)
+
+		// the limit follows the schedule
+		morning := time.Date(2020, 1, 1, 11, 0, 0, 0, time.Local)
+		_, delay := limiter.reserve(morning, 2000)
+		So(delay, ShouldEqual, 0)
+		So(limiter.limiter.Limit(), ShouldEqual, 1000)
+		// a buffer larger than the burst is reserved in several parts
+		reservations, delay := limiter.reserve(morning, 5000)
+		So(delay, ShouldEqual, 5*time.Second)
+		So(len(reservations), ShouldEqual, 3)
+		afternoon := morning.Add(2 * time.Hour)
+		limiter.reserve(afternoon, 1)
+		So(limiter.limiter.Limit(), ShouldEqual, 100000)
+
+		unlimited := NewLimiter(&Schedule{fallback: rate.Inf}, 0)
+		_, delay = unlimited.reserve(morning, 10*1024*1024)
+		So(delay, ShouldEqual, 0)
+		So(unlimited.Burst(), ShouldEqual, 0)
+
+		// the schedule and the burst can be replaced at runtime
+		fast, err := ParseSchedule("1M")
+		So(err, ShouldBeNil)
+		limiter.SetSchedule(fast)
+		So(limiter.limiter.Limit(), ShouldEqual, 1000000)
+		limiter.SetBurst(0)
+		So(limiter.Burst(), ShouldEqual, 1000000)
+	})
+
+	Convey("Testing Throttle", t, func() {
+		schedule, err := ParseSchedule("1M")
+		So(err, ShouldBeNil)
+		data := bytes.Repeat([]byte("x"), 64*1024)
+
+		Convey("reads and writes larger than the burst", func() {
+			throttle := NewThrottle(NewLimiter(schedule, 1024), nil)
+			output, err := ioutil.ReadAll(throttle.Reader(context.Background(), bytes.NewReader(data)))
+			So(err, ShouldBeNil)
+			So(output, ShouldResemble, data)
+
+			// the buffers are split to fit the smallest bucket
+			var chunks chunkWriter
+			throttle = NewThrottle(NewLimiter(schedule, 4096), nil, NewLimiter(schedule, 1024))
+			n, err := throttle.Writer(context.Background(), &chunks).Write(data[:2500])
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 2500)
+			So(chunks.chunks, ShouldResemble, []int{1024, 1024, 452})
+
+			stats := throttle.Stats()
+			So(stats.Bytes, ShouldEqual, 2500)
+		})
+
+		Convey("deprecated NewReader() and NewWriter()", func() {
+			output, err := ioutil.ReadAll(NewReader(bytes.NewReader(data), rate.NewLimiter(rate.Limit(1000000), 1024)))
+			So(err, ShouldBeNil)
+			So(output, ShouldResemble, data)
+
+			var buf bytes.Buffer
+			n, err := NewWriter(&buf, rate.NewLimiter(rate.Limit(1000000), 1024)).Write(data)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, len(data))
+			So(buf.Bytes(), ShouldResemble, data)
+		})
+
+		Convey("waits are interrupted by the context", func() {
+			slow, err := ParseSchedule("1K")
+			So(err, ShouldBeNil)
+			throttle := NewThrottle(NewLimiter(slow, 1024))
+			So(throttle.WaitN(context.Background(), 1024), ShouldBeNil)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			So(throttle.WaitN(ctx, 1024), ShouldResemble, context.DeadlineExceeded)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+			So(throttle.Stats().Bytes, ShouldEqual, 1024)
+
+			// the limiters can be swapped at runtime
+			throttle.SetLimiters(NewLimiter(schedule, 0))
+			So(throttle.WaitN(context.Background(), 1024), ShouldBeNil)
+			stats := throttle.Stats()
+			So(stats.Bytes, ShouldEqual, 2048)
+			So(stats.Throttled, ShouldBeLessThan, time.Second)
+		})
+	})
+}
@@ -0,0 +1,153 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"golang.org/x/time/rate"
+)
+
+// Schedule is a rate limit depending on the time of the day, i.e:
+//
+//   10MB                                          always 10MB/s
+//   1MB/s 09:00-18:00 else unlimited              1MB/s during business hours
+//   512K 09:00-12:00, 1M 14:00-18:00 else 10M     the first matching range wins
+//   100K 22:00-06:00                              ranges can span midnight, unlimited otherwise
+type Schedule struct {
+	rules    []scheduleRule
+	fallback rate.Limit
+}
+
+type scheduleRule struct {
+	limit    rate.Limit
+	from, to time.Duration // since midnight
+}
+
+// ParseSchedule parses a rate limit with optional time-of-day ranges
+func ParseSchedule(input string) (*Schedule, error) {
+	schedule := &Schedule{fallback: rate.Inf}
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return schedule, nil
+	}
+
+	rules := input
+	if idx := strings.Index(strings.ToLower(input), " else "); idx >= 0 {
+		fallback, err := parseLimit(input[idx+len(" else "):])
+		if err != nil {
+			return nil, err
+		}
+		schedule.fallback = fallback
+		rules = input[:idx]
+	}
+
+	for _, rule := range strings.Split(rules, ",") {
+		fields := strings.Fields(rule)
+		switch len(fields) {
+		case 1:
+			// a constant limit, only valid alone
+			if strings.Contains(rules, ",") || strings.Contains(strings.ToLower(input), " else ") {
+				return nil, fmt.Errorf("invalid rate limit %q: missing time range for %q", input, strings.TrimSpace(rule))
+			}
+			limit, err := parseLimit(fields[0])
+			if err != nil {
+				return nil, err
+			}
+			schedule.fallback = limit
+		case 2:
+			limit, err := parseLimit(fields[0])
+			if err != nil {
+				return nil, err
+			}
+			from, to, err := parseTimeRange(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid rate limit %q: %v", input, err)
+			}
+			schedule.rules = append(schedule.rules, scheduleRule{limit: limit, from: from, to: to})
+		default:
+			return nil, fmt.Errorf("invalid rate limit %q: expected '<rate> [HH:MM-HH:MM][, ...] [else <rate>]'", input)
+		}
+	}
+	return schedule, nil
+}
+
+// parseLimit parses a rate in bytes per second, i.e: "1MB", "1MB/s", "512K" or "unlimited"
+func parseLimit(input string) (rate.Limit, error) {
+	value := strings.TrimSuffix(strings.TrimSpace(input), "/s")
+	switch strings.ToLower(value) {
+	case "unlimited", "none", "off":
+		return rate.Inf, nil
+	}
+	bytes, err := humanize.ParseBytes(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q: %v", input, err)
+	}
+	if bytes == 0 {
+		return 0, fmt.Errorf("invalid rate %q: use 'unlimited' to disable the rate limit", input)
+	}
+	return rate.Limit(float64(bytes)), nil
+}
+
+// parseTimeRange parses "HH:MM-HH:MM"
+func parseTimeRange(input string) (time.Duration, time.Duration, error) {
+	parts := strings.Split(input, "-")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid time range %q, expected HH:MM-HH:MM", input)
+	}
+	bounds := make([]time.Duration, 2)
+	for idx, part := range parts {
+		parsed, err := time.Parse("15:04", part)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid time range %q, expected HH:MM-HH:MM", input)
+		}
+		bounds[idx] = time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute
+	}
+	if bounds[0] == bounds[1] {
+		return 0, 0, fmt.Errorf("invalid time range %q: empty range", input)
+	}
+	return bounds[0], bounds[1], nil
+}
+
+// Limit returns the rate limit at a given time, rate.Inf when unlimited
+func (s *Schedule) Limit(now time.Time) rate.Limit {
+	if s == nil {
+		return rate.Inf
+	}
+	hour, min, sec := now.Clock()
+	clock := time.Duration(hour)*time.Hour + time.Duration(min)*time.Minute + time.Duration(sec)*time.Second
+	for _, rule := range s.rules {
+		if rule.from < rule.to && clock >= rule.from && clock < rule.to {
+			return rule.limit
+		}
+		// the range spans midnight
+		if rule.from > rule.to && (clock >= rule.from || clock < rule.to) {
+			return rule.limit
+		}
+	}
+	return s.fallback
+}
+
+// IsUnlimited returns true if the schedule never limits the rate
+func (s *Schedule) IsUnlimited() bool {
+	return s.maxFiniteLimit() == 0
+}
+
+// maxFiniteLimit returns the highest limit of the schedule except unlimited, 0 if there is none
+func (s *Schedule) maxFiniteLimit() rate.Limit {
+	if s == nil {
+		return 0
+	}
+	max := rate.Limit(0)
+	limits := []rate.Limit{s.fallback}
+	for _, rule := range s.rules {
+		limits = append(limits, rule.limit)
+	}
+	for _, limit := range limits {
+		if limit != rate.Inf && limit > max {
+			max = limit
+		}
+	}
+	return max
+}
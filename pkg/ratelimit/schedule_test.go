@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/time/rate"
+)
+
+func TestParseSchedule(t *testing.T) {
+	Convey("Testing ParseSchedule()", t, func() {
+		at := func(clock string) time.Time {
+			parsed, err := time.Parse("15:04", clock)
+			So(err, ShouldBeNil)
+			return time.Date(2020, 1, 1, parsed.Hour(), parsed.Minute(), 0, 0, time.Local)
+		}
+
+		for _, test := range []struct {
+			input     string
+			clock     string
+			expected  rate.Limit
+			unlimited bool
+		}{
+			{"", "12:00", rate.Inf, true},
+			{"unlimited", "12:00", rate.Inf, true},
+			{"10M", "12:00", 10000000, false},
+			{"1MB/s", "03:00", 1000000, false},
+			{"1MiB", "03:00", 1048576, false},
+			{"1MB/s 09:00-18:00 else unlimited", "08:59", rate.Inf, false},
+			{"1MB/s 09:00-18:00 else unlimited", "09:00", 1000000, false},
+			{"1MB/s 09:00-18:00 else unlimited", "17:59", 1000000, false},
+			{"1MB/s 09:00-18:00 else unlimited", "18:00", rate.Inf, false},
+			{"512K 09:00-12:00, 1M 14:00-18:00 else 10M", "10:00", 512000, false},
+			{"512K 09:00-12:00, 1M 14:00-18:00 else 10M", "13:00", 10000000, false},
+			{"512K 09:00-12:00, 1M 14:00-18:00 else 10M", "15:00", 1000000, false},
+			{"100K 22:00-06:00", "23:30", 100000, false},
+			{"100K 22:00-06:00", "05:00", 100000, false},
+			{"100K 22:00-06:00", "12:00", rate.Inf, false},
+		} {
+			schedule, err := ParseSchedule(test.input)
+			So(err, ShouldBeNil)
+			So(schedule.Limit(at(test.clock)), ShouldEqual, test.expected)
+			So(schedule.IsUnlimited(), ShouldEqual, test.unlimited)
+		}
+
+		for _, invalid := range []string{
+			"fast",
+			"0",
+			"1M 09:00",
+			"1M 09:00-25:00",
+			"1M 09:00-09:00",
+			"1M, 2M 09:00-10:00",
+			"1M else 2M",
+			"1M 09:00-10:00 else fast",
+			"1M 09:00-10:00 extra",
+		} {
+			_, err := ParseSchedule(invalid)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
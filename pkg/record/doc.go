@@ -0,0 +1 @@
+package record // import "moul.io/assh/v2/pkg/record"
@@ -0,0 +1,193 @@
+package record
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"moul.io/assh/v2/pkg/sshproto"
+)
+
+var messageNames = map[byte]string{
+	1:  "DISCONNECT",
+	2:  "IGNORE",
+	3:  "UNIMPLEMENTED",
+	4:  "DEBUG",
+	5:  "SERVICE_REQUEST",
+	6:  "SERVICE_ACCEPT",
+	7:  "EXT_INFO",
+	20: "KEXINIT",
+	21: "NEWKEYS",
+	30: "KEXDH_INIT",
+	31: "KEXDH_REPLY",
+	32: "KEX_DH_GEX_INIT",
+	33: "KEX_DH_GEX_REPLY",
+	34: "KEX_DH_GEX_REQUEST",
+}
+
+// Summary describes the SSH protocol found in a capture
+type Summary struct {
+	Header    Header                    `json:"header"`
+	Duration  time.Duration             `json:"duration"`
+	Frames    int                       `json:"frames"`
+	Truncated bool                      `json:"truncated"`
+	Streams   map[string]*StreamSummary `json:"streams"` // "client" and "server"
+	// LongestSilence is the longest time without data in both directions, starting at SilenceAt
+	LongestSilence time.Duration `json:"longest_silence"`
+	SilenceAt      time.Duration `json:"silence_at"`
+	// LastSpeaker is the direction of the last frame before the disconnection
+	LastSpeaker string `json:"last_speaker,omitempty"`
+	// Negotiated contains the algorithms agreed by both KEXINIT
+	Negotiated *sshproto.KexInit `json:"negotiated,omitempty"`
+}
+
+// StreamSummary describes a direction of a capture
+type StreamSummary struct {
+	Bytes  int `json:"bytes"`
+	Frames int `json:"frames"`
+	// Version is the identification string, i.e: SSH-2.0-OpenSSH_8.9, the servers may send other lines before it
+	Version    string            `json:"version,omitempty"`
+	VersionAt  time.Duration     `json:"version_at"`
+	PreVersion []string          `json:"pre_version,omitempty"`
+	Packets    []Packet          `json:"packets,omitempty"`
+	KexInit    *sshproto.KexInit `json:"kexinit,omitempty"`
+	// EncryptedBytes are the bytes after NEWKEYS, the packet boundaries cannot be read anymore
+	EncryptedBytes int           `json:"encrypted_bytes"`
+	EncryptedAt    time.Duration `json:"encrypted_at,omitempty"`
+	// Error is the reason the stream cannot be parsed until the end, i.e: a middlebox altered it
+	Error string `json:"error,omitempty"`
+}
+
+// Packet is a cleartext packet of the binary packet protocol
+type Packet struct {
+	Offset  time.Duration `json:"offset"`
+	Length  uint32        `json:"length"`
+	Type    byte          `json:"type"`
+	Name    string        `json:"name"`
+	Details string        `json:"details,omitempty"`
+}
+
+// stream is the data of a direction, with the time of each frame
+type stream struct {
+	data    []byte
+	starts  []int
+	offsets []time.Duration
+}
+
+// offsetAt returns the time of the frame containing the byte at pos
+func (s *stream) offsetAt(pos int) time.Duration {
+	offset := time.Duration(0)
+	for idx, start := range s.starts {
+		if start > pos {
+			break
+		}
+		offset = s.offsets[idx]
+	}
+	return offset
+}
+
+// Inspect summarizes the version exchange and the packet boundaries of a capture
+func Inspect(header Header, frames []Frame) *Summary {
+	summary := &Summary{Header: header, Frames: len(frames), Streams: map[string]*StreamSummary{}}
+	streams := map[Direction]*stream{Sent: {}, Received: {}}
+	var last time.Duration
+	for idx, frame := range frames {
+		if frame.Direction == Truncated {
+			summary.Truncated = true
+			continue
+		}
+		if gap := frame.Offset - last; idx > 0 && gap > summary.LongestSilence {
+			summary.LongestSilence, summary.SilenceAt = gap, last
+		}
+		last = frame.Offset
+		s, found := streams[frame.Direction]
+		if !found {
+			continue
+		}
+		s.starts = append(s.starts, len(s.data))
+		s.offsets = append(s.offsets, frame.Offset)
+		s.data = append(s.data, frame.Data...)
+		summary.LastSpeaker = frame.Direction.String()
+	}
+	summary.Duration = last
+
+	for direction, s := range streams {
+		summary.Streams[direction.String()] = inspectStream(s)
+	}
+	client, server := summary.Streams[Sent.String()].KexInit, summary.Streams[Received.String()].KexInit
+	if client != nil && server != nil {
+		summary.Negotiated = sshproto.Negotiate(client, server)
+	}
+	return summary
+}
+
+func inspectStream(s *stream) *StreamSummary {
+	summary := &StreamSummary{Bytes: len(s.data), Frames: len(s.starts)}
+	if len(s.data) == 0 {
+		return summary
+	}
+
+	// version exchange
+	identification, rest, err := sshproto.ReadIdentification(s.data)
+	if errors.Is(err, sshproto.ErrIncomplete) {
+		summary.Error = "the version exchange is incomplete"
+		return summary
+	} else if err != nil {
+		summary.Error = fmt.Sprintf("no SSH version exchange in the first %d bytes", len(s.data))
+		return summary
+	}
+	summary.Version, summary.VersionAt = identification.Version, s.offsetAt(identification.Offset)
+	summary.PreVersion = identification.PreVersion
+
+	// binary packets, until NEWKEYS
+	for pos := len(s.data) - len(rest); pos < len(s.data); {
+		payload, size, err := sshproto.ReadPacket(s.data[pos:])
+		if err != nil {
+			summary.Error = fmt.Sprintf("%v at byte %d", err, pos)
+			return summary
+		}
+		packet := Packet{Offset: s.offsetAt(pos), Length: uint32(size - 4)}
+		if len(payload) > 0 {
+			packet.Type = payload[0]
+			packet.Name = messageNames[packet.Type]
+			if packet.Name == "" {
+				packet.Name = fmt.Sprintf("MSG_%d", packet.Type)
+			}
+			switch packet.Type {
+			case sshproto.MsgKexInit:
+				if kexInit, err := sshproto.ParseKexInit(payload); err == nil {
+					summary.KexInit = kexInit
+					packet.Details = "kex=" + strings.Join(kexInit.Kex, ",")
+				} else {
+					packet.Details = err.Error()
+				}
+			case sshproto.MsgDisconnect:
+				packet.Details = parseDisconnect(payload)
+			}
+		}
+		summary.Packets = append(summary.Packets, packet)
+		pos += size
+		if packet.Type == sshproto.MsgNewKeys {
+			summary.EncryptedBytes = len(s.data) - pos
+			if pos < len(s.data) {
+				summary.EncryptedAt = s.offsetAt(pos)
+			}
+			return summary
+		}
+	}
+	return summary
+}
+
+func parseDisconnect(payload []byte) string {
+	if len(payload) < 5 {
+		return ""
+	}
+	reason := binary.BigEndian.Uint32(payload[1:])
+	description, _, err := sshproto.ReadString(payload[5:])
+	if err != nil {
+		return fmt.Sprintf("reason=%d", reason)
+	}
+	return fmt.Sprintf("reason=%d %q", reason, description)
+}
@@ -0,0 +1,306 @@
+package record
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// A capture file is:
+//
+//	magic       "ASSHREC1"
+//	header      uint32 length + JSON Header
+//	frames      Direction (1 byte) + uint64 nanoseconds since Header.StartedAt + uint32 length + data
+//
+// the integers are big-endian, a Truncated frame without data is written when the size cap is reached
+const (
+	magic = "ASSHREC1"
+	// Extension is the extension of the capture files
+	Extension = ".assh-rec"
+
+	frameHeaderSize = 1 + 8 + 4
+	maxFrameSize    = 16 * 1024 * 1024
+)
+
+// Direction is the origin of the data of a frame
+type Direction byte
+
+const (
+	// Sent is the data sent by the ssh client to the server
+	Sent Direction = '>'
+	// Received is the data received from the server
+	Received Direction = '<'
+	// Truncated marks the end of a capture that reached its size cap
+	Truncated Direction = '!'
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Sent:
+		return "client"
+	case Received:
+		return "server"
+	case Truncated:
+		return "truncated"
+	default:
+		return fmt.Sprintf("unknown(%d)", d)
+	}
+}
+
+// Header describes the connection of a capture
+type Header struct {
+	Host      string    `json:"host"`
+	HostName  string    `json:"hostname"`
+	Port      string    `json:"port"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// Frame is a chunk of data read in a direction
+type Frame struct {
+	Direction Direction
+	Offset    time.Duration // since Header.StartedAt
+	Data      []byte
+}
+
+// Options configures the size and the rotation of the captures
+type Options struct {
+	// MaxSize stops the recording when the file reaches this size, 0 for unlimited
+	MaxSize int64
+	// MaxFiles is the amount of captures kept per host, 0 for unlimited
+	MaxFiles int
+}
+
+// Recorder writes the frames of a connection to a capture file
+type Recorder struct {
+	lock      sync.Mutex
+	path      string
+	file      *os.File
+	writer    *bufio.Writer
+	startedAt time.Time
+	size      int64
+	maxSize   int64
+	stopped   bool
+}
+
+// Create starts a capture in dir, named after the host and the time of the connection, and removes the
+// oldest captures of the host
+func Create(dir string, header Header, options Options) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%s-%s%s", safeName(header.Host), header.StartedAt.UTC().Format("20060102T150405.000000000Z"), Extension)
+	path := filepath.Join(dir, name)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{
+		path:      path,
+		file:      file,
+		writer:    bufio.NewWriter(file),
+		startedAt: header.StartedAt,
+		maxSize:   options.MaxSize,
+	}
+
+	encoded, err := json.Marshal(header)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, uint32(len(encoded)))
+	for _, chunk := range [][]byte{[]byte(magic), buf, encoded} {
+		if _, err := r.writer.Write(chunk); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		r.size += int64(len(chunk))
+	}
+	if err := r.writer.Flush(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	if options.MaxFiles > 0 {
+		if err := prune(dir, safeName(header.Host), options.MaxFiles); err != nil {
+			logger().Warn("Failed to remove the old captures", zap.String("dir", dir), zap.Error(err))
+		}
+	}
+	return r, nil
+}
+
+// Path returns the path of the capture file
+func (r *Recorder) Path() string { return r.path }
+
+// Record appends a frame, the data is dropped once the size cap is reached
+func (r *Recorder) Record(direction Direction, data []byte, now time.Time) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.stopped || len(data) == 0 {
+		return nil
+	}
+	if r.maxSize > 0 && r.size+int64(frameHeaderSize+len(data)) > r.maxSize-frameHeaderSize {
+		// keep room for the Truncated frame
+		r.stopped = true
+		return r.writeFrame(Truncated, nil, now)
+	}
+	return r.writeFrame(direction, data, now)
+}
+
+func (r *Recorder) writeFrame(direction Direction, data []byte, now time.Time) error {
+	header := make([]byte, frameHeaderSize)
+	header[0] = byte(direction)
+	offset := now.Sub(r.startedAt)
+	if offset < 0 {
+		offset = 0
+	}
+	binary.BigEndian.PutUint64(header[1:9], uint64(offset))
+	binary.BigEndian.PutUint32(header[9:13], uint32(len(data)))
+	if _, err := r.writer.Write(header); err != nil {
+		return err
+	}
+	if _, err := r.writer.Write(data); err != nil {
+		return err
+	}
+	r.size += int64(frameHeaderSize + len(data))
+	// a frame is written at once, the capture stays readable if ssh or assh is killed
+	return r.writer.Flush()
+}
+
+// stop drops the next frames
+func (r *Recorder) stop() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.stopped = true
+}
+
+// Close closes the capture file
+func (r *Recorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.file.Close()
+}
+
+// Reader returns a reader recording the data read from src in a direction, the recording errors are
+// logged and stop the recording but not the connection
+func (r *Recorder) Reader(src io.Reader, direction Direction) io.Reader {
+	return &recordingReader{src: src, recorder: r, direction: direction}
+}
+
+type recordingReader struct {
+	src       io.Reader
+	recorder  *Recorder
+	direction Direction
+}
+
+func (r *recordingReader) Read(buf []byte) (int, error) {
+	n, err := r.src.Read(buf)
+	if n > 0 {
+		if recordErr := r.recorder.Record(r.direction, buf[:n], time.Now()); recordErr != nil {
+			logger().Warn("Failed to record the connection", zap.String("file", r.recorder.path), zap.Error(recordErr))
+			r.recorder.stop()
+		}
+	}
+	return n, err
+}
+
+// safeName replaces the characters of a host that are not welcome in a file name
+func safeName(host string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		return r
+	}, host)
+}
+
+// prune removes the oldest captures of a host, the names sort by date
+func prune(dir string, host string, keep int) error {
+	matches, err := filepath.Glob(filepath.Join(dir, host+"-*"+Extension))
+	if err != nil {
+		return err
+	}
+	// "web-2020..." must not remove the captures of "web-1"
+	captures := []string{}
+	for _, match := range matches {
+		rest := strings.TrimPrefix(filepath.Base(match), host+"-")
+		if len(rest) > 0 && rest[0] >= '0' && rest[0] <= '9' && !strings.Contains(strings.TrimSuffix(rest, Extension), "-") {
+			captures = append(captures, match)
+		}
+	}
+	sort.Strings(captures)
+	for len(captures) > keep {
+		if err := os.Remove(captures[0]); err != nil {
+			return err
+		}
+		captures = captures[1:]
+	}
+	return nil
+}
+
+// Open reads a capture file
+func Open(path string) (*Header, []Frame, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	return Read(bufio.NewReader(file))
+}
+
+// Read parses a capture, the frames read before an error are returned with it
+func Read(source io.Reader) (*Header, []Frame, error) {
+	prefix := make([]byte, len(magic)+4)
+	if _, err := io.ReadFull(source, prefix); err != nil {
+		return nil, nil, fmt.Errorf("not an assh capture: %v", err)
+	}
+	if string(prefix[:len(magic)]) != magic {
+		return nil, nil, fmt.Errorf("not an assh capture: invalid magic %q", prefix[:len(magic)])
+	}
+	headerSize := binary.BigEndian.Uint32(prefix[len(magic):])
+	if headerSize > maxFrameSize {
+		return nil, nil, fmt.Errorf("invalid header size %d", headerSize)
+	}
+	encoded := make([]byte, headerSize)
+	if _, err := io.ReadFull(source, encoded); err != nil {
+		return nil, nil, fmt.Errorf("truncated header: %v", err)
+	}
+	var header Header
+	if err := json.Unmarshal(encoded, &header); err != nil {
+		return nil, nil, fmt.Errorf("invalid header: %v", err)
+	}
+
+	frames := []Frame{}
+	frameHeader := make([]byte, frameHeaderSize)
+	for {
+		if _, err := io.ReadFull(source, frameHeader); err == io.EOF {
+			return &header, frames, nil
+		} else if err != nil {
+			return &header, frames, fmt.Errorf("truncated frame %d: %v", len(frames), err)
+		}
+		size := binary.BigEndian.Uint32(frameHeader[9:13])
+		if size > maxFrameSize {
+			return &header, frames, fmt.Errorf("invalid size of frame %d: %d", len(frames), size)
+		}
+		frame := Frame{
+			Direction: Direction(frameHeader[0]),
+			Offset:    time.Duration(binary.BigEndian.Uint64(frameHeader[1:9])),
+			Data:      make([]byte, size),
+		}
+		if _, err := io.ReadFull(source, frame.Data); err != nil {
+			return &header, frames, fmt.Errorf("truncated frame %d: %v", len(frames), err)
+		}
+		frames = append(frames, frame)
+	}
+}
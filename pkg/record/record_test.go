@@ -0,0 +1,199 @@
+package record
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"moul.io/assh/v2/pkg/sshproto"
+)
+
+// sshPacket builds a binary packet of RFC 4253 section 6 with a minimal padding
+func sshPacket(payload []byte) []byte {
+	padding := 4
+	packet := make([]byte, 5, 5+len(payload)+padding)
+	binary.BigEndian.PutUint32(packet, uint32(1+len(payload)+padding))
+	packet[4] = byte(padding)
+	packet = append(packet, payload...)
+	return append(packet, make([]byte, padding)...)
+}
+
+func kexInit(lists ...string) []byte {
+	payload := append([]byte{sshproto.MsgKexInit}, make([]byte, 16)...)
+	for _, list := range append(lists, "", "") { // languages
+		size := make([]byte, 4)
+		binary.BigEndian.PutUint32(size, uint32(len(list)))
+		payload = append(payload, size...)
+		payload = append(payload, list...)
+	}
+	return append(payload, 0, 0, 0, 0, 0)
+}
+
+func TestRecorder(t *testing.T) {
+	Convey("Testing Recorder", t, func() {
+		dir, err := ioutil.TempDir("", "assh-record")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		start := time.Date(2020, 1, 31, 9, 12, 3, 0, time.UTC)
+		header := Header{Host: "web/prod", HostName: "10.0.0.1", Port: "22", StartedAt: start}
+
+		Convey("round trip", func() {
+			recorder, err := Create(filepath.Join(dir, "sub"), header, Options{})
+			So(err, ShouldBeNil)
+			So(filepath.Base(recorder.Path()), ShouldEqual, "web_prod-20200131T091203.000000000Z"+Extension)
+
+			So(recorder.Record(Sent, []byte("hello"), start.Add(time.Millisecond)), ShouldBeNil)
+			So(recorder.Record(Received, []byte("world"), start.Add(time.Second)), ShouldBeNil)
+			So(recorder.Record(Sent, nil, start.Add(2*time.Second)), ShouldBeNil)
+			read, err := ioutil.ReadAll(recorder.Reader(strings.NewReader("bye"), Sent))
+			So(err, ShouldBeNil)
+			So(string(read), ShouldEqual, "bye")
+
+			// the frames are on disk before Close, i.e: when assh is killed
+			_, frames, err := Open(recorder.Path())
+			So(err, ShouldBeNil)
+			So(len(frames), ShouldEqual, 3)
+			So(recorder.Close(), ShouldBeNil)
+
+			info, err := os.Stat(recorder.Path())
+			So(err, ShouldBeNil)
+			So(info.Mode().Perm(), ShouldEqual, os.FileMode(0600))
+
+			readHeader, frames, err := Open(recorder.Path())
+			So(err, ShouldBeNil)
+			So(readHeader.Host, ShouldEqual, "web/prod")
+			So(readHeader.StartedAt.Equal(start), ShouldBeTrue)
+			So(len(frames), ShouldEqual, 3)
+			So(frames[0], ShouldResemble, Frame{Direction: Sent, Offset: time.Millisecond, Data: []byte("hello")})
+			So(frames[1], ShouldResemble, Frame{Direction: Received, Offset: time.Second, Data: []byte("world")})
+			So(string(frames[2].Data), ShouldEqual, "bye")
+
+			// a capture cut by a crash keeps the complete frames
+			content, err := ioutil.ReadFile(recorder.Path())
+			So(err, ShouldBeNil)
+			So(ioutil.WriteFile(recorder.Path(), content[:len(content)-2], 0600), ShouldBeNil)
+			_, frames, err = Open(recorder.Path())
+			So(err, ShouldNotBeNil)
+			So(len(frames), ShouldEqual, 2)
+
+			So(ioutil.WriteFile(recorder.Path(), []byte("SSH-2.0-OpenSSH\r\n"), 0600), ShouldBeNil)
+			readHeader, _, err = Open(recorder.Path())
+			So(err, ShouldNotBeNil)
+			So(readHeader, ShouldBeNil)
+		})
+
+		Convey("size cap", func() {
+			recorder, err := Create(dir, header, Options{MaxSize: 200})
+			So(err, ShouldBeNil)
+			for i := 0; i < 10; i++ {
+				So(recorder.Record(Sent, make([]byte, 30), start), ShouldBeNil)
+			}
+			So(recorder.Close(), ShouldBeNil)
+
+			info, err := os.Stat(recorder.Path())
+			So(err, ShouldBeNil)
+			So(info.Size(), ShouldBeLessThanOrEqualTo, 200)
+			_, frames, err := Open(recorder.Path())
+			So(err, ShouldBeNil)
+			So(len(frames), ShouldBeGreaterThan, 1)
+			So(frames[len(frames)-1].Direction, ShouldEqual, Truncated)
+			So(Inspect(header, frames).Truncated, ShouldBeTrue)
+		})
+
+		Convey("rotation", func() {
+			other := header
+			other.Host = "web/prod-1"
+			recorder, err := Create(dir, other, Options{MaxFiles: 2})
+			So(err, ShouldBeNil)
+			So(recorder.Close(), ShouldBeNil)
+
+			for i := 0; i < 4; i++ {
+				header.StartedAt = start.Add(time.Duration(i) * time.Minute)
+				recorder, err := Create(dir, header, Options{MaxFiles: 2})
+				So(err, ShouldBeNil)
+				So(recorder.Close(), ShouldBeNil)
+			}
+			matches, err := filepath.Glob(filepath.Join(dir, "*"+Extension))
+			So(err, ShouldBeNil)
+			names := []string{}
+			for _, match := range matches {
+				names = append(names, filepath.Base(match))
+			}
+			So(names, ShouldResemble, []string{
+				"web_prod-1-20200131T091203.000000000Z" + Extension,
+				"web_prod-20200131T091403.000000000Z" + Extension,
+				"web_prod-20200131T091503.000000000Z" + Extension,
+			})
+		})
+	})
+}
+
+func TestInspect(t *testing.T) {
+	Convey("Testing Inspect", t, func() {
+		header := Header{Host: "web"}
+		clientKex := kexInit("curve25519-sha256,diffie-hellman-group14-sha256", "ssh-ed25519,rsa-sha2-512",
+			"chacha20-poly1305@openssh.com,aes128-ctr", "aes128-ctr", "hmac-sha2-256", "hmac-sha2-256", "none", "none")
+		serverKex := kexInit("diffie-hellman-group14-sha256,curve25519-sha256", "rsa-sha2-512",
+			"aes128-ctr,chacha20-poly1305@openssh.com", "aes256-ctr,aes128-ctr", "hmac-sha2-256", "hmac-sha1,hmac-sha2-256", "none,zlib", "none")
+		frames := []Frame{
+			{Direction: Sent, Offset: 0, Data: []byte("SSH-2.0-OpenSSH_8.2\r\n")},
+			{Direction: Received, Offset: 10 * time.Millisecond, Data: []byte("Welcome\r\nSSH-2.0-OpenSSH_7.4\r\n")},
+			// a packet split across frames
+			{Direction: Sent, Offset: 20 * time.Millisecond, Data: sshPacket(clientKex)[:10]},
+			{Direction: Sent, Offset: 30 * time.Millisecond, Data: sshPacket(clientKex)[10:]},
+			{Direction: Received, Offset: 40 * time.Millisecond, Data: sshPacket(serverKex)},
+			{Direction: Sent, Offset: 50 * time.Millisecond, Data: append(sshPacket([]byte{sshproto.MsgNewKeys}), "encrypted"...)},
+			{Direction: Received, Offset: 3 * time.Second, Data: sshPacket([]byte{1, 0, 0, 0, 2, 0, 0, 0, 3, 'b', 'y', 'e'})},
+		}
+
+		summary := Inspect(header, frames)
+		So(summary.Frames, ShouldEqual, 7)
+		So(summary.Duration, ShouldEqual, 3*time.Second)
+		So(summary.LongestSilence, ShouldEqual, 3*time.Second-50*time.Millisecond)
+		So(summary.SilenceAt, ShouldEqual, 50*time.Millisecond)
+		So(summary.LastSpeaker, ShouldEqual, "server")
+		So(summary.Truncated, ShouldBeFalse)
+
+		client := summary.Streams["client"]
+		So(client.Version, ShouldEqual, "SSH-2.0-OpenSSH_8.2")
+		So(client.Error, ShouldBeEmpty)
+		So(len(client.Packets), ShouldEqual, 2)
+		So(client.Packets[0].Name, ShouldEqual, "KEXINIT")
+		So(client.Packets[0].Offset, ShouldEqual, 20*time.Millisecond)
+		So(client.Packets[1].Name, ShouldEqual, "NEWKEYS")
+		So(client.EncryptedBytes, ShouldEqual, len("encrypted"))
+		So(client.EncryptedAt, ShouldEqual, 50*time.Millisecond)
+
+		server := summary.Streams["server"]
+		So(server.Version, ShouldEqual, "SSH-2.0-OpenSSH_7.4")
+		So(server.VersionAt, ShouldEqual, 10*time.Millisecond)
+		So(server.PreVersion, ShouldResemble, []string{"Welcome"})
+		So(len(server.Packets), ShouldEqual, 2)
+		So(server.Packets[1].Name, ShouldEqual, "DISCONNECT")
+		So(server.Packets[1].Details, ShouldEqual, `reason=2 "bye"`)
+
+		So(summary.Negotiated, ShouldResemble, &sshproto.KexInit{
+			Kex:               []string{"curve25519-sha256"},
+			HostKey:           []string{"rsa-sha2-512"},
+			CiphersClient:     []string{"chacha20-poly1305@openssh.com"},
+			CiphersServer:     []string{"aes128-ctr"},
+			MACsClient:        []string{"<implicit>"},
+			MACsServer:        []string{"hmac-sha2-256"},
+			CompressionClient: []string{"none"},
+			CompressionServer: []string{"none"},
+		})
+
+		Convey("not SSH", func() {
+			summary := Inspect(header, []Frame{{Direction: Received, Data: []byte("HTTP/1.1 400 Bad Request\r\n\r\n")}})
+			So(summary.Streams["server"].Version, ShouldBeEmpty)
+			So(summary.Streams["server"].Error, ShouldEqual, "the version exchange is incomplete")
+			So(summary.Negotiated, ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1 @@
+package sshargs // import "moul.io/assh/v2/pkg/sshargs"
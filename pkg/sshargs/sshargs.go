@@ -0,0 +1,138 @@
+package sshargs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sshOptionsWithValue are the options of ssh(1) taking a value, from the getopt(3) string of OpenSSH:
+// "1246ab:c:e:fgi:kl:m:no:p:qstvxAB:CD:E:F:GI:J:KL:MNO:P:Q:R:S:TVw:W:XYy"
+const (
+	sshOptionsWithValue = "bceilmopBDEFIJLOPQRSwW"
+	sshBoolOptions      = "1246afgknqstvxACGKMNTVXYy"
+)
+
+// Args are the arguments of a ssh command line, parsed like ssh(1) does
+type Args struct {
+	// Destination is the destination as written on the command line
+	Destination string
+	// User, Host and Port are parsed from the destination, `[user@]host` or `ssh://[user@]host[:port]`
+	User string
+	Host string
+	Port string
+	// Command is the remote command and its arguments
+	Command []string
+	// Flags contains the values of the options, i.e: Flags["o"] = ["LogLevel=DEBUG"], Flags["v"] = ["", ""]
+	Flags map[string][]string
+}
+
+// Parse parses the arguments of ssh (without the program name); like OpenSSH, the options
+// may follow the destination until the first argument of the command or `--`
+func Parse(args []string) (*Args, error) {
+	parsed := &Args{Flags: map[string][]string{}}
+	terminated := false
+
+	for idx := 0; idx < len(args); idx++ {
+		arg := args[idx]
+		if !terminated && arg == "--" {
+			terminated = true
+			continue
+		}
+		if terminated || arg == "-" || !strings.HasPrefix(arg, "-") {
+			if parsed.Destination != "" {
+				parsed.Command = args[idx:]
+				break
+			}
+			if err := parsed.setDestination(arg); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// grouped short options, i.e: `-vvv`, `-tt`, `-At`, `-p22` or `-oLogLevel=DEBUG`
+		for pos := 1; pos < len(arg); pos++ {
+			name := arg[pos : pos+1]
+			switch {
+			case strings.Contains(sshBoolOptions, name):
+				parsed.Flags[name] = append(parsed.Flags[name], "")
+				continue
+			case strings.Contains(sshOptionsWithValue, name):
+				value := arg[pos+1:]
+				if value == "" {
+					if idx+1 >= len(args) {
+						return nil, fmt.Errorf("option -%s requires an argument", name)
+					}
+					idx++
+					value = args[idx]
+				}
+				parsed.Flags[name] = append(parsed.Flags[name], value)
+			default:
+				return nil, fmt.Errorf("unknown option -%s", name)
+			}
+			break
+		}
+	}
+
+	if parsed.Destination == "" {
+		return nil, fmt.Errorf("missing destination")
+	}
+	return parsed, nil
+}
+
+// setDestination parses `[user@]host` or `ssh://[user@]host[:port]`
+func (a *Args) setDestination(destination string) error {
+	a.Destination = destination
+	if strings.HasPrefix(destination, "ssh://") {
+		authority := strings.TrimPrefix(destination, "ssh://")
+		if strings.Contains(strings.TrimSuffix(authority, "/"), "/") {
+			return fmt.Errorf("invalid destination %q: ssh URIs do not accept a path", destination)
+		}
+		authority = strings.TrimSuffix(authority, "/")
+		if idx := strings.LastIndex(authority, "@"); idx >= 0 {
+			a.User, authority = authority[:idx], authority[idx+1:]
+		}
+		switch {
+		case strings.HasPrefix(authority, "["):
+			end := strings.Index(authority, "]")
+			if end < 0 {
+				return fmt.Errorf("invalid destination %q: unclosed bracket", destination)
+			}
+			a.Host = authority[1:end]
+			a.Port = strings.TrimPrefix(authority[end+1:], ":")
+		case strings.Contains(authority, ":"):
+			parts := strings.SplitN(authority, ":", 2)
+			a.Host, a.Port = parts[0], parts[1]
+		default:
+			a.Host = authority
+		}
+	} else if idx := strings.LastIndex(destination, "@"); idx >= 0 {
+		a.User, a.Host = destination[:idx], destination[idx+1:]
+	} else {
+		a.Host = destination
+	}
+	if a.Host == "" {
+		return fmt.Errorf("invalid destination %q: empty host", destination)
+	}
+	return nil
+}
+
+// Verbosity returns the amount of -v flags
+func (a *Args) Verbosity() int {
+	return len(a.Flags["v"])
+}
+
+// Option returns the value of a `-o Key=Value` or `-o "Key Value"` option, the keys are case-insensitive;
+// like ssh, the first value wins
+func (a *Args) Option(key string) (string, bool) {
+	for _, option := range a.Flags["o"] {
+		option = strings.TrimSpace(option)
+		idx := strings.IndexAny(option, "= \t")
+		if idx < 0 {
+			continue
+		}
+		if strings.EqualFold(option[:idx], key) {
+			return strings.TrimLeft(option[idx:], "= \t"), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,64 @@
+package sshargs
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParse(t *testing.T) {
+	Convey("Testing Parse()", t, func() {
+		for _, test := range []struct {
+			args        []string
+			destination string
+			user        string
+			host        string
+			port        string
+			command     []string
+			verbosity   int
+		}{
+			{[]string{"web1"}, "web1", "", "web1", "", nil, 0},
+			{[]string{"-vvv", "-At", "root@web1", "uptime"}, "root@web1", "root", "web1", "", []string{"uptime"}, 3},
+			{[]string{"-v", "-p22", "-v", "-l", "root", "web1", "ls", "-la"}, "web1", "", "web1", "", []string{"ls", "-la"}, 2},
+			{[]string{"web1", "-v", "-o", "LogLevel=DEBUG", "tail", "-f", "/var/log/syslog"}, "web1", "", "web1", "", []string{"tail", "-f", "/var/log/syslog"}, 1},
+			{[]string{"-tt", "--", "web1", "-v"}, "web1", "", "web1", "", []string{"-v"}, 0},
+			{[]string{"web1", "--", "-v"}, "web1", "", "web1", "", []string{"-v"}, 0},
+			{[]string{"-J", "gw", "ssh://root@web1:2222"}, "ssh://root@web1:2222", "root", "web1", "2222", nil, 0},
+			{[]string{"ssh://[::1]:2222/"}, "ssh://[::1]:2222/", "", "::1", "2222", nil, 0},
+			{[]string{"-oProxyJump=gw", "user@domain.tld@web1/gw"}, "user@domain.tld@web1/gw", "user@domain.tld", "web1/gw", "", nil, 0},
+		} {
+			args, err := Parse(test.args)
+			So(err, ShouldBeNil)
+			So(args.Destination, ShouldEqual, test.destination)
+			So(args.User, ShouldEqual, test.user)
+			So(args.Host, ShouldEqual, test.host)
+			So(args.Port, ShouldEqual, test.port)
+			So(args.Command, ShouldResemble, test.command)
+			So(args.Verbosity(), ShouldEqual, test.verbosity)
+		}
+
+		args, err := Parse([]string{"-oLogLevel=DEBUG3", "-o", "loglevel quiet", "-o", "User = root", "-i", "id_rsa", "web1"})
+		So(err, ShouldBeNil)
+		So(args.Flags["i"], ShouldResemble, []string{"id_rsa"})
+		value, found := args.Option("LogLevel")
+		So(found, ShouldBeTrue)
+		So(value, ShouldEqual, "DEBUG3")
+		value, found = args.Option("user")
+		So(found, ShouldBeTrue)
+		So(value, ShouldEqual, "root")
+		_, found = args.Option("Port")
+		So(found, ShouldBeFalse)
+
+		for _, invalid := range [][]string{
+			{},
+			{"-v"},
+			{"-Z", "web1"},
+			{"web1", "-p"},
+			{"ssh://web1/path"},
+			{"root@"},
+		} {
+			_, err := Parse(invalid)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
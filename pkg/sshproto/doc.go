@@ -0,0 +1 @@
+package sshproto // import "moul.io/assh/v2/pkg/sshproto"
@@ -0,0 +1,169 @@
+package sshproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// MaxPacketLength is the largest packet all the implementations must support, RFC 4253 section 6.1
+	MaxPacketLength = 35000
+	// the lines before the version and their size are limited, RFC 4253 section 4.2
+	maxVersionLines  = 64
+	maxVersionLength = 255
+
+	// MsgDisconnect is the message type of SSH_MSG_DISCONNECT
+	MsgDisconnect = 1
+	// MsgKexInit is the message type of SSH_MSG_KEXINIT
+	MsgKexInit = 20
+	// MsgNewKeys is the message type of SSH_MSG_NEWKEYS, the following packets are encrypted
+	MsgNewKeys = 21
+)
+
+// AEADCiphers are the ciphers authenticating the packets themselves, their MAC is implicit
+var AEADCiphers = map[string]bool{
+	"aes128-gcm@openssh.com":        true,
+	"aes256-gcm@openssh.com":        true,
+	"chacha20-poly1305@openssh.com": true,
+}
+
+// ErrIncomplete is returned when more data is needed to parse a message
+var ErrIncomplete = errors.New("incomplete")
+
+// Identification is the version exchange of a side of a connection, RFC 4253 section 4.2
+type Identification struct {
+	// Version is the identification string, i.e: SSH-2.0-OpenSSH_8.9
+	Version string
+	// PreVersion are the lines sent by the servers before the version
+	PreVersion []string
+	// Offset is the position of the version line
+	Offset int
+}
+
+// ReadIdentification parses the version exchange starting data and returns the data following it,
+// ErrIncomplete if the version line is not received yet
+func ReadIdentification(data []byte) (*Identification, []byte, error) {
+	identification := &Identification{}
+	pos := 0
+	for lines := 0; ; lines++ {
+		end := bytes.IndexByte(data[pos:], '\n')
+		if lines >= maxVersionLines || end > maxVersionLength || (end < 0 && len(data)-pos > maxVersionLength) {
+			return nil, nil, errors.New("no SSH version exchange")
+		}
+		if end < 0 {
+			return nil, nil, ErrIncomplete
+		}
+		line := strings.TrimRight(string(data[pos:pos+end]), "\r")
+		if strings.HasPrefix(line, "SSH-") {
+			identification.Version, identification.Offset = line, pos
+			return identification, data[pos+end+1:], nil
+		}
+		identification.PreVersion = append(identification.PreVersion, line)
+		pos += end + 1
+	}
+}
+
+// ReadPacket returns the payload of the cleartext binary packet starting data and the size of the packet,
+// RFC 4253 section 6; the error wraps ErrIncomplete if the packet is not received entirely yet
+func ReadPacket(data []byte) ([]byte, int, error) {
+	if len(data) < 5 {
+		return nil, 0, fmt.Errorf("%w packet header (%d bytes)", ErrIncomplete, len(data))
+	}
+	length := binary.BigEndian.Uint32(data)
+	padding := int(data[4])
+	if length < 5 || length > MaxPacketLength || padding >= int(length) {
+		return nil, 0, fmt.Errorf("invalid packet: length=%d padding=%d", length, padding)
+	}
+	if len(data)-4 < int(length) {
+		return nil, 0, fmt.Errorf("%w packet: %d of %d bytes", ErrIncomplete, len(data)-4, length)
+	}
+	return data[5 : 4+int(length)-padding], 4 + int(length), nil
+}
+
+// ReadString reads a string of RFC 4251 section 5 and returns the data following it
+func ReadString(data []byte) (string, []byte, error) {
+	if len(data) < 4 {
+		return "", nil, errors.New("truncated string")
+	}
+	length := binary.BigEndian.Uint32(data)
+	if uint32(len(data)-4) < length {
+		return "", nil, errors.New("truncated string")
+	}
+	return string(data[4 : 4+length]), data[4+length:], nil
+}
+
+// KexInit are the name-lists of a SSH_MSG_KEXINIT, or the negotiated algorithms
+type KexInit struct {
+	Kex               []string `json:"kex"`
+	HostKey           []string `json:"host_key"`
+	CiphersClient     []string `json:"ciphers_client_to_server"`
+	CiphersServer     []string `json:"ciphers_server_to_client"`
+	MACsClient        []string `json:"macs_client_to_server"`
+	MACsServer        []string `json:"macs_server_to_client"`
+	CompressionClient []string `json:"compression_client_to_server"`
+	CompressionServer []string `json:"compression_server_to_client"`
+}
+
+// ParseKexInit parses the payload of a SSH_MSG_KEXINIT, RFC 4253 section 7.1
+func ParseKexInit(payload []byte) (*KexInit, error) {
+	// message type and cookie
+	if len(payload) < 17 {
+		return nil, errors.New("truncated KEXINIT")
+	}
+	if payload[0] != MsgKexInit {
+		return nil, errors.New("not a KEXINIT packet")
+	}
+	rest := payload[17:]
+	kexInit := &KexInit{}
+	for _, list := range []*[]string{
+		&kexInit.Kex, &kexInit.HostKey,
+		&kexInit.CiphersClient, &kexInit.CiphersServer,
+		&kexInit.MACsClient, &kexInit.MACsServer,
+		&kexInit.CompressionClient, &kexInit.CompressionServer,
+	} {
+		value, next, err := ReadString(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid KEXINIT: %v", err)
+		}
+		if value != "" {
+			*list = strings.Split(value, ",")
+		}
+		rest = next
+	}
+	return kexInit, nil
+}
+
+// Negotiate picks the first algorithm of the client supported by the server, RFC 4253 section 7.1; the
+// lists of the result are empty when there is no common algorithm
+func Negotiate(client, server *KexInit) *KexInit {
+	pick := func(client, server []string) []string {
+		for _, candidate := range client {
+			for _, supported := range server {
+				if candidate == supported {
+					return []string{candidate}
+				}
+			}
+		}
+		return nil
+	}
+	negotiated := &KexInit{
+		Kex:               pick(client.Kex, server.Kex),
+		HostKey:           pick(client.HostKey, server.HostKey),
+		CiphersClient:     pick(client.CiphersClient, server.CiphersClient),
+		CiphersServer:     pick(client.CiphersServer, server.CiphersServer),
+		MACsClient:        pick(client.MACsClient, server.MACsClient),
+		MACsServer:        pick(client.MACsServer, server.MACsServer),
+		CompressionClient: pick(client.CompressionClient, server.CompressionClient),
+		CompressionServer: pick(client.CompressionServer, server.CompressionServer),
+	}
+	if len(negotiated.CiphersClient) > 0 && AEADCiphers[negotiated.CiphersClient[0]] {
+		negotiated.MACsClient = []string{"<implicit>"}
+	}
+	if len(negotiated.CiphersServer) > 0 && AEADCiphers[negotiated.CiphersServer[0]] {
+		negotiated.MACsServer = []string{"<implicit>"}
+	}
+	return negotiated
+}
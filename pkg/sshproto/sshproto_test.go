@@ -0,0 +1,103 @@
+package sshproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func nameList(list string) []byte {
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(len(list)))
+	return append(size, list...)
+}
+
+func TestReadIdentification(t *testing.T) {
+	Convey("Testing ReadIdentification()", t, func() {
+		identification, rest, err := ReadIdentification([]byte("Welcome\r\nSSH-2.0-OpenSSH_9.2p1 Debian\r\n\x00\x00"))
+		So(err, ShouldBeNil)
+		So(identification.Version, ShouldEqual, "SSH-2.0-OpenSSH_9.2p1 Debian")
+		So(identification.PreVersion, ShouldResemble, []string{"Welcome"})
+		So(identification.Offset, ShouldEqual, 9)
+		So(rest, ShouldResemble, []byte("\x00\x00"))
+
+		_, _, err = ReadIdentification([]byte("SSH-2.0-OpenSSH"))
+		So(errors.Is(err, ErrIncomplete), ShouldBeTrue)
+		_, _, err = ReadIdentification([]byte(strings.Repeat("x", 10) + "\n"))
+		So(errors.Is(err, ErrIncomplete), ShouldBeTrue)
+		_, _, err = ReadIdentification([]byte(strings.Repeat("x\n", 64) + "SSH-2.0-late\r\n"))
+		So(err, ShouldNotBeNil)
+		So(errors.Is(err, ErrIncomplete), ShouldBeFalse)
+		_, _, err = ReadIdentification([]byte(strings.Repeat("x", 300)))
+		So(err, ShouldNotBeNil)
+		So(errors.Is(err, ErrIncomplete), ShouldBeFalse)
+	})
+}
+
+func TestReadPacket(t *testing.T) {
+	Convey("Testing ReadPacket()", t, func() {
+		// length=7: padding length, 2 bytes of payload, 4 bytes of padding
+		packet := []byte{0, 0, 0, 7, 4, MsgNewKeys, 42, 0, 0, 0, 0, 0xff}
+		payload, size, err := ReadPacket(packet)
+		So(err, ShouldBeNil)
+		So(payload, ShouldResemble, []byte{MsgNewKeys, 42})
+		So(size, ShouldEqual, 11)
+
+		_, _, err = ReadPacket(packet[:3])
+		So(errors.Is(err, ErrIncomplete), ShouldBeTrue)
+		_, _, err = ReadPacket(packet[:8])
+		So(errors.Is(err, ErrIncomplete), ShouldBeTrue)
+		So(err.Error(), ShouldEqual, "incomplete packet: 4 of 7 bytes")
+		_, _, err = ReadPacket([]byte{0xff, 0, 0, 0, 4, 0})
+		So(err, ShouldNotBeNil)
+		So(errors.Is(err, ErrIncomplete), ShouldBeFalse)
+	})
+}
+
+func TestKexInit(t *testing.T) {
+	Convey("Testing ParseKexInit() and Negotiate()", t, func() {
+		kexInit := func(lists ...string) []byte {
+			payload := append([]byte{MsgKexInit}, make([]byte, 16)...)
+			for _, list := range append(lists, "", "") { // languages
+				payload = append(payload, nameList(list)...)
+			}
+			return append(payload, 0, 0, 0, 0, 0)
+		}
+		server, err := ParseKexInit(kexInit(
+			"curve25519-sha256,diffie-hellman-group14-sha256", "ssh-ed25519,rsa-sha2-512",
+			"aes256-gcm@openssh.com,aes128-ctr", "aes128-ctr", "hmac-sha2-256", "hmac-sha2-256", "none", "none",
+		))
+		So(err, ShouldBeNil)
+		So(server.Kex, ShouldResemble, []string{"curve25519-sha256", "diffie-hellman-group14-sha256"})
+		So(server.CompressionServer, ShouldResemble, []string{"none"})
+
+		client := &KexInit{
+			Kex:               []string{"diffie-hellman-group14-sha256", "curve25519-sha256"},
+			HostKey:           []string{"rsa-sha2-512"},
+			CiphersClient:     []string{"aes256-gcm@openssh.com"},
+			CiphersServer:     []string{"chacha20-poly1305@openssh.com"},
+			MACsClient:        []string{"hmac-sha2-256"},
+			MACsServer:        []string{"hmac-sha2-256"},
+			CompressionClient: []string{"none"},
+			CompressionServer: []string{"zlib"},
+		}
+		So(Negotiate(client, server), ShouldResemble, &KexInit{
+			Kex:               []string{"diffie-hellman-group14-sha256"},
+			HostKey:           []string{"rsa-sha2-512"},
+			CiphersClient:     []string{"aes256-gcm@openssh.com"},
+			MACsClient:        []string{"<implicit>"},
+			MACsServer:        []string{"hmac-sha2-256"},
+			CompressionClient: []string{"none"},
+		})
+
+		_, err = ParseKexInit([]byte{MsgNewKeys})
+		So(err, ShouldNotBeNil)
+		_, err = ParseKexInit(kexInit("curve25519-sha256")[:25])
+		So(err, ShouldNotBeNil)
+		_, err = ParseKexInit(append([]byte{MsgNewKeys}, make([]byte, 40)...))
+		So(err, ShouldNotBeNil)
+	})
+}
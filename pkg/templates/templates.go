@@ -1,11 +1,15 @@
 package templates
 
 import (
+	"bytes"
 	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
 	"strings"
 	"text/template"
 
 	"github.com/Masterminds/sprig"
+	"moul.io/assh/v2/pkg/utils"
 )
 
 func funcMap() template.FuncMap {
@@ -39,3 +43,53 @@ func funcMap() template.FuncMap {
 func New(format string) (*template.Template, error) {
 	return template.New("").Funcs(funcMap()).Parse(format)
 }
+
+// NewFromFile creates a new template with funcMap from a template file.
+//
+// The sibling files sharing the same extension, if any, are parsed in the same set, so
+// their named templates can be used with `template` or `include`, i.e:
+// `{{include "header.tmpl" . | upper}}`.
+func NewFromFile(path string) (*template.Template, error) {
+	path, err := utils.ExpandUser(path)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl := template.New(filepath.Base(path))
+	funcs := funcMap()
+	funcs["include"] = func(name string, data interface{}) (string, error) {
+		var buff bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&buff, name, data); err != nil {
+			return "", err
+		}
+		return buff.String(), nil
+	}
+	tmpl = tmpl.Funcs(funcs)
+
+	// without an extension, the other files of the directory are not templates
+	siblings := []string{}
+	if ext := filepath.Ext(path); ext != "" {
+		if siblings, err = filepath.Glob(filepath.Join(filepath.Dir(path), "*"+ext)); err != nil {
+			return nil, err
+		}
+	}
+	for _, sibling := range siblings {
+		if sibling == path {
+			continue
+		}
+		siblingContent, err := ioutil.ReadFile(sibling)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tmpl.New(filepath.Base(sibling)).Parse(string(siblingContent)); err != nil {
+			return nil, err
+		}
+	}
+
+	return tmpl.Parse(string(content))
+}
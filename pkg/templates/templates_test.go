@@ -0,0 +1,48 @@
+package templates
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNewFromFile(t *testing.T) {
+	Convey("Testing NewFromFile()", t, func() {
+		dir, err := ioutil.TempDir("", "assh-templates")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		files := map[string]string{
+			"header.tmpl":     `{{define "signature"}}-- assh{{end}}Connection to {{.Name}}`,
+			"disconnect.tmpl": "{{include \"header.tmpl\" . | upper}} closed\n{{template \"signature\"}}\n",
+			"broken.txt":      "{{.Name",
+			"plain":           "Hello {{.Name}}\n",
+		}
+		for name, content := range files {
+			So(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600), ShouldBeNil)
+		}
+
+		tmpl, err := NewFromFile(filepath.Join(dir, "disconnect.tmpl"))
+		So(err, ShouldBeNil)
+		var buff bytes.Buffer
+		So(tmpl.Execute(&buff, struct{ Name string }{"localhost"}), ShouldBeNil)
+		So(buff.String(), ShouldEqual, "CONNECTION TO LOCALHOST closed\n-- assh\n")
+
+		_, err = NewFromFile(filepath.Join(dir, "broken.txt"))
+		So(err, ShouldNotBeNil)
+
+		// without an extension, the other files are not parsed
+		tmpl, err = NewFromFile(filepath.Join(dir, "plain"))
+		So(err, ShouldBeNil)
+		buff.Reset()
+		So(tmpl.Execute(&buff, struct{ Name string }{"localhost"}), ShouldBeNil)
+		So(buff.String(), ShouldEqual, "Hello localhost\n")
+
+		_, err = NewFromFile(filepath.Join(dir, "missing.tmpl"))
+		So(err, ShouldNotBeNil)
+	})
+}
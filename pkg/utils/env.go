@@ -2,6 +2,7 @@ package utils
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -26,21 +27,111 @@ func GetHomeDir() string {
 // ExpandEnvSafe replaces ${var} or $var in the string according to the values
 // of the current environment variables.
 // As opposed to os.ExpandEnv, ExpandEnvSafe won't remove the dollar in '$(...)'
+// The shell forms ${var:-default}, ${var:+alternate} and ${var:?message} are supported
+// and '$$' is a literal dollar; a missing required variable expands to an empty
+// string, see ValidateEnv.
 // See https://golang.org/src/os/env.go?s=963:994#L22 for the original function
 func ExpandEnvSafe(s string) string {
+	expanded, _ := expandEnv(s)
+	return expanded
+}
+
+// ValidateEnv returns an error when a variable required with ${var:?message} is unset or empty
+func ValidateEnv(s string) error {
+	_, err := expandEnv(s)
+	return err
+}
+
+func expandEnv(s string) (string, error) {
+	var firstErr error
 	buf := make([]byte, 0, 2*len(s))
 	i := 0
 	for j := 0; j < len(s); j++ {
-		// the following line is the only one changing
-		if s[j] == '$' && j+1 < len(s) && s[j+1] != '(' {
-			buf = append(buf, s[i:j]...)
-			name, w := getShellName(s[j+1:])
-			buf = append(buf, os.Getenv(name)...)
-			j += w
-			i = j + 1
+		if s[j] != '$' || j+1 == len(s) || s[j+1] == '(' {
+			continue
+		}
+		buf = append(buf, s[i:j]...)
+		var value string
+		var w int
+		switch end := closingBrace(s[j+1:]); {
+		case s[j+1] == '$':
+			value, w = "$", 1
+		case end > 0:
+			var err error
+			if value, err = expandParameter(s[j+2 : j+1+end]); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			w = end + 1
+		default:
+			var name string
+			name, w = getShellName(s[j+1:])
+			value = os.Getenv(name)
+		}
+		buf = append(buf, value...)
+		j += w
+		i = j + 1
+	}
+	return string(buf) + s[i:], firstErr
+}
+
+// closingBrace returns the index of the brace closing the one starting s, -1 otherwise
+func closingBrace(s string) int {
+	if s[0] != '{' {
+		return -1
+	}
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// expandParameter expands the content of ${...}, the words of the shell forms are expanded too
+func expandParameter(content string) (string, error) {
+	name := content
+	for i := 0; i < len(content); i++ {
+		if !isAlphaNum(content[i]) {
+			name = content[:i]
+			break
+		}
+	}
+	rest := content[len(name):]
+	if name == "" || len(rest) < 2 || rest[0] != ':' || !strings.ContainsRune("-+?", rune(rest[1])) {
+		return os.Getenv(content), nil
+	}
+
+	value, word := os.Getenv(name), rest[2:]
+	switch rest[1] {
+	case '-':
+		if value == "" {
+			return expandEnv(word)
+		}
+	case '+':
+		if value == "" {
+			return "", nil
+		}
+		return expandEnv(word)
+	case '?':
+		if value == "" {
+			message, err := expandEnv(word)
+			if err != nil {
+				return "", err
+			}
+			if message == "" {
+				message = "parameter null or not set"
+			}
+			return "", fmt.Errorf("%s: %s", name, message)
 		}
 	}
-	return string(buf) + s[i:]
+	return value, nil
 }
 
 // ExpandUser expands tild and env vars in unix paths
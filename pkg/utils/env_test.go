@@ -23,6 +23,56 @@ func TestExpandEnvSafe(t *testing.T) {
 		So(ExpandEnvSafe("/a/${FOO}/c/$FOO"), ShouldEqual, "/a/bar/c/bar")
 		So(ExpandEnvSafe("/a/$(FOO)/c"), ShouldEqual, "/a/$(FOO)/c")
 		So(ExpandEnvSafe(""), ShouldEqual, "")
+
+		So(os.Setenv("EMPTY", ""), ShouldBeNil)
+		So(os.Unsetenv("UNSET"), ShouldBeNil)
+		for _, tt := range []struct {
+			input    string
+			expected string
+		}{
+			{"${FOO:-default}", "bar"},
+			{"${EMPTY:-default}", "default"},
+			{"${UNSET:-default}", "default"},
+			{"${UNSET:-}", ""},
+			{"${UNSET:-$FOO-${FOO}}", "bar-bar"},
+			{"${UNSET:-${EMPTY:-nested}}", "nested"},
+			{"${UNSET:-$(hostname)}", "$(hostname)"},
+			{"${FOO:+alternate}", "alternate"},
+			{"${EMPTY:+alternate}", ""},
+			{"${UNSET:+alternate}", ""},
+			{"${FOO:?required}", "bar"},
+			{"${UNSET:?required}", ""},
+			{"$$FOO", "$FOO"},
+			{"$${FOO}", "${FOO}"},
+			{"$$$FOO", "$bar"},
+			{"cost: 5$", "cost: 5$"},
+			{"${FOO", "FOO"},
+		} {
+			So(ExpandEnvSafe(tt.input), ShouldEqual, tt.expected)
+		}
+	})
+}
+
+func TestValidateEnv(t *testing.T) {
+	Convey("Testing ValidateEnv", t, func() {
+		So(os.Setenv("FOO", "bar"), ShouldBeNil)
+		So(os.Setenv("EMPTY", ""), ShouldBeNil)
+		So(os.Unsetenv("UNSET"), ShouldBeNil)
+
+		So(ValidateEnv("$UNSET ${UNSET} ${UNSET:-default} ${FOO:?required}"), ShouldBeNil)
+		So(ValidateEnv("$${UNSET:?escaped}"), ShouldBeNil)
+
+		err := ValidateEnv("user-${UNSET:?set UNSET to your login}")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "UNSET: set UNSET to your login")
+
+		err = ValidateEnv("${EMPTY:?}")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "EMPTY: parameter null or not set")
+
+		err = ValidateEnv("${UNSET:-${EMPTY:?nested}}")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldEqual, "EMPTY: nested")
 	})
 }
 